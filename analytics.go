@@ -0,0 +1,211 @@
+package baidupush
+
+import (
+	"sort"
+	"time"
+)
+
+// secondsPerDay is the step between days of statistics.
+const secondsPerDay = 24 * 60 * 60
+
+// DeviceAverages are averages of daily device statistics over some days.
+type DeviceAverages struct {
+	NewUser     float64
+	LostUser    float64
+	Online      float64
+	NetGrowth   float64
+	ChurnRate   float64
+	OnlineRatio float64
+}
+
+// DailyDevices is the device statistics of a day with metrics derived.
+type DailyDevices struct {
+	DeviceStatistics
+	// Filled means the day is missing in the report, it is filled with no
+	// new, lost or online devices and the totals of the day before.
+	Filled bool
+	// NetGrowth is new devices less lost devices.
+	NetGrowth int
+	// ChurnRate is lost devices over the available devices of the day
+	// before, zero on the first day, which is left out of averages of churn
+	// rates.
+	ChurnRate float64
+	// OnlineRatio is online devices over the available devices.
+	OnlineRatio float64
+	// Rolling7 and Rolling30 average the last 7 and 30 days up to the day,
+	// or all days before if fewer.
+	Rolling7, Rolling30 DeviceAverages
+
+	// first means the day is the first of its trend, without a day before.
+	first bool
+}
+
+// DeviceTrend is daily device statistics ordered by day without gaps.
+type DeviceTrend struct {
+	Days []DailyDevices
+}
+
+// AnalyzeDeviceStatistics orders stats returned by ReportDeviceStatistics by
+// day, fills the days missing and derives the metrics of every day. The last
+// of duplicate days wins.
+func AnalyzeDeviceStatistics(stats []DeviceStatistics) DeviceTrend {
+	byDay := map[int64]DeviceStatistics{}
+	for _, s := range stats {
+		byDay[s.Day] = s
+	}
+	days := []int64{}
+	for day := range byDay {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+
+	trend := DeviceTrend{Days: []DailyDevices{}}
+	for i, day := range days {
+		if i > 0 {
+			prev := trend.Days[len(trend.Days)-1].DeviceStatistics
+			for gap := days[i-1] + secondsPerDay; gap < day; gap += secondsPerDay {
+				trend.add(DailyDevices{
+					DeviceStatistics: DeviceStatistics{Day: gap, AddedupTerm: prev.AddedupTerm, AvailChnID: prev.AvailChnID},
+					Filled:           true,
+				})
+			}
+		}
+		trend.add(DailyDevices{DeviceStatistics: byDay[day]})
+	}
+	return trend
+}
+
+// add appends d with its metrics derived.
+func (t *DeviceTrend) add(d DailyDevices) {
+	d.NetGrowth = d.DailyNewUser - d.DailyLostUser
+	if n := len(t.Days); n > 0 {
+		d.ChurnRate = ratio(d.DailyLostUser, t.Days[n-1].AvailChnID)
+	} else {
+		d.first = true
+	}
+	d.OnlineRatio = ratio(d.DailyOnline, d.AvailChnID)
+	t.Days = append(t.Days, d)
+	n := len(t.Days)
+	t.Days[n-1].Rolling7 = averageDays(t.Days[max(0, n-7):])
+	t.Days[n-1].Rolling30 = averageDays(t.Days[max(0, n-30):])
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+func averageDays(days []DailyDevices) DeviceAverages {
+	avg := DeviceAverages{}
+	if len(days) == 0 {
+		return avg
+	}
+	churnDays := 0
+	for _, d := range days {
+		avg.NewUser += float64(d.DailyNewUser)
+		avg.LostUser += float64(d.DailyLostUser)
+		avg.Online += float64(d.DailyOnline)
+		avg.NetGrowth += float64(d.NetGrowth)
+		avg.OnlineRatio += d.OnlineRatio
+		if !d.first {
+			avg.ChurnRate += d.ChurnRate
+			churnDays++
+		}
+	}
+	n := float64(len(days))
+	avg.NewUser /= n
+	avg.LostUser /= n
+	avg.Online /= n
+	avg.NetGrowth /= n
+	avg.OnlineRatio /= n
+	if churnDays > 0 {
+		avg.ChurnRate /= float64(churnDays)
+	}
+	return avg
+}
+
+// Between returns the days of t in [from, to).
+func (t DeviceTrend) Between(from, to time.Time) DeviceTrend {
+	period := DeviceTrend{Days: []DailyDevices{}}
+	for _, d := range t.Days {
+		if d.Day >= from.Unix() && d.Day < to.Unix() {
+			period.Days = append(period.Days, d)
+		}
+	}
+	return period
+}
+
+// DevicePeriod summarizes the device statistics of some days.
+type DevicePeriod struct {
+	// From and To are the first and the last days.
+	From, To int64
+	Days     int
+	NewUser  int
+	LostUser int
+	// NetGrowth is the new devices less the lost devices.
+	NetGrowth int
+	// StartDevices and EndDevices are the available devices of the first
+	// and the last days.
+	StartDevices, EndDevices int
+	// ChurnRate is the lost devices over the devices available at the start
+	// of the period.
+	ChurnRate float64
+	// Averages are the averages of the daily statistics.
+	Averages DeviceAverages
+}
+
+// Summary summarizes the days of t.
+func (t DeviceTrend) Summary() DevicePeriod {
+	p := DevicePeriod{Days: len(t.Days)}
+	if len(t.Days) == 0 {
+		return p
+	}
+	first, last := t.Days[0], t.Days[len(t.Days)-1]
+	p.From, p.To = first.Day, last.Day
+	p.StartDevices, p.EndDevices = first.AvailChnID, last.AvailChnID
+	for _, d := range t.Days {
+		p.NewUser += d.DailyNewUser
+		p.LostUser += d.DailyLostUser
+	}
+	p.NetGrowth = p.NewUser - p.LostUser
+	p.ChurnRate = ratio(p.LostUser, first.AvailChnID-first.NetGrowth)
+	p.Averages = averageDays(t.Days)
+	return p
+}
+
+// PeriodDiff compares two periods of device statistics, every change is of
+// After less Before.
+type PeriodDiff struct {
+	Before, After DevicePeriod
+	NewUser       int
+	LostUser      int
+	NetGrowth     int
+	EndDevices    int
+	ChurnRate     float64
+	Averages      DeviceAverages
+}
+
+// DiffPeriods compares period after with period before, such as this week
+// with the week before.
+func DiffPeriods(before, after DevicePeriod) PeriodDiff {
+	b, a := before.Averages, after.Averages
+	return PeriodDiff{
+		Before:     before,
+		After:      after,
+		NewUser:    after.NewUser - before.NewUser,
+		LostUser:   after.LostUser - before.LostUser,
+		NetGrowth:  after.NetGrowth - before.NetGrowth,
+		EndDevices: after.EndDevices - before.EndDevices,
+		ChurnRate:  after.ChurnRate - before.ChurnRate,
+		Averages: DeviceAverages{
+			NewUser:     a.NewUser - b.NewUser,
+			LostUser:    a.LostUser - b.LostUser,
+			Online:      a.Online - b.Online,
+			NetGrowth:   a.NetGrowth - b.NetGrowth,
+			ChurnRate:   a.ChurnRate - b.ChurnRate,
+			OnlineRatio: a.OnlineRatio - b.OnlineRatio,
+		},
+	}
+}
@@ -0,0 +1,53 @@
+package baidupush
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAnalyzeDeviceStatistics(t *testing.T) {
+	day0 := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+	day := func(i int) int64 { return day0 + int64(i)*secondsPerDay }
+	stats := []DeviceStatistics{
+		{Day: day(3), DailyNewUser: 20, DailyLostUser: 10, DailyOnline: 55, AvailChnID: 110},
+		{Day: day(0), DailyNewUser: 10, DailyLostUser: 0, DailyOnline: 50, AvailChnID: 100},
+		{Day: day(1), DailyNewUser: 5, DailyLostUser: 5, DailyOnline: 40, AvailChnID: 100},
+		{Day: day(1), DailyNewUser: 5, DailyLostUser: 5, DailyOnline: 40, AvailChnID: 100},
+	}
+	trend := AnalyzeDeviceStatistics(stats)
+	if len(trend.Days) != 4 {
+		t.Fatalf("days %+v", trend.Days)
+	}
+	for i, d := range trend.Days {
+		if d.Day != day(i) {
+			t.Errorf("day %d is %d", i, d.Day)
+		}
+	}
+	gap := trend.Days[2]
+	if !gap.Filled || gap.AvailChnID != 100 || gap.DailyNewUser != 0 {
+		t.Errorf("gap %+v", gap)
+	}
+	last := trend.Days[3]
+	if last.NetGrowth != 10 || last.ChurnRate != 0.1 || last.OnlineRatio != 0.5 {
+		t.Errorf("last day %+v", last)
+	}
+	// the first day without a day before is left out of the churn rate
+	if avg := last.Rolling7; avg.NewUser != 8.75 || avg.Online != 36.25 || math.Abs(avg.ChurnRate-0.05) > 1e-9 {
+		t.Errorf("rolling 7 %+v", avg)
+	}
+
+	week1 := trend.Between(time.Unix(day(0), 0), time.Unix(day(2), 0)).Summary()
+	week2 := trend.Between(time.Unix(day(2), 0), time.Unix(day(4), 0)).Summary()
+	if week1.Days != 2 || week1.NewUser != 15 || week1.NetGrowth != 10 || week1.StartDevices != 100 || week1.ChurnRate != 5.0/90 || week1.Averages.ChurnRate != 0.05 {
+		t.Errorf("week 1 %+v", week1)
+	}
+	diff := DiffPeriods(week1, week2)
+	if diff.NewUser != 5 || diff.LostUser != 5 || diff.EndDevices != 10 || math.Abs(diff.ChurnRate-(0.1-5.0/90)) > 1e-9 {
+		t.Errorf("diff %+v", diff)
+	}
+
+	if empty := AnalyzeDeviceStatistics(nil).Summary(); empty.Days != 0 {
+		t.Errorf("empty summary %+v", empty)
+	}
+}
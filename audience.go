@@ -0,0 +1,509 @@
+package baidupush
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// TagMembers provides the devices in tags, which the service counts but does
+// not list, so they are mirrored locally.
+type TagMembers interface {
+	// TagDevices returns channel IDs of devices in tag.
+	TagDevices(tag string) ([]string, error)
+	// AllDevices returns channel IDs of all devices known, which NOT excludes
+	// devices from.
+	AllDevices() ([]string, error)
+}
+
+// TagMirror is a TagMembers in memory, kept by managing tags through it. It is
+// safe for concurrent use.
+type TagMirror struct {
+	mu   sync.RWMutex
+	tags map[string]map[string]struct{}
+}
+
+// NewTagMirror returns an empty mirror.
+func NewTagMirror() *TagMirror {
+	return &TagMirror{tags: map[string]map[string]struct{}{}}
+}
+
+// Add mirrors channelIDs added to tag.
+func (m *TagMirror) Add(tag string, channelIDs ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := m.tags[tag]
+	if members == nil {
+		members = map[string]struct{}{}
+		m.tags[tag] = members
+	}
+	for _, id := range channelIDs {
+		members[id] = struct{}{}
+	}
+}
+
+// Remove mirrors channelIDs deleted from tag.
+func (m *TagMirror) Remove(tag string, channelIDs ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := m.tags[tag]
+	for _, id := range channelIDs {
+		delete(members, id)
+	}
+	if len(members) == 0 {
+		delete(m.tags, tag)
+	}
+}
+
+// AddTagDevices adds channelIDs to tag through bc by AddTagDevices in batches
+// of 10, and mirrors the devices added successfully.
+func (m *TagMirror) AddTagDevices(bc *Channel, tag string, channelIDs []string) ([]TagResult, error) {
+	return m.manage(bc.AddTagDevices, m.Add, tag, channelIDs)
+}
+
+// DeleteTagDevices deletes channelIDs from tag through bc by DeleteTagDevices
+// in batches of 10, and mirrors the devices deleted successfully.
+func (m *TagMirror) DeleteTagDevices(bc *Channel, tag string, channelIDs []string) ([]TagResult, error) {
+	return m.manage(bc.DeleteTagDevices, m.Remove, tag, channelIDs)
+}
+
+func (m *TagMirror) manage(call func(string, []string) ([]TagResult, error), mirror func(string, ...string), tag string, channelIDs []string) ([]TagResult, error) {
+	results := []TagResult{}
+	for _, chunk := range chunkStrings(channelIDs, 10) {
+		chunkResults, err := call(tag, chunk)
+		if err != nil {
+			return results, err
+		}
+		for _, result := range chunkResults {
+			if result.Res == 0 {
+				mirror(tag, result.ChnID)
+			}
+		}
+		results = append(results, chunkResults...)
+	}
+	return results, nil
+}
+
+// TagDevices implements TagMembers.
+func (m *TagMirror) TagDevices(tag string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := []string{}
+	for id := range m.tags[tag] {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// AllDevices implements TagMembers.
+func (m *TagMirror) AllDevices() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	all := map[string]struct{}{}
+	for _, members := range m.tags {
+		for id := range members {
+			all[id] = struct{}{}
+		}
+	}
+	ids := []string{}
+	for id := range all {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Audience is an expression over tags selecting devices, like
+// "vip AND beijing AND NOT churned". Operators are NOT, AND and OR in
+// precedence from high to low, case insensitive, grouped by parentheses. A tag
+// containing spaces, parentheses or quotes, or named like an operator, is
+// quoted as in Go like "\"new york\"", and * stands for all devices.
+type Audience struct {
+	expr audienceNode
+}
+
+// audienceNode is a node of the syntax tree of an Audience.
+type audienceNode struct {
+	op       string // tag, all, not, and, or
+	tag      string
+	children []audienceNode
+}
+
+// ParseAudience parses an audience expression.
+func ParseAudience(expr string) (*Audience, error) {
+	tokens, err := tokenizeAudience(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &audienceParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("audience %q: %v", expr, err)
+	}
+	return &Audience{expr: node}, nil
+}
+
+// String returns the expression of a, normalized.
+func (a *Audience) String() string {
+	return a.expr.String()
+}
+
+// Tags returns the tags a refers to, sorted.
+func (a *Audience) Tags() []string {
+	set := map[string]bool{}
+	a.expr.walk(func(n audienceNode) {
+		if n.op == "tag" {
+			set[n.tag] = true
+		}
+	})
+	tags := []string{}
+	for tag := range set {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Resolve returns the channel IDs of devices in a, sorted.
+func (a *Audience) Resolve(members TagMembers) ([]string, error) {
+	e := &audienceEval{members: members, tags: map[string]map[string]struct{}{}}
+	set, err := e.eval(a.expr)
+	if err != nil {
+		return nil, err
+	}
+	return sortedKeys(set), nil
+}
+
+// AudiencePreview previews the devices in an Audience.
+type AudiencePreview struct {
+	// Size is the number of devices in the audience.
+	Size int
+	// Tags are the numbers of devices mirrored in every tag referred to.
+	Tags map[string]int
+	// Batches is the number of PushMsgToBatchDevices calls to push to the
+	// audience.
+	Batches int
+}
+
+// Preview returns the size of a without pushing.
+func (a *Audience) Preview(members TagMembers) (AudiencePreview, error) {
+	ids, err := a.Resolve(members)
+	if err != nil {
+		return AudiencePreview{}, err
+	}
+	preview := AudiencePreview{
+		Size:    len(ids),
+		Tags:    map[string]int{},
+		Batches: (len(ids) + MaxBatchDevices - 1) / MaxBatchDevices,
+	}
+	for _, tag := range a.Tags() {
+		tagIDs, err := members.TagDevices(tag)
+		if err != nil {
+			return AudiencePreview{}, err
+		}
+		preview.Tags[tag] = len(tagIDs)
+	}
+	return preview, nil
+}
+
+// PushAudience pushes msg to devices in a resolved by members, by
+// PushMsgToBatchDevices in batches of MaxBatchDevices. opts are optional
+// parameters of every batch, msg_type defaults to MsgTypeNotice.
+//
+// The error returned joins errors of all failed batches.
+func (bc *Channel) PushAudience(a *Audience, members TagMembers, msg string, opts url.Values) ([]TemplateBatch, error) {
+	ids, err := a.Resolve(members)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []TemplateBatch{}, nil
+	}
+	return bc.pushBatches([]string{msg}, map[string][]string{msg: ids}, opts)
+}
+
+type audienceEval struct {
+	members TagMembers
+	tags    map[string]map[string]struct{}
+	all     map[string]struct{}
+}
+
+func (e *audienceEval) eval(n audienceNode) (map[string]struct{}, error) {
+	switch n.op {
+	case "tag":
+		if set, ok := e.tags[n.tag]; ok {
+			return set, nil
+		}
+		ids, err := e.members.TagDevices(n.tag)
+		if err != nil {
+			return nil, err
+		}
+		e.tags[n.tag] = toSet(ids)
+		return e.tags[n.tag], nil
+	case "all":
+		return e.allDevices()
+	case "not":
+		all, err := e.allDevices()
+		if err != nil {
+			return nil, err
+		}
+		excluded, err := e.eval(n.children[0])
+		if err != nil {
+			return nil, err
+		}
+		set := map[string]struct{}{}
+		for id := range all {
+			if _, ok := excluded[id]; !ok {
+				set[id] = struct{}{}
+			}
+		}
+		return set, nil
+	}
+
+	sets := make([]map[string]struct{}, len(n.children))
+	for i, child := range n.children {
+		set, err := e.eval(child)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	result := map[string]struct{}{}
+	if n.op == "or" {
+		for _, set := range sets {
+			for id := range set {
+				result[id] = struct{}{}
+			}
+		}
+		return result, nil
+	}
+	for id := range sets[0] {
+		in := true
+		for _, set := range sets[1:] {
+			if _, ok := set[id]; !ok {
+				in = false
+				break
+			}
+		}
+		if in {
+			result[id] = struct{}{}
+		}
+	}
+	return result, nil
+}
+
+func (e *audienceEval) allDevices() (map[string]struct{}, error) {
+	if e.all == nil {
+		ids, err := e.members.AllDevices()
+		if err != nil {
+			return nil, err
+		}
+		e.all = toSet(ids)
+	}
+	return e.all, nil
+}
+
+func toSet(ids []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (n audienceNode) walk(f func(audienceNode)) {
+	f(n)
+	for _, child := range n.children {
+		child.walk(f)
+	}
+}
+
+func (n audienceNode) String() string {
+	switch n.op {
+	case "tag":
+		if isPlainTag(n.tag) {
+			return n.tag
+		}
+		return fmt.Sprintf("%q", n.tag)
+	case "all":
+		return "*"
+	case "not":
+		child := n.children[0].String()
+		if op := n.children[0].op; op == "and" || op == "or" {
+			child = "(" + child + ")"
+		}
+		return "NOT " + child
+	}
+	parts := make([]string, len(n.children))
+	for i, child := range n.children {
+		parts[i] = child.String()
+		if n.op == "and" && child.op == "or" {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, " "+strings.ToUpper(n.op)+" ")
+}
+
+func isPlainTag(tag string) bool {
+	if tag == "" || isAudienceOperator(tag) {
+		return false
+	}
+	return strings.IndexFunc(tag, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`()"*`, r)
+	}) < 0
+}
+
+func isAudienceOperator(word string) bool {
+	switch strings.ToUpper(word) {
+	case "AND", "OR", "NOT":
+		return true
+	}
+	return false
+}
+
+type audienceToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeAudience(expr string) ([]audienceToken, error) {
+	tokens := []audienceToken{}
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, audienceToken{text: string(c)})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(expr) && expr[j] != '"' {
+				if expr[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(expr) {
+				return nil, errors.New("unterminated quoted tag")
+			}
+			tag, err := unquoteTag(expr[i : j+1])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, audienceToken{text: tag, quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t\n\r()\"", rune(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, audienceToken{text: expr[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func unquoteTag(s string) (string, error) {
+	var tag string
+	if _, err := fmt.Sscanf(s, "%q", &tag); err != nil {
+		return "", fmt.Errorf("bad quoted tag %s", s)
+	}
+	return tag, nil
+}
+
+type audienceParser struct {
+	tokens []audienceToken
+	pos    int
+}
+
+// keyword reports whether the next token is the operator op, and consumes it
+// if so.
+func (p *audienceParser) keyword(op string) bool {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *audienceParser) parseOr() (audienceNode, error) {
+	return p.parseBinary("or", p.parseAnd)
+}
+
+func (p *audienceParser) parseAnd() (audienceNode, error) {
+	return p.parseBinary("and", p.parseNot)
+}
+
+func (p *audienceParser) parseBinary(op string, operand func() (audienceNode, error)) (audienceNode, error) {
+	node, err := operand()
+	if err != nil {
+		return node, err
+	}
+	children := []audienceNode{node}
+	for p.keyword(op) {
+		node, err = operand()
+		if err != nil {
+			return node, err
+		}
+		children = append(children, node)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return audienceNode{op: op, children: children}, nil
+}
+
+func (p *audienceParser) parseNot() (audienceNode, error) {
+	if p.keyword("not") {
+		node, err := p.parseNot()
+		if err != nil {
+			return node, err
+		}
+		return audienceNode{op: "not", children: []audienceNode{node}}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *audienceParser) parsePrimary() (audienceNode, error) {
+	if p.pos >= len(p.tokens) {
+		return audienceNode{}, errors.New("unexpected end")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch {
+	case tok.quoted:
+		return audienceNode{op: "tag", tag: tok.text}, nil
+	case tok.text == "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return node, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].text != ")" || p.tokens[p.pos].quoted {
+			return node, errors.New("missing )")
+		}
+		p.pos++
+		return node, nil
+	case tok.text == "*":
+		return audienceNode{op: "all"}, nil
+	case tok.text == ")" || isAudienceOperator(tok.text):
+		return audienceNode{}, fmt.Errorf("unexpected %q", tok.text)
+	}
+	return audienceNode{op: "tag", tag: tok.text}, nil
+}
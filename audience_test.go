@@ -0,0 +1,84 @@
+package baidupush
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAudience(t *testing.T) {
+	cases := []struct{ expr, want string }{
+		{"vip and beijing and not churned", "vip AND beijing AND NOT churned"},
+		{"vip OR beijing AND shanghai", "vip OR beijing AND shanghai"},
+		{"(vip OR beijing) AND NOT (churned OR banned)", "(vip OR beijing) AND NOT (churned OR banned)"},
+		{`"new york" OR "and"`, `"new york" OR "and"`},
+		{"NOT NOT *", "NOT NOT *"},
+	}
+	for _, c := range cases {
+		a, err := ParseAudience(c.expr)
+		if err != nil {
+			t.Errorf("parse %q error %v", c.expr, err)
+			continue
+		}
+		if a.String() != c.want {
+			t.Errorf("parse %q got %q want %q", c.expr, a.String(), c.want)
+		}
+	}
+
+	for _, expr := range []string{"", "vip AND", "(vip", "vip)", "AND vip", `"vip`, "vip beijing"} {
+		if _, err := ParseAudience(expr); err == nil {
+			t.Errorf("audience %q parsed", expr)
+		}
+	}
+}
+
+func TestPushAudience(t *testing.T) {
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		if call.apiMethod == "add_devices" {
+			return map[string]interface{}{"result": []interface{}{
+				map[string]interface{}{"channel_id": "a", "result": 0},
+				map[string]interface{}{"channel_id": "b", "result": 0},
+				map[string]interface{}{"channel_id": "c", "result": 0},
+			}}, 0
+		}
+		return pushOK(call)
+	})
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+
+	mirror := NewTagMirror()
+	if _, err := mirror.AddTagDevices(bc, "vip", []string{"a", "b", "c"}); err != nil {
+		t.Fatal("add tag devices error", err)
+	}
+	mirror.Add("beijing", "a", "b", "d")
+	mirror.Add("churned", "b")
+	mirror.Add("new york", "e")
+
+	a, _ := ParseAudience(`vip AND beijing AND NOT churned OR "new york"`)
+	if tags := a.Tags(); !reflect.DeepEqual(tags, []string{"beijing", "churned", "new york", "vip"}) {
+		t.Errorf("tags %v", tags)
+	}
+	preview, err := a.Preview(mirror)
+	if err != nil {
+		t.Fatal("preview error", err)
+	}
+	want := AudiencePreview{Size: 2, Batches: 1, Tags: map[string]int{"beijing": 3, "churned": 1, "new york": 1, "vip": 3}}
+	if !reflect.DeepEqual(preview, want) {
+		t.Errorf("preview %+v", preview)
+	}
+	if len(srv.received()) != 1 {
+		t.Fatal("preview pushed")
+	}
+
+	batches, err := bc.PushAudience(a, mirror, "hello", nil)
+	if err != nil {
+		t.Fatal("push error", err)
+	}
+	calls := srv.received()
+	if len(batches) != 1 || calls[1].apiMethod != "batch_device" || calls[1].params.Get("channel_ids") != `["a","e"]` {
+		t.Errorf("batches %+v calls %+v", batches, calls)
+	}
+
+	all, _ := ParseAudience("NOT vip")
+	if ids, _ := all.Resolve(mirror); !reflect.DeepEqual(ids, []string{"d", "e"}) {
+		t.Errorf("NOT vip resolved %v", ids)
+	}
+}
@@ -3,18 +3,47 @@ package baidupush
 import (
 	"fmt"
 	"net/url"
+	"os"
+	"sync"
 	"testing"
 	"time"
 )
 
+// The tests below run against the live service with the default channel
+// loaded by LoadConfig from BAIDUPUSH_CONFIG or BAIDUPUSH_* environment
+// variables, and the device of BAIDUPUSH_TEST_CHANNEL_ID. They are skipped if
+// not configured.
 var (
-	channelID = "YOUR DEVICE CHANNELID HERE"
-	apiKey    = "YOUR API KEY HERE"
-	secret    = "YOUR API SECRET HERE"
-	channel   = NewChannelDefaultHost(apiKey, secret, AndroidDeviceType)
+	channelID = os.Getenv("BAIDUPUSH_TEST_CHANNEL_ID")
+	channel   *Channel
+	loadLive  sync.Once
+	liveErr   error
 )
 
+func requireLiveChannel(t *testing.T) {
+	t.Helper()
+	if os.Getenv("BAIDUPUSH_CONFIG") == "" && os.Getenv("BAIDUPUSH_API_KEY") == "" {
+		t.Skip("live service not configured, set BAIDUPUSH_CONFIG or BAIDUPUSH_API_KEY and BAIDUPUSH_SECRET")
+	}
+	if channelID == "" {
+		t.Skip("BAIDUPUSH_TEST_CHANNEL_ID not set")
+	}
+	loadLive.Do(func() {
+		var channels map[string]*Channel
+		if channels, liveErr = LoadConfig(""); liveErr == nil {
+			if channel = channels[DefaultChannelName]; channel == nil {
+				liveErr = fmt.Errorf("channel %s not configured", DefaultChannelName)
+			}
+		}
+	})
+	if liveErr != nil {
+		t.Fatal("load config error", liveErr)
+	}
+}
+
 func TestPushMsgToSingleDevice(t *testing.T) {
+	requireLiveChannel(t)
+
 	msg := `{
     "title": "hello",
     "description": "hello world",
@@ -37,6 +66,8 @@ func TestPushMsgToSingleDevice(t *testing.T) {
 }
 
 func TestPushMsgToAllDevice(t *testing.T) {
+	requireLiveChannel(t)
+
 	msg := `{
     "title": "hello",
     "description": "hello world",
@@ -59,6 +90,8 @@ func TestPushMsgToAllDevice(t *testing.T) {
 }
 
 func TestPushMsgToTagDevice(t *testing.T) {
+	requireLiveChannel(t)
+
 	msg := `{
     "title": "hello",
     "description": "hello world",
@@ -92,6 +125,8 @@ func TestPushMsgToTagDevice(t *testing.T) {
 }
 
 func TestPushMsgToBatchDevice(t *testing.T) {
+	requireLiveChannel(t)
+
 	msg := `{
     "title": "hello",
     "description": "hello world",
@@ -151,6 +186,8 @@ func TestPushMsgToBatchDevice(t *testing.T) {
 }
 
 func TestReportDeviceStat(t *testing.T) {
+	requireLiveChannel(t)
+
 	total, devStat, err := channel.ReportDeviceStatistics()
 	if err != nil {
 		t.Error("report device statistics error", err)
@@ -166,6 +203,8 @@ func TestReportDeviceStat(t *testing.T) {
 }
 
 func TestPushTimeMsg(t *testing.T) {
+	requireLiveChannel(t)
+
 	msg := `{
     "title": "hello",
     "description": "hello world",
@@ -218,6 +257,8 @@ func TestPushTimeMsg(t *testing.T) {
 }
 
 func TestTagManagement(t *testing.T) {
+	requireLiveChannel(t)
+
 	tag1, err := channel.CreateTag("tag1")
 	if err != nil {
 		t.Error("create tag1 error", err)
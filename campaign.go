@@ -0,0 +1,576 @@
+package baidupush
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AudienceKind is the kind of audience of a Campaign.
+type AudienceKind string
+
+const (
+	// AudienceAll is all devices, pushed to by PushMsgToAllDevices.
+	AudienceAll AudienceKind = "all"
+	// AudienceTag is devices in a tag, pushed to by PushMsgToTaggedDevices.
+	AudienceTag AudienceKind = "tag"
+	// AudienceDevices is a list of channel IDs, pushed to by
+	// PushMsgToBatchDevices.
+	AudienceDevices AudienceKind = "devices"
+	// AudienceUsers is the devices of users on the platform of the channel,
+	// looked up in a DeviceStore and pushed to by PushMsgToBatchDevices.
+	AudienceUsers AudienceKind = "users"
+)
+
+// CampaignAudience is who a Campaign is pushed to.
+type CampaignAudience struct {
+	Kind       AudienceKind `json:"kind"`
+	Tag        string       `json:"tag,omitempty"`
+	ChannelIDs []string     `json:"channel_ids,omitempty"`
+	UserIDs    []string     `json:"user_ids,omitempty"`
+	// App limits devices of users to those of an app, empty means any.
+	App string `json:"app,omitempty"`
+}
+
+// CampaignStatus is the status of a Campaign.
+type CampaignStatus string
+
+const (
+	// CampaignDraft means the campaign is not sent yet.
+	CampaignDraft CampaignStatus = "draft"
+	// CampaignSending means the campaign is being pushed, or was when the
+	// process stopped, it could be sent again.
+	CampaignSending CampaignStatus = "sending"
+	// CampaignScheduled means a timer task of the campaign is waiting.
+	CampaignScheduled CampaignStatus = "scheduled"
+	// CampaignSent means the campaign was pushed.
+	CampaignSent CampaignStatus = "sent"
+	// CampaignPartial means some batches of the campaign failed, sending it
+	// again pushes only those.
+	CampaignPartial CampaignStatus = "partial"
+	// CampaignFailed means the campaign failed to push, it could be sent
+	// again.
+	CampaignFailed CampaignStatus = "failed"
+	// CampaignCanceled means the timer task of the campaign was canceled.
+	CampaignCanceled CampaignStatus = "canceled"
+)
+
+// CampaignBatch is the channel IDs of a campaign to devices or users pushed
+// by one PushMsgToBatchDevices.
+type CampaignBatch struct {
+	ChannelIDs []string `json:"channel_ids"`
+	// MsgID is set once the batch is pushed.
+	MsgID string `json:"msg_id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Campaign is a message pushed to an audience, at once or at SendTime, with
+// all IDs returned by the service recorded.
+type Campaign struct {
+	ID       string           `json:"id"`
+	Msg      string           `json:"msg"`
+	Opts     url.Values       `json:"opts,omitempty"`
+	Audience CampaignAudience `json:"audience"`
+	// SendTime schedules a campaign to all or tagged devices by a timer
+	// task, zero means at once.
+	SendTime time.Time `json:"send_time"`
+	// TopicID groups the messages of a campaign to devices or users in
+	// reports.
+	TopicID string `json:"topic_id,omitempty"`
+
+	Status  CampaignStatus `json:"status"`
+	MsgIDs  []string       `json:"msg_ids,omitempty"`
+	TimerID string         `json:"timer_id,omitempty"`
+	// Batches are the batches of a campaign to devices or users, split on
+	// the first send and kept for sending again.
+	Batches []CampaignBatch `json:"batches,omitempty"`
+	// Devices is the number of devices pushed to by batches.
+	Devices int       `json:"devices,omitempty"`
+	Errors  []string  `json:"errors,omitempty"`
+	Created time.Time `json:"created"`
+	Sent    time.Time `json:"sent"`
+}
+
+func (c *Campaign) validate() error {
+	if c.ID == "" || c.Msg == "" {
+		return errors.New("campaign ID and msg are required")
+	}
+	a := c.Audience
+	switch {
+	case a.Kind == AudienceAll:
+	case a.Kind == AudienceTag && a.Tag != "":
+	case a.Kind == AudienceDevices && len(a.ChannelIDs) > 0:
+	case a.Kind == AudienceUsers && len(a.UserIDs) > 0:
+	default:
+		return fmt.Errorf("campaign %s: bad audience %+v", c.ID, a)
+	}
+	batch := a.Kind == AudienceDevices || a.Kind == AudienceUsers
+	if batch && !c.SendTime.IsZero() {
+		return fmt.Errorf("campaign %s: only campaigns to all or tagged devices could be scheduled", c.ID)
+	}
+	if !batch && c.TopicID != "" {
+		return fmt.Errorf("campaign %s: only campaigns to devices or users could have topics", c.ID)
+	}
+	return nil
+}
+
+// CampaignManager sends campaigns through a channel and records them in a
+// JSON file, replaced atomically on every change. It is safe for concurrent
+// use.
+type CampaignManager struct {
+	// Timers creates the timer tasks of scheduled campaigns, required to
+	// send them.
+	Timers *TimerManager
+	// Devices looks up devices of users, required to send campaigns to
+	// users.
+	Devices DeviceStore
+
+	mu        sync.Mutex
+	path      string
+	channel   *Channel
+	campaigns map[string]*Campaign
+	sending   map[string]bool
+	now       func() time.Time
+}
+
+// OpenCampaignManager opens the campaigns recorded in path, which is created
+// on the first change if not existing, to send them through bc.
+func OpenCampaignManager(path string, bc *Channel) (*CampaignManager, error) {
+	m := &CampaignManager{
+		path:      path,
+		channel:   bc,
+		campaigns: map[string]*Campaign{},
+		sending:   map[string]bool{},
+		now:       time.Now,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	campaigns := []*Campaign{}
+	if err = json.Unmarshal(data, &campaigns); err != nil {
+		return nil, fmt.Errorf("campaigns %s: %v", path, err)
+	}
+	for _, c := range campaigns {
+		m.campaigns[c.ID] = c
+	}
+	return m, nil
+}
+
+func (m *CampaignManager) save() error {
+	ids := []string{}
+	for id := range m.campaigns {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	campaigns := []*Campaign{}
+	for _, id := range ids {
+		campaigns = append(campaigns, m.campaigns[id])
+	}
+	data, err := json.MarshalIndent(campaigns, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.path, data)
+}
+
+// Create records c as a draft, the results of c are ignored.
+func (m *CampaignManager) Create(c Campaign) (Campaign, error) {
+	if err := c.validate(); err != nil {
+		return Campaign{}, err
+	}
+	c = Campaign{
+		ID:       c.ID,
+		Msg:      c.Msg,
+		Opts:     c.Opts,
+		Audience: c.Audience,
+		SendTime: c.SendTime,
+		TopicID:  c.TopicID,
+		Status:   CampaignDraft,
+		Created:  m.now(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.campaigns[c.ID]; ok {
+		return Campaign{}, fmt.Errorf("campaign %s exists", c.ID)
+	}
+	m.campaigns[c.ID] = &c
+	if err := m.save(); err != nil {
+		delete(m.campaigns, c.ID)
+		return Campaign{}, err
+	}
+	return c, nil
+}
+
+// Campaign returns the campaign of id, or false if not found.
+func (m *CampaignManager) Campaign(id string) (Campaign, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.campaigns[id]
+	if !ok {
+		return Campaign{}, false
+	}
+	return *c, true
+}
+
+// Campaigns returns all campaigns ordered by ID.
+func (m *CampaignManager) Campaigns() []Campaign {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	campaigns := []Campaign{}
+	for _, c := range m.campaigns {
+		campaigns = append(campaigns, *c)
+	}
+	sort.Slice(campaigns, func(i, j int) bool { return campaigns[i].ID < campaigns[j].ID })
+	return campaigns
+}
+
+// update saves the campaign of c.ID replaced by c.
+func (m *CampaignManager) update(c Campaign) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old := m.campaigns[c.ID]
+	m.campaigns[c.ID] = &c
+	if err := m.save(); err != nil {
+		m.campaigns[c.ID] = old
+		return err
+	}
+	return nil
+}
+
+// Send pushes the campaign of id, a draft, a failed or a partial one, or one
+// left sending by a process stopped. A campaign with SendTime is scheduled
+// by a timer task of Timers labeled by its ID. The error returned is of
+// failing to push at all or to record the campaign, the errors of some
+// batches failed are recorded in Errors.
+//
+// The campaign is recorded sending before the first push, and every push is
+// made with an idempotency key of the campaign ID and the batch, so that
+// sending again, such as a partial campaign, skips the batches pushed within
+// the window of the dedup store of the channel.
+func (m *CampaignManager) Send(id string) (Campaign, error) {
+	m.mu.Lock()
+	stored, ok := m.campaigns[id]
+	if !ok {
+		m.mu.Unlock()
+		return Campaign{}, fmt.Errorf("campaign %s not found", id)
+	}
+	c := *stored
+	switch {
+	case m.sending[id]:
+		m.mu.Unlock()
+		return c, fmt.Errorf("campaign %s is being sent", id)
+	case c.Status != CampaignDraft && c.Status != CampaignFailed && c.Status != CampaignPartial && c.Status != CampaignSending:
+		m.mu.Unlock()
+		return c, fmt.Errorf("campaign %s is %s", id, c.Status)
+	}
+	m.sending[id] = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.sending, id)
+		m.mu.Unlock()
+	}()
+
+	if len(c.Batches) == 0 {
+		c.MsgIDs, c.TimerID, c.Devices = nil, "", 0
+	}
+	c.Errors = nil
+	err := m.split(&c)
+	if err == nil {
+		c.Status = CampaignSending
+		if err = m.update(c); err != nil {
+			return *stored, err
+		}
+		err = m.push(&c)
+	}
+	if err != nil {
+		c.Status = CampaignFailed
+		c.Errors = append(c.Errors, err.Error())
+	}
+	c.Sent = m.now()
+	if saveErr := m.update(c); err == nil {
+		err = saveErr
+	}
+	return c, err
+}
+
+// split splits the devices of a campaign to devices or users into batches,
+// unless split by a former send.
+func (m *CampaignManager) split(c *Campaign) error {
+	a := c.Audience
+	if (a.Kind != AudienceDevices && a.Kind != AudienceUsers) || len(c.Batches) > 0 {
+		return nil
+	}
+
+	channelIDs := a.ChannelIDs
+	if a.Kind == AudienceUsers {
+		ids, err := m.userDevices(a.App, a.UserIDs)
+		if err != nil {
+			return err
+		}
+		channelIDs = ids
+	}
+	if len(channelIDs) == 0 {
+		return errors.New("no devices to push to")
+	}
+	for _, chunk := range chunkStrings(channelIDs, MaxBatchDevices) {
+		c.Batches = append(c.Batches, CampaignBatch{ChannelIDs: chunk})
+	}
+	return nil
+}
+
+func (m *CampaignManager) push(c *Campaign) error {
+	a := c.Audience
+	switch a.Kind {
+	case AudienceAll, AudienceTag:
+		if !c.SendTime.IsZero() {
+			return m.schedule(c)
+		}
+		req := PushRequest{Kind: PushAll, Msg: c.Msg, Opts: c.Opts, IdempotencyKey: "campaign/" + c.ID}
+		if a.Kind == AudienceTag {
+			req.Kind, req.Tag = PushTag, a.Tag
+		}
+		result, err := m.channel.Push(req)
+		if err != nil {
+			return err
+		}
+		c.MsgIDs, c.Status = []string{result.MsgID}, CampaignSent
+		return nil
+	}
+
+	opts := url.Values{}
+	for k, v := range c.Opts {
+		opts[k] = v
+	}
+	if opts.Get("msg_type") == "" {
+		opts.Set("msg_type", strconv.Itoa(MsgTypeNotice))
+	}
+	if c.TopicID != "" {
+		opts.Set("topic_id", c.TopicID)
+	}
+	for i := range c.Batches {
+		batch := &c.Batches[i]
+		if batch.MsgID != "" {
+			continue
+		}
+		result, err := m.channel.Push(PushRequest{
+			Kind:           PushBatch,
+			ChannelIDs:     batch.ChannelIDs,
+			Msg:            c.Msg,
+			Opts:           opts,
+			IdempotencyKey: fmt.Sprintf("campaign/%s/%d", c.ID, i),
+		})
+		batch.Error = ""
+		if err != nil {
+			batch.Error = err.Error()
+			c.Errors = append(c.Errors, batch.Error)
+			continue
+		}
+		batch.MsgID = result.MsgID
+	}
+
+	c.MsgIDs, c.Devices = nil, 0
+	for _, batch := range c.Batches {
+		if batch.MsgID != "" {
+			c.MsgIDs = append(c.MsgIDs, batch.MsgID)
+			c.Devices += len(batch.ChannelIDs)
+		}
+	}
+	switch {
+	case len(c.Errors) == 0:
+		c.Status = CampaignSent
+	case len(c.MsgIDs) > 0:
+		c.Status = CampaignPartial
+	default:
+		c.Status = CampaignFailed
+	}
+	return nil
+}
+
+func (m *CampaignManager) schedule(c *Campaign) error {
+	if m.Timers == nil {
+		return errors.New("no timer manager to schedule")
+	}
+	// a timer task created by a send interrupted before being recorded
+	for _, record := range m.Timers.Timers(c.ID) {
+		if record.Status == TimerScheduled && record.SendTime.Equal(time.Unix(c.SendTime.Unix(), 0)) {
+			c.MsgIDs, c.TimerID, c.Status = []string{record.MsgID}, record.TimerID, CampaignScheduled
+			return nil
+		}
+	}
+	var record TimerRecord
+	var err error
+	if c.Audience.Kind == AudienceTag {
+		record, err = m.Timers.PushTag(c.ID, c.Audience.Tag, c.Msg, c.SendTime, c.Opts)
+	} else {
+		record, err = m.Timers.PushAll(c.ID, c.Msg, c.SendTime, c.Opts)
+	}
+	if err != nil {
+		return err
+	}
+	c.MsgIDs, c.TimerID, c.Status = []string{record.MsgID}, record.TimerID, CampaignScheduled
+	return nil
+}
+
+// userDevices returns channel IDs of live devices of users on the platform of
+// the channel, of app unless empty.
+func (m *CampaignManager) userDevices(app string, userIDs []string) ([]string, error) {
+	if m.Devices == nil {
+		return nil, errors.New("no device store to look up users")
+	}
+	platform := devicePlatform(m.channel.deviceType)
+	ids := []string{}
+	for _, userID := range userIDs {
+		devices, err := m.Devices.Devices(userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range devices {
+			if d.Platform == platform && d.DeadSince == nil && (app == "" || d.App == app) {
+				ids = append(ids, d.ChannelID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// Status returns the status of the campaign of id, a scheduled one becomes
+// sent once its timer task is recorded executed by Timers, such as after
+// Timers.Reconcile.
+func (m *CampaignManager) Status(id string) (CampaignStatus, error) {
+	c, ok := m.Campaign(id)
+	if !ok {
+		return "", fmt.Errorf("campaign %s not found", id)
+	}
+	if c.Status != CampaignScheduled || m.Timers == nil {
+		return c.Status, nil
+	}
+	for _, record := range m.Timers.Timers(c.ID) {
+		if record.TimerID != c.TimerID {
+			continue
+		}
+		switch record.Status {
+		case TimerExecuting, TimerExecuted:
+			c.Status = CampaignSent
+		case TimerCanceled:
+			c.Status = CampaignCanceled
+		default:
+			return c.Status, nil
+		}
+		return c.Status, m.update(c)
+	}
+	return c.Status, nil
+}
+
+// Cancel cancels the timer task of the scheduled campaign of id by Timers,
+// a task already executing or executed makes the campaign sent instead.
+func (m *CampaignManager) Cancel(id string) (Campaign, error) {
+	c, ok := m.Campaign(id)
+	if !ok {
+		return Campaign{}, fmt.Errorf("campaign %s not found", id)
+	}
+	m.mu.Lock()
+	sending := m.sending[id]
+	m.mu.Unlock()
+	if sending {
+		return c, fmt.Errorf("campaign %s is being sent", id)
+	}
+
+	switch c.Status {
+	case CampaignDraft:
+		c.Status = CampaignCanceled
+		return c, m.update(c)
+	case CampaignScheduled:
+	default:
+		return c, fmt.Errorf("campaign %s is %s", id, c.Status)
+	}
+	if m.Timers == nil {
+		return c, errors.New("no timer manager to cancel")
+	}
+
+	record, err := m.Timers.Cancel(c.TimerID)
+	if err != nil {
+		return c, err
+	}
+	switch record.Status {
+	case TimerCanceled:
+		c.Status = CampaignCanceled
+	case TimerExecuting, TimerExecuted:
+		c.Status = CampaignSent
+	}
+	return c, m.update(c)
+}
+
+// CampaignReport reports how a campaign did.
+type CampaignReport struct {
+	Campaign Campaign
+	// Messages are the reports of QueryMsgStatus of the messages pushed.
+	Messages []MessageResult
+	// TimerRecords are all pages of QueryTimerRecords of the timer task.
+	TimerRecords []MessageResult
+	// Topic is the acks by day reported by ReportTopicStatistics.
+	Topic []TopicStatistics
+	// Success is the number of messages arrived.
+	Success int
+	// Acks is the number of messages acked under the topic.
+	Acks int
+}
+
+// Report reports the campaign of id from QueryMsgStatus of its messages,
+// QueryTimerRecords of its timer task and ReportTopicStatistics of its topic.
+func (m *CampaignManager) Report(id string) (CampaignReport, error) {
+	c, ok := m.Campaign(id)
+	if !ok {
+		return CampaignReport{}, fmt.Errorf("campaign %s not found", id)
+	}
+	report := CampaignReport{Campaign: c, Messages: []MessageResult{}}
+
+	for _, chunk := range chunkStrings(c.MsgIDs, 10) {
+		msgID := chunk[0]
+		if len(chunk) > 1 {
+			data, _ := json.Marshal(chunk)
+			msgID = string(data)
+		}
+		_, results, err := m.channel.QueryMsgStatus(msgID)
+		if err != nil {
+			return report, fmt.Errorf("campaign %s: %w", id, err)
+		}
+		report.Messages = append(report.Messages, results...)
+	}
+	for _, result := range report.Messages {
+		report.Success += result.Success
+	}
+
+	if c.TimerID != "" {
+		records, err := m.channel.timerRecords(c.TimerID)
+		if err != nil {
+			return report, fmt.Errorf("campaign %s: %w", id, err)
+		}
+		report.TimerRecords = records
+	}
+
+	if c.TopicID != "" {
+		_, days, err := m.channel.ReportTopicStatistics(c.TopicID)
+		if err != nil {
+			return report, fmt.Errorf("campaign %s: %w", id, err)
+		}
+		sort.Slice(days, func(i, j int) bool { return days[i].Day < days[j].Day })
+		report.Topic = days
+		for _, day := range days {
+			report.Acks += day.Ack
+		}
+	}
+	return report, nil
+}
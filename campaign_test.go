@@ -0,0 +1,156 @@
+package baidupush
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCampaignManager(t *testing.T) {
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		switch call.apiClass + "/" + call.apiMethod {
+		case "push/tags":
+			return map[string]interface{}{"msg_id": "msg-timer", "timer_id": "timer-1", "send_time": call.params.Get("send_time")}, 0
+		case "push/batch_device":
+			if call.params.Get("channel_ids") == `["broken"]` {
+				return nil, 30602
+			}
+		case "timer/cancel":
+			return map[string]interface{}{}, 0
+		case "report/query_msg_status":
+			return map[string]interface{}{"total_num": 1, "result": []interface{}{
+				map[string]interface{}{"msg_id": call.params.Get("msg_id"), "status": 0, "send_time": 1709251200, "success": 2},
+			}}, 0
+		case "report/statistic_topic":
+			return map[string]interface{}{"total_num": 1, "result": map[string]interface{}{
+				"1709251200": map[string]interface{}{"ack": 1},
+			}}, 0
+		}
+		return pushOK(call)
+	})
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+
+	dir := t.TempDir()
+	m, err := OpenCampaignManager(filepath.Join(dir, "campaigns.json"), bc)
+	if err != nil {
+		t.Fatal("open campaigns error", err)
+	}
+	m.Timers, err = OpenTimerManager(filepath.Join(dir, "timers.log"), bc)
+	if err != nil {
+		t.Fatal("open timers error", err)
+	}
+	defer m.Timers.Close()
+	devices := NewMemoryDeviceStore()
+	devices.Register(Device{UserID: "alice", ChannelID: "a1", Platform: Android, App: "shop"})
+	devices.Register(Device{UserID: "alice", ChannelID: "a2", Platform: IOS, App: "shop"})
+	devices.Register(Device{UserID: "bob", ChannelID: "b1", Platform: Android, App: "shop"})
+	devices.Register(Device{UserID: "bob", ChannelID: "b2", Platform: Android, App: "blog"})
+	m.Devices = devices
+
+	bad := []Campaign{
+		{ID: "x", Msg: "m", Audience: CampaignAudience{Kind: AudienceTag}},
+		{ID: "x", Msg: "m", Audience: CampaignAudience{Kind: AudienceDevices, ChannelIDs: []string{"a"}}, SendTime: time.Now().Add(time.Hour)},
+		{ID: "x", Msg: "m", Audience: CampaignAudience{Kind: AudienceAll}, TopicID: "t"},
+	}
+	for _, c := range bad {
+		if _, err := m.Create(c); err == nil {
+			t.Errorf("campaign %+v created", c)
+		}
+	}
+
+	users, err := m.Create(Campaign{ID: "users", Msg: "hi", TopicID: "spring", Audience: CampaignAudience{Kind: AudienceUsers, UserIDs: []string{"alice", "bob"}, App: "shop"}})
+	if err != nil || users.Status != CampaignDraft {
+		t.Fatal("create campaign error", err)
+	}
+	if _, err := m.Create(users); err == nil {
+		t.Error("duplicate campaign created")
+	}
+	users, err = m.Send("users")
+	if err != nil || users.Status != CampaignSent || users.Devices != 2 || len(users.MsgIDs) != 1 {
+		t.Fatalf("send users campaign %+v error %v", users, err)
+	}
+	call := srv.received()[0]
+	if call.params.Get("channel_ids") != `["a1","b1"]` || call.params.Get("topic_id") != "spring" {
+		t.Errorf("users campaign pushed %v", call.params)
+	}
+	if _, err := m.Send("users"); err == nil {
+		t.Error("campaign sent twice")
+	}
+
+	report, err := m.Report("users")
+	if err != nil {
+		t.Fatal("report error", err)
+	}
+	if report.Success != 2 || report.Acks != 1 || len(report.Messages) != 1 {
+		t.Errorf("report %+v", report)
+	}
+
+	broken, _ := m.Create(Campaign{ID: "broken", Msg: "hi", Audience: CampaignAudience{Kind: AudienceDevices, ChannelIDs: []string{"broken"}}})
+	if broken, _ = m.Send(broken.ID); broken.Status != CampaignFailed || len(broken.Errors) != 1 {
+		t.Errorf("broken campaign %+v", broken)
+	}
+
+	sale, _ := m.Create(Campaign{ID: "sale", Msg: "sale", SendTime: time.Now().Add(time.Hour), Audience: CampaignAudience{Kind: AudienceTag, Tag: "vip"}})
+	if sale, err = m.Send(sale.ID); err != nil || sale.Status != CampaignScheduled || sale.TimerID != "timer-1" {
+		t.Fatalf("schedule campaign %+v error %v", sale, err)
+	}
+	if status, _ := m.Status("sale"); status != CampaignScheduled {
+		t.Errorf("status %s", status)
+	}
+	if sale, err = m.Cancel("sale"); err != nil || sale.Status != CampaignCanceled {
+		t.Errorf("cancel campaign %+v error %v", sale, err)
+	}
+
+	reopened, err := OpenCampaignManager(filepath.Join(dir, "campaigns.json"), bc)
+	if err != nil {
+		t.Fatal("reopen error", err)
+	}
+	campaigns := reopened.Campaigns()
+	if len(campaigns) != 3 || campaigns[1].ID != "sale" || campaigns[1].Status != CampaignCanceled || campaigns[2].MsgIDs[0] != users.MsgIDs[0] {
+		t.Errorf("reopened campaigns %+v", campaigns)
+	}
+}
+
+func TestCampaignResendPartial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "campaigns.json")
+	broken := true
+	var m *CampaignManager
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		if c, _ := m.Campaign("big"); c.Status != CampaignSending {
+			t.Errorf("campaign %s while pushing want sending", c.Status)
+		}
+		if reopened, err := OpenCampaignManager(path, nil); err != nil || reopened.Campaigns()[0].Status != CampaignSending {
+			t.Error("sending campaign not recorded before pushing")
+		}
+		if broken && call.params.Get("channel_ids") == `["broken"]` {
+			return nil, 30602
+		}
+		return pushOK(call)
+	})
+	m, _ = OpenCampaignManager(path, NewChannel(srv.host(), "key", "secret", AndroidDeviceType))
+
+	ids := []string{}
+	for i := 0; i < MaxBatchDevices; i++ {
+		ids = append(ids, fmt.Sprintf("c%05d", i))
+	}
+	ids = append(ids, "broken")
+	m.Create(Campaign{ID: "big", Msg: "hi", Audience: CampaignAudience{Kind: AudienceDevices, ChannelIDs: ids}})
+
+	c, err := m.Send("big")
+	if err != nil || c.Status != CampaignPartial || len(c.Batches) != 2 || len(c.MsgIDs) != 1 || c.Devices != MaxBatchDevices || len(c.Errors) != 1 {
+		t.Fatalf("campaign %s %v %d devices errors %v error %v want partial", c.Status, c.MsgIDs, c.Devices, c.Errors, err)
+	}
+
+	broken = false
+	if c, err = m.Send("big"); err != nil || c.Status != CampaignSent || len(c.MsgIDs) != 2 || c.Devices != len(ids) || len(c.Errors) != 0 {
+		t.Fatalf("resent campaign %s %v %d devices errors %v error %v want sent", c.Status, c.MsgIDs, c.Devices, c.Errors, err)
+	}
+	calls := srv.received()
+	if len(calls) != 3 || calls[2].params.Get("channel_ids") != `["broken"]` {
+		t.Errorf("%d pushes want the failed batch pushed again only", len(calls))
+	}
+	if _, err = m.Send("big"); err == nil {
+		t.Error("sent campaign sent again")
+	}
+}
@@ -0,0 +1,220 @@
+package baidupush
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrFrequencyCapped is returned for a push suppressed by FrequencyCap.
+var ErrFrequencyCapped = errors.New("frequency cap reached")
+
+// CounterStore counts pushes by key within rolling windows, for FrequencyCap.
+// A store shared by processes lets them share budgets.
+type CounterStore interface {
+	// Take counts a push of key at t if fewer than limit pushes were counted
+	// within window before t, and reports whether it was counted.
+	Take(key string, limit int, window time.Duration, t time.Time) (bool, error)
+	// Release uncounts the push of key counted at t, such as a push failed.
+	Release(key string, t time.Time) error
+}
+
+// MemoryCounterStore is a CounterStore in memory keeping the time of every
+// push within the window. It is safe for concurrent use.
+type MemoryCounterStore struct {
+	mu     sync.Mutex
+	pushes map[string][]time.Time
+}
+
+// NewMemoryCounterStore returns an empty store.
+func NewMemoryCounterStore() *MemoryCounterStore {
+	return &MemoryCounterStore{pushes: map[string][]time.Time{}}
+}
+
+// Take implements CounterStore.
+func (s *MemoryCounterStore) Take(key string, limit int, window time.Duration, t time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pushes := s.pushes[key]
+	since := t.Add(-window)
+	i := 0
+	for i < len(pushes) && !pushes[i].After(since) {
+		i++
+	}
+	pushes = pushes[i:]
+	if len(pushes) >= limit {
+		s.set(key, pushes)
+		return false, nil
+	}
+	s.set(key, append(pushes, t))
+	return true, nil
+}
+
+// Release implements CounterStore.
+func (s *MemoryCounterStore) Release(key string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pushes := s.pushes[key]
+	for i := len(pushes) - 1; i >= 0; i-- {
+		if pushes[i].Equal(t) {
+			s.set(key, append(pushes[:i:i], pushes[i+1:]...))
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryCounterStore) set(key string, pushes []time.Time) {
+	if len(pushes) == 0 {
+		delete(s.pushes, key)
+		return
+	}
+	s.pushes[key] = pushes
+}
+
+// CapLimit allows Max pushes to a user within a rolling Window.
+type CapLimit struct {
+	Max    int
+	Window time.Duration
+}
+
+// FrequencyCap suppresses pushes to users who got too many of them lately,
+// each category of pushes, such as a topic or "marketing", has its own budget
+// and limit. It is safe for concurrent use.
+type FrequencyCap struct {
+	mu     sync.Mutex
+	store  CounterStore
+	limits map[string]CapLimit
+	now    func() time.Time
+}
+
+// NewFrequencyCap returns a cap counting pushes in store, limited by limit
+// in categories without limits of their own.
+func NewFrequencyCap(store CounterStore, limit CapLimit) *FrequencyCap {
+	return &FrequencyCap{
+		store:  store,
+		limits: map[string]CapLimit{"": limit},
+		now:    time.Now,
+	}
+}
+
+// SetLimit sets the limit of category.
+func (f *FrequencyCap) SetLimit(category string, limit CapLimit) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.limits[category] = limit
+}
+
+func (f *FrequencyCap) limit(category string) CapLimit {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if limit, ok := f.limits[category]; ok {
+		return limit
+	}
+	return f.limits[""]
+}
+
+func capKey(category, userID string) string {
+	return category + "\x00" + userID
+}
+
+// take takes a push of category from the budget of user at t.
+func (f *FrequencyCap) take(category, userID string, t time.Time) (bool, error) {
+	limit := f.limit(category)
+	return f.store.Take(capKey(category, userID), limit.Max, limit.Window, t)
+}
+
+// PushSingle pushes msg through bc to device by PushMsgToSingleDevice unless
+// its user reached the cap of category, in which case ErrFrequencyCapped is
+// returned. A failed push does not count.
+func (f *FrequencyCap) PushSingle(bc *Channel, device Device, category, msg string, opts url.Values) (PushResult, error) {
+	now := f.now()
+	ok, err := f.take(category, device.UserID, now)
+	if err != nil {
+		return PushResult{}, err
+	}
+	if !ok {
+		return PushResult{}, ErrFrequencyCapped
+	}
+
+	result, err := bc.Push(PushRequest{Kind: PushSingle, ChannelID: device.ChannelID, Msg: msg, Opts: opts})
+	if err != nil {
+		f.store.Release(capKey(category, device.UserID), now)
+	}
+	return result, err
+}
+
+// CappedPush is the outcome of FrequencyCap.PushBatch.
+type CappedPush struct {
+	// Batches are the calls of PushMsgToBatchDevices.
+	Batches []TemplateBatch
+	// Suppressed are the users who reached the cap, ordered by ID.
+	Suppressed []string
+}
+
+// PushBatch pushes msg through bc to devices of users under the cap of
+// category by PushMsgToBatchDevices in batches of MaxBatchDevices, a user
+// counts one push however many devices. Pushes of failed batches do not
+// count. opts are optional parameters of every batch, msg_type defaults to
+// MsgTypeNotice.
+//
+// The error returned joins errors of all failed batches and errors of store.
+func (f *FrequencyCap) PushBatch(bc *Channel, devices []Device, category, msg string, opts url.Values) (CappedPush, error) {
+	now := f.now()
+	capped := CappedPush{}
+	decided := map[string]bool{}
+	users := map[string]string{}
+	channelIDs := []string{}
+	errs := []error{}
+	for _, device := range devices {
+		allowed, seen := decided[device.UserID]
+		if !seen {
+			ok, err := f.take(category, device.UserID, now)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			allowed = ok
+			decided[device.UserID] = ok
+			if !ok && err == nil {
+				capped.Suppressed = append(capped.Suppressed, device.UserID)
+			}
+		}
+		if allowed {
+			users[device.ChannelID] = device.UserID
+			channelIDs = append(channelIDs, device.ChannelID)
+		}
+	}
+	sort.Strings(capped.Suppressed)
+	if len(channelIDs) == 0 {
+		return capped, errors.Join(errs...)
+	}
+
+	batches, err := bc.pushBatches([]string{msg}, map[string][]string{msg: channelIDs}, opts)
+	capped.Batches = batches
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// release the budget of users whose devices all failed
+	sent := map[string]bool{}
+	for _, batch := range batches {
+		if batch.Err == nil {
+			for _, id := range batch.ChannelIDs {
+				sent[users[id]] = true
+			}
+		}
+	}
+	for _, batch := range batches {
+		for _, id := range batch.ChannelIDs {
+			if user := users[id]; batch.Err != nil && !sent[user] {
+				sent[user] = true
+				f.store.Release(capKey(category, user), now)
+			}
+		}
+	}
+	return capped, errors.Join(errs...)
+}
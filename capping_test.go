@@ -0,0 +1,89 @@
+package baidupush
+
+import (
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryCounterStore(t *testing.T) {
+	store := NewMemoryCounterStore()
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := store.Take("u", 2, time.Hour, start.Add(time.Duration(i)*time.Minute)); ok != want {
+			t.Errorf("take %d got %v want %v", i, ok, want)
+		}
+	}
+	if ok, _ := store.Take("u", 2, time.Hour, start.Add(time.Hour)); !ok {
+		t.Error("window not rolled")
+	}
+	store.Release("u", start.Add(time.Hour))
+	if ok, _ := store.Take("u", 2, time.Hour, start.Add(time.Hour)); !ok {
+		t.Error("release not uncounted")
+	}
+}
+
+func TestFrequencyCap(t *testing.T) {
+	var failing atomic.Bool
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		if failing.Load() {
+			return nil, 30602
+		}
+		return pushOK(call)
+	})
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	fc := NewFrequencyCap(NewMemoryCounterStore(), CapLimit{Max: 1, Window: 24 * time.Hour})
+	fc.SetLimit("news", CapLimit{Max: 2, Window: 24 * time.Hour})
+	fc.now = func() time.Time { return now }
+
+	alice := Device{UserID: "alice", ChannelID: "a1"}
+	if _, err := fc.PushSingle(bc, alice, "marketing", "sale", nil); err != nil {
+		t.Fatal("push error", err)
+	}
+	if _, err := fc.PushSingle(bc, alice, "marketing", "sale", nil); !errors.Is(err, ErrFrequencyCapped) {
+		t.Fatal("capped push error", err)
+	}
+	// news has its own budget
+	if _, err := fc.PushSingle(bc, alice, "news", "headline", nil); err != nil {
+		t.Fatal("news push error", err)
+	}
+
+	devices := []Device{
+		{UserID: "alice", ChannelID: "a2"},
+		{UserID: "bob", ChannelID: "b1"},
+		{UserID: "bob", ChannelID: "b2"},
+		{UserID: "carol", ChannelID: "c1"},
+	}
+	failing.Store(true)
+	if _, err := fc.PushBatch(bc, devices, "marketing", "sale", nil); err == nil {
+		t.Fatal("failed batch no error")
+	}
+	failing.Store(false)
+
+	calls := len(srv.received())
+	capped, err := fc.PushBatch(bc, devices, "marketing", "sale", nil)
+	if err != nil {
+		t.Fatal("batch error", err)
+	}
+	if !reflect.DeepEqual(capped.Suppressed, []string{"alice"}) {
+		t.Errorf("suppressed %v", capped.Suppressed)
+	}
+	got := srv.received()[calls:]
+	if len(got) != 1 || got[0].apiMethod != "batch_device" || got[0].params.Get("channel_ids") != `["b1","b2","c1"]` {
+		t.Errorf("batch calls %+v", got)
+	}
+
+	capped, _ = fc.PushBatch(bc, devices, "marketing", "sale", nil)
+	if len(capped.Batches) != 0 || !reflect.DeepEqual(capped.Suppressed, []string{"alice", "bob", "carol"}) {
+		t.Errorf("capped %+v", capped)
+	}
+
+	now = now.Add(24 * time.Hour)
+	if _, err := fc.PushSingle(bc, alice, "marketing", "sale", nil); err != nil {
+		t.Error("push after window error", err)
+	}
+}
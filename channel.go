@@ -2,6 +2,7 @@ package baidupush
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
@@ -12,7 +13,12 @@ import (
 	"runtime"
 	"sort"
 	"strconv"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -36,12 +42,25 @@ const (
 
 // Channel contains all the methods to interact with Baidu Cloud Push Service.
 type Channel struct {
-	host       string
-	apiKey     string
-	secret     string
-	requestID  int64
-	deviceType int
-}
+	host         string
+	ctx          context.Context
+	credentials  CredentialsProvider
+	requestID    *atomic.Int64
+	deviceType   int
+	log          logConfig
+	metrics      *Metrics
+	tracer       trace.Tracer
+	dedup        DedupStore
+	pushing      *keyLocks
+	client       *http.Client
+	deployStatus int
+	retry        RetryPolicy
+	limiter      *rateLimiter
+	deadToken    DeadTokenHandler
+}
+
+// ChannelOption sets an optional behaviour of Channel.
+type ChannelOption func(*Channel)
 
 // NewChannel returns a channel bound with specified paramters.
 //
@@ -52,23 +71,71 @@ type Channel struct {
 // secret: API secret.
 //
 // device: Device type, AppleDeviceType or AndroidDeviceType.
-func NewChannel(host, key, secret string, device int) *Channel {
-	return &Channel{
-		host:       host,
-		apiKey:     key,
-		secret:     secret,
-		deviceType: device,
+//
+// opts: Optional behaviours such as WithLogger, WithMetrics or WithTracerProvider,
+// key and secret are ignored if WithCredentialsProvider is given.
+func NewChannel(host, key, secret string, device int, opts ...ChannelOption) *Channel {
+	bc := &Channel{
+		host:        host,
+		ctx:         context.Background(),
+		credentials: StaticCredentials{APIKey: key, Secret: secret},
+		requestID:   new(atomic.Int64),
+		deviceType:  device,
+		log:         defaultLogConfig(),
+		tracer:      defaultTracer(),
+		dedup:       NewMemoryDedupStore(DefaultDedupCapacity, DefaultDedupTTL),
+		pushing:     &keyLocks{},
+		client:      http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(bc)
+	}
+	return bc
+}
+
+// WithTimeout limits the time of every HTTP request made by channel, including
+// reading the response. There is no timeout by default.
+func WithTimeout(timeout time.Duration) ChannelOption {
+	return func(bc *Channel) {
+		bc.client = &http.Client{Timeout: timeout}
+	}
+}
+
+// WithParentContext makes ctx the parent of every API call of channel, the
+// spans of calls are its children and cancelling it aborts calls in flight.
+// Defaults to context.Background().
+func WithParentContext(ctx context.Context) ChannelOption {
+	return func(bc *Channel) {
+		bc.ctx = ctx
+	}
+}
+
+// WithDeployStatus sets the deploy_status of pushes to iOS devices supporting
+// it if not given, DeployStatusProduct or DeployStatusDevelop.
+func WithDeployStatus(status int) ChannelOption {
+	return func(bc *Channel) {
+		bc.deployStatus = status
 	}
 }
 
 // NewChannelDefaultHost returns a channel with host set to "api.tuisong.baidu.com"
-func NewChannelDefaultHost(key, secret string, device int) *Channel {
-	return NewChannel(DefaultBaiduPushService, key, secret, device)
+func NewChannelDefaultHost(key, secret string, device int, opts ...ChannelOption) *Channel {
+	return NewChannel(DefaultBaiduPushService, key, secret, device, opts...)
 }
 
-// GetRequestID returns request ID returned by server.
+// WithContext returns a shallow copy of channel whose API calls have ctx as
+// parent instead of the parent context of channel, such as the context of an
+// incoming request. The copy shares everything else with channel.
+func (bc *Channel) WithContext(ctx context.Context) *Channel {
+	c := *bc
+	c.ctx = ctx
+	return &c
+}
+
+// GetRequestID returns request ID returned by server, it is the ID of the
+// latest finished call if channel is used concurrently.
 func (bc *Channel) GetRequestID() int64 {
-	return bc.requestID
+	return bc.requestID.Load()
 }
 
 // PushMsgToSingleDevice pushes a message to a single device.
@@ -94,6 +161,7 @@ func (bc *Channel) PushMsgToSingleDevice(channelID string, msg string, opts url.
 
 	resultMap, err := bc.pushMessage("PushMsgToSingleDevice", "single_device", musts, opts)
 	if err != nil {
+		bc.reportDeadToken(channelID, err)
 		return msgID, sendTime, err
 	}
 
@@ -320,25 +388,13 @@ func (bc *Channel) QueryTagsInfo(opts url.Values) (int, []TagInfo, error) {
 	if err != nil {
 		return totalNum, nil, err
 	}
-	query := absorbOptionalKeys(commonRequestParams(bc.apiKey, bc.deviceType), opts)
-
-	data, err := requestService(bc.host, "app", "query_tags", http.MethodGet, bc.secret, query)
-	if err != nil {
-		return totalNum, nil, err
-	}
+	query := absorbOptionalKeys(commonRequestParams(bc.deviceType), opts)
 
-	result := map[string]interface{}{}
-	err = json.Unmarshal(data, &result)
+	rspParams, err := bc.request("app", "query_tags", http.MethodGet, query)
 	if err != nil {
 		return totalNum, nil, err
 	}
 
-	bc.requestID = int64(result["request_id"].(float64))
-	if errCode, ok := result["error_code"]; ok {
-		return totalNum, nil, checkErrorCode(int(errCode.(float64)))
-	}
-
-	rspParams := result["response_params"].(map[string]interface{})
 	totalNum = int(rspParams["total_num"].(float64))
 	tags := rspParams["result"].([]interface{})
 
@@ -403,26 +459,14 @@ func (bc *Channel) DeleteTagDevices(tag string, channelIDs []string) ([]TagResul
 func (bc *Channel) GetTagDevicesNumber(tag string) (int, error) {
 	num := 0
 
-	query := commonRequestParams(bc.apiKey, bc.deviceType)
+	query := commonRequestParams(bc.deviceType)
 	query.Add("tag", tag)
 
-	data, err := requestService(bc.host, "tag", "device_num", http.MethodGet, bc.secret, query)
-	if err != nil {
-		return num, err
-	}
-
-	result := map[string]interface{}{}
-	err = json.Unmarshal(data, &result)
+	rspParams, err := bc.request("tag", "device_num", http.MethodGet, query)
 	if err != nil {
 		return num, err
 	}
 
-	bc.requestID = int64(result["request_id"].(float64))
-	if errCode, ok := result["error_code"]; ok {
-		return num, checkErrorCode(int(errCode.(float64)))
-	}
-
-	rspParams := result["response_params"].(map[string]interface{})
 	num = int(rspParams["device_num"].(float64))
 
 	return num, nil
@@ -454,25 +498,13 @@ func (bc *Channel) QueryTimerTasks(opts url.Values) (int, []TimerResult, error)
 		return totalNum, nil, err
 	}
 
-	query := absorbOptionalKeys(commonRequestParams(bc.apiKey, bc.deviceType), opts)
-
-	data, err := requestService(bc.host, "timer", "query_list", http.MethodGet, bc.secret, query)
-	if err != nil {
-		return totalNum, nil, err
-	}
+	query := absorbOptionalKeys(commonRequestParams(bc.deviceType), opts)
 
-	result := map[string]interface{}{}
-	err = json.Unmarshal(data, &result)
+	rspParams, err := bc.request("timer", "query_list", http.MethodGet, query)
 	if err != nil {
 		return totalNum, nil, err
 	}
 
-	bc.requestID = int64(result["request_id"].(float64))
-	if errCode, ok := result["error_code"]; ok {
-		return totalNum, nil, checkErrorCode(int(errCode.(float64)))
-	}
-
-	rspParams := result["response_params"].(map[string]interface{})
 	totalNum = int(rspParams["total_num"].(float64))
 
 	timerResults := []TimerResult{}
@@ -496,26 +528,11 @@ func (bc *Channel) QueryTimerTasks(opts url.Values) (int, []TimerResult, error)
 //
 // timerID: ID of timed task.
 func (bc *Channel) CancelTimerTask(timerID string) error {
-	query := commonRequestParams(bc.apiKey, bc.deviceType)
+	query := commonRequestParams(bc.deviceType)
 	query.Add("timer_id", timerID)
 
-	data, err := requestService(bc.host, "timer", "cancel", http.MethodPost, bc.secret, query)
-	if err != nil {
-		return err
-	}
-
-	result := map[string]interface{}{}
-	err = json.Unmarshal(data, &result)
-	if err != nil {
-		return err
-	}
-
-	bc.requestID = int64(result["request_id"].(float64))
-	if errCode, ok := result["error_code"]; ok {
-		return checkErrorCode(int(errCode.(float64)))
-	}
-
-	return nil
+	_, err := bc.request("timer", "cancel", http.MethodPost, query)
+	return err
 }
 
 // TopicResult represents information of topic.
@@ -540,25 +557,13 @@ func (bc *Channel) QueryTopicList(opts url.Values) (int, []TopicResult, error) {
 		return totalNum, nil, err
 	}
 
-	query := absorbOptionalKeys(commonRequestParams(bc.apiKey, bc.deviceType), opts)
-
-	data, err := requestService(bc.host, "topic", "query_list", http.MethodGet, bc.secret, query)
-	if err != nil {
-		return totalNum, nil, err
-	}
+	query := absorbOptionalKeys(commonRequestParams(bc.deviceType), opts)
 
-	result := map[string]interface{}{}
-	err = json.Unmarshal(data, &result)
+	rspParams, err := bc.request("topic", "query_list", http.MethodGet, query)
 	if err != nil {
 		return totalNum, nil, err
 	}
 
-	bc.requestID = int64(result["request_id"].(float64))
-	if errCode, ok := result["error_code"]; ok {
-		return totalNum, nil, checkErrorCode(int(errCode.(float64)))
-	}
-
-	rspParams := result["response_params"].(map[string]interface{})
 	totalNum = int(rspParams["total_num"].(float64))
 	topicsData := rspParams["result"].([]interface{})
 	topicsResults := []TopicResult{}
@@ -591,25 +596,13 @@ type DeviceStatistics struct {
 func (bc *Channel) ReportDeviceStatistics() (int, []DeviceStatistics, error) {
 	totalNum := 0
 
-	query := commonRequestParams(bc.apiKey, bc.deviceType)
-
-	data, err := requestService(bc.host, "report", "statistic_device", http.MethodGet, bc.secret, query)
-	if err != nil {
-		return totalNum, nil, err
-	}
+	query := commonRequestParams(bc.deviceType)
 
-	result := map[string]interface{}{}
-	err = json.Unmarshal(data, &result)
+	rspParams, err := bc.request("report", "statistic_device", http.MethodGet, query)
 	if err != nil {
 		return totalNum, nil, err
 	}
 
-	bc.requestID = int64(result["request_id"].(float64))
-	if errCode, ok := result["error_code"]; ok {
-		return totalNum, nil, checkErrorCode(int(errCode.(float64)))
-	}
-
-	rspParams := result["response_params"].(map[string]interface{})
 	totalNum = int(rspParams["total_num"].(float64))
 	statData := rspParams["result"].(map[string]interface{})
 	deviceStat := []DeviceStatistics{}
@@ -651,26 +644,14 @@ type TopicStatistics struct {
 func (bc *Channel) ReportTopicStatistics(topicID string) (int, []TopicStatistics, error) {
 	totalNum := 0
 
-	query := commonRequestParams(bc.apiKey, bc.deviceType)
+	query := commonRequestParams(bc.deviceType)
 	query.Add("topic_id", topicID)
 
-	data, err := requestService(bc.host, "report", "statistic_topic", http.MethodGet, bc.secret, query)
-	if err != nil {
-		return totalNum, nil, err
-	}
-
-	result := map[string]interface{}{}
-	err = json.Unmarshal(data, &result)
+	rspParams, err := bc.request("report", "statistic_topic", http.MethodGet, query)
 	if err != nil {
 		return totalNum, nil, err
 	}
 
-	bc.requestID = int64(result["request_id"].(float64))
-	if errCode, ok := result["error_code"]; ok {
-		return totalNum, nil, checkErrorCode(int(errCode.(float64)))
-	}
-
-	rspParams := result["response_params"].(map[string]interface{})
 	totalNum = int(rspParams["total_num"].(float64))
 	statData := rspParams["result"].(map[string]interface{})
 	topicStat := []TopicStatistics{}
@@ -693,25 +674,16 @@ func (bc *Channel) pushMessage(apiName, apiMethod string, musts, optionals url.V
 		return nil, err
 	}
 
-	query := absorbOptionalKeys(commonRequestParams(bc.apiKey, bc.deviceType), musts, optionals)
-
-	data, err := requestService(bc.host, "push", apiMethod, http.MethodPost, bc.secret, query)
-	if err != nil {
-		return nil, err
+	query := absorbOptionalKeys(commonRequestParams(bc.deviceType), musts, optionals)
+	if bc.deployStatus != 0 && bc.deviceType == AppleDeviceType && optionalKeys[apiName]["deploy_status"] && query.Get("deploy_status") == "" {
+		query.Set("deploy_status", strconv.Itoa(bc.deployStatus))
 	}
 
-	result := map[string]interface{}{}
-	err = json.Unmarshal(data, &result)
+	rspParams, err := bc.request("push", apiMethod, http.MethodPost, query)
 	if err != nil {
 		return nil, err
 	}
 
-	bc.requestID = int64(result["request_id"].(float64))
-	if errCode, ok := result["error_code"]; ok {
-		return nil, checkErrorCode(int(errCode.(float64)))
-	}
-
-	rspParams := result["response_params"].(map[string]interface{})
 	resultMap := map[string]interface{}{
 		"msg_id":   rspParams["msg_id"].(string),
 		"timer_id": "",
@@ -738,26 +710,13 @@ func (bc *Channel) query(apiName, apiMethod string, musts, optionals url.Values)
 		return nil, err
 	}
 
-	query := absorbOptionalKeys(commonRequestParams(bc.apiKey, bc.deviceType), musts, optionals)
-
-	data, err := requestService(bc.host, "report", apiMethod, http.MethodGet, bc.secret, query)
-	if err != nil {
-		return nil, err
-	}
+	query := absorbOptionalKeys(commonRequestParams(bc.deviceType), musts, optionals)
 
-	result := map[string]interface{}{}
-	err = json.Unmarshal(data, &result)
+	rspParams, err := bc.request("report", apiMethod, http.MethodGet, query)
 	if err != nil {
 		return nil, err
 	}
 
-	bc.requestID = int64(result["request_id"].(float64))
-	if errCode, ok := result["error_code"]; ok {
-		return nil, checkErrorCode(int(errCode.(float64)))
-	}
-
-	rspParams := result["response_params"].(map[string]interface{})
-
 	resultMap := map[string]interface{}{}
 	if total, ok := rspParams["total_num"]; ok {
 		resultMap["total_num"] = int(total.(float64))
@@ -794,26 +753,14 @@ func (bc *Channel) query(apiName, apiMethod string, musts, optionals url.Values)
 func (bc *Channel) manageTag(apiMethod, tag string) (string, error) {
 	retTag := ""
 
-	query := commonRequestParams(bc.apiKey, bc.deviceType)
+	query := commonRequestParams(bc.deviceType)
 	query.Add("tag", tag)
 
-	data, err := requestService(bc.host, "app", apiMethod, http.MethodPost, bc.secret, query)
-	if err != nil {
-		return retTag, err
-	}
-
-	result := map[string]interface{}{}
-	err = json.Unmarshal(data, &result)
+	rspParams, err := bc.request("app", apiMethod, http.MethodPost, query)
 	if err != nil {
 		return retTag, err
 	}
 
-	bc.requestID = int64(result["request_id"].(float64))
-	if errCode, ok := result["error_code"]; ok {
-		return retTag, checkErrorCode(int(errCode.(float64)))
-	}
-
-	rspParams := result["response_params"].(map[string]interface{})
 	retTag = rspParams["tag"].(string)
 	retCode := int(rspParams["result"].(float64))
 	if retCode != 0 {
@@ -835,27 +782,15 @@ func (bc *Channel) manageTagDevices(apiMethod, tag string, channelIDs []string)
 		return nil, err
 	}
 
-	query := commonRequestParams(bc.apiKey, bc.deviceType)
+	query := commonRequestParams(bc.deviceType)
 	query.Add("tag", tag)
 	query.Add("channel_ids", string(chnData))
 
-	data, err := requestService(bc.host, "tag", apiMethod, http.MethodPost, bc.secret, query)
-	if err != nil {
-		return nil, err
-	}
-
-	result := map[string]interface{}{}
-	err = json.Unmarshal(data, &result)
+	rspParams, err := bc.request("tag", apiMethod, http.MethodPost, query)
 	if err != nil {
 		return nil, err
 	}
 
-	bc.requestID = int64(result["request_id"].(float64))
-	if errCode, ok := result["error_code"]; ok {
-		return nil, checkErrorCode(int(errCode.(float64)))
-	}
-
-	rspParams := result["response_params"].(map[string]interface{})
 	devices := rspParams["result"].([]interface{})
 	for _, dev := range devices {
 		devMap := dev.(map[string]interface{})
@@ -863,13 +798,84 @@ func (bc *Channel) manageTagDevices(apiMethod, tag string, channelIDs []string)
 		res := int(devMap["result"].(float64))
 		tagResults = append(tagResults, TagResult{ChnID: cid, Res: res})
 	}
+	bc.metrics.observeTagDevices(apiMethod, tagResults)
 
 	return tagResults, nil
 }
 
-func commonRequestParams(apiKey string, deviceType int) url.Values {
+// request calls apiClass/apiMethod of the service with query, records the
+// request ID and returns the response parameters or the error code as error.
+// Temporary failures safe to retry are retried by the retry policy of channel,
+// waiting between attempts until the parent context is done.
+func (bc *Channel) request(apiClass, apiMethod, httpMethod string, query url.Values) (map[string]interface{}, error) {
+	for attempt := 1; ; attempt++ {
+		bc.limiter.wait()
+		rspParams, err := bc.call(apiClass, apiMethod, httpMethod, query)
+		if err == nil || attempt >= bc.retry.MaxAttempts || !retryable(httpMethod, err) {
+			return rspParams, err
+		}
+
+		timer := time.NewTimer(bc.retry.Backoff(attempt))
+		select {
+		case <-bc.ctx.Done():
+			timer.Stop()
+			return nil, bc.ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// call makes one attempt of request, each attempt is logged, measured and
+// traced on its own.
+func (bc *Channel) call(apiClass, apiMethod, httpMethod string, query url.Values) (rspParams map[string]interface{}, err error) {
+	var requestID int64
+	var creds Credentials
+	code := 0
+	start := time.Now()
+	ctx, span := bc.startSpan(apiClass, apiMethod, query)
+	defer func() {
+		latency := time.Since(start)
+		bc.logCall(apiClass, apiMethod, httpMethod, query, creds.Secret, requestID, code, latency, err)
+		bc.metrics.observeCall(apiClass, apiMethod, code, latency, err)
+		endSpan(span, requestID, code, creds.Secret, err)
+	}()
+
+	// the API key and the secret signing the request must be of the same
+	// credentials, they are read once in case of rotation
+	creds, err = bc.credentials.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	query.Set("apikey", creds.APIKey)
+	query.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	query.Del("sign")
+
+	data, err := requestService(ctx, bc.client, bc.host, apiClass, apiMethod, httpMethod, creds.Secret, query)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{}
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	requestID = int64(result["request_id"].(float64))
+	bc.requestID.Store(requestID)
+	if errCode, ok := result["error_code"]; ok {
+		code = int(errCode.(float64))
+		if err = checkErrorCode(code); err == nil {
+			err = &ServiceError{Code: code, Msg: fmt.Sprint(result["error_msg"])}
+		}
+		return nil, err
+	}
+
+	return result["response_params"].(map[string]interface{}), nil
+}
+
+func commonRequestParams(deviceType int) url.Values {
 	commons := url.Values{}
-	commons.Add("apikey", apiKey)
 	commons.Add("timestamp", fmt.Sprintf("%d", time.Now().Unix()))
 	commons.Add("device_type", fmt.Sprintf("%d", deviceType))
 	return commons
@@ -915,7 +921,7 @@ func absorbOptionalKeys(valuesSet ...url.Values) url.Values {
 	return together
 }
 
-func requestService(host, apiClass, apiMethod, httpMethod, secret string, query url.Values) ([]byte, error) {
+func requestService(ctx context.Context, client *http.Client, host, apiClass, apiMethod, httpMethod, secret string, query url.Values) ([]byte, error) {
 	urlStr := fmt.Sprintf("http://%s/rest/3.0/%s/%s", host, apiClass, apiMethod)
 	sign := generateSign(httpMethod, urlStr, secret, query)
 	query.Add("sign", sign)
@@ -923,20 +929,21 @@ func requestService(host, apiClass, apiMethod, httpMethod, secret string, query
 	var req *http.Request
 	var err error
 	if httpMethod == http.MethodPost {
-		req, err = http.NewRequest(httpMethod, urlStr, bytes.NewReader([]byte(query.Encode())))
+		req, err = http.NewRequestWithContext(ctx, httpMethod, urlStr, bytes.NewReader([]byte(query.Encode())))
 		if err != nil {
 			return nil, err
 		}
 	} else if httpMethod == http.MethodGet {
 		urlStr = fmt.Sprintf("%s?%s", urlStr, query.Encode())
-		req, err = http.NewRequest(httpMethod, urlStr, nil)
+		req, err = http.NewRequestWithContext(ctx, httpMethod, urlStr, nil)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	req.Header = apiHeader()
-	rsp, err := http.DefaultClient.Do(req)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	rsp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
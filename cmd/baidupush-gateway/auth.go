@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientConfig configures a client of the gateway.
+type clientConfig struct {
+	Name     string   `json:"name"`
+	Token    string   `json:"token"`
+	Rate     float64  `json:"rate"`
+	Burst    int      `json:"burst"`
+	Channels []string `json:"channels"`
+}
+
+// client is an authenticated user of the gateway.
+type client struct {
+	name     string
+	digest   [sha256.Size]byte
+	channels map[string]bool
+	bucket   *bucket
+}
+
+// allowed reports whether client could use channel.
+func (c *client) allowed(channel string) bool {
+	return len(c.channels) == 0 || c.channels[channel]
+}
+
+// clients authenticates requests by API tokens.
+type clients struct {
+	list []*client
+}
+
+func newClients(configs []clientConfig, now func() time.Time) (*clients, error) {
+	cs := &clients{}
+	for _, cfg := range configs {
+		if cfg.Name == "" || len(cfg.Token) < 16 {
+			return nil, fmt.Errorf("client %q: name and a token of at least 16 characters are required", cfg.Name)
+		}
+		if cfg.Rate <= 0 || cfg.Burst <= 0 {
+			return nil, fmt.Errorf("client %s: rate and burst must be positive", cfg.Name)
+		}
+		c := &client{
+			name:     cfg.Name,
+			digest:   sha256.Sum256([]byte(cfg.Token)),
+			channels: map[string]bool{},
+			bucket:   newBucket(cfg.Rate, cfg.Burst, now),
+		}
+		for _, ch := range cfg.Channels {
+			c.channels[ch] = true
+		}
+		cs.list = append(cs.list, c)
+	}
+	return cs, nil
+}
+
+// authenticate returns the client presenting the bearer token of r, or nil.
+func (cs *clients) authenticate(r *http.Request) *client {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil
+	}
+	digest := sha256.Sum256([]byte(token))
+	var found *client
+	for _, c := range cs.list {
+		if subtle.ConstantTimeCompare(digest[:], c.digest[:]) == 1 {
+			found = c
+		}
+	}
+	return found
+}
+
+// bucket is a token bucket limiting the rate of requests.
+type bucket struct {
+	mu       sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+func newBucket(rate float64, burst int, now func() time.Time) *bucket {
+	return &bucket{
+		rate:     rate,
+		capacity: float64(burst),
+		tokens:   float64(burst),
+		last:     now(),
+		now:      now,
+	}
+}
+
+// take consumes a token, returning false and the time to wait if there is none.
+func (b *bucket) take() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
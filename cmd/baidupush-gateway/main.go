@@ -0,0 +1,136 @@
+// Command baidupush-gateway serves a small authenticated JSON API in front of
+// Baidu Cloud Push Service, so that services in any language push through it
+// while the credentials live in one place.
+//
+// Usage:
+//
+//	baidupush-gateway -config gateway.json [-listen :8080] [-timeout 30s]
+//
+// The config file names the channels and the clients allowed to use them:
+//
+//	{
+//	  "channels": {
+//	    "android": {"host": "api.tuisong.baidu.com", "api_key": "...", "secret": "...", "device_type": "android"},
+//	    "ios": {"api_key": "...", "secret": "...", "device_type": "ios"}
+//	  },
+//	  "clients": [
+//	    {"name": "billing", "token": "...", "rate": 5, "burst": 10, "channels": ["android"]}
+//	  ]
+//	}
+//
+// Channels also take timeout, retry, rate_limit and deploy_status as read by
+// baidupush.ReadConfig, fields set at the top level are inherited by every
+// channel.
+//
+// Clients authenticate with "Authorization: Bearer <token>", rate is the
+// number of requests allowed per second and burst the size of the bucket. A
+// client without channels could use all of them.
+//
+// A request is served within timeout, including the calls to Baidu and the
+// backoff between retries, and answered 504 if it passes. SIGTERM or an
+// interrupt stops the gateway after the requests in flight are served.
+//
+// The API is described by the OpenAPI document served at /openapi.json.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	baidupush "github.com/leesper/baidupush-golang"
+)
+
+// config is the content of gateway config file, channels are configured as
+// in baidupush.Config.
+type config struct {
+	baidupush.Config
+	Clients []clientConfig `json:"clients"`
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	if err = json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("config file %s: %v", path, err)
+	}
+	if len(cfg.Channels) == 0 {
+		return nil, fmt.Errorf("config file %s: no channels", path)
+	}
+	return cfg, nil
+}
+
+func main() {
+	configPath := flag.String("config", "gateway.json", "path of config file")
+	listen := flag.String("listen", ":8080", "address to listen on")
+	timeout := flag.Duration("timeout", defaultTimeout, "time to serve a request")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	if err := serve(ctx, *configPath, *listen, *timeout, logger); err != nil {
+		logger.Error("baidupush-gateway: exit", "error", err)
+		os.Exit(1)
+	}
+}
+
+// serve serves the gateway on listen until ctx is done, then shuts it down
+// gracefully.
+func serve(ctx context.Context, configPath, listen string, timeout time.Duration, logger *slog.Logger) error {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	channels, err := cfg.NewChannels(baidupush.WithLogger(logger), baidupush.WithMsgRedacted())
+	if err != nil {
+		return err
+	}
+	clients, err := newClients(cfg.Clients, time.Now)
+	if err != nil {
+		return err
+	}
+
+	s := newServer(channels, clients, logger)
+	s.timeout = timeout
+	srv := &http.Server{
+		Addr:              listen,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		// leave time to write the error of a request timed out
+		WriteTimeout: timeout + 5*time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		logger.Info("baidupush-gateway: listening", "addr", listen)
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err = <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("baidupush-gateway: shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout+5*time.Second)
+	defer cancel()
+	if err = srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err = <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// route is a registered "METHOD /path/{name}" pattern.
+type route struct {
+	method   string
+	segments []string
+	handler  http.HandlerFunc
+}
+
+// router dispatches requests on method and path, wildcard segments such as
+// {channel} are made available through pathValue.
+type router struct {
+	routes []route
+}
+
+type pathValuesKey struct{}
+
+// HandleFunc registers h for pattern, which is a method, a space and a path.
+func (rt *router) HandleFunc(pattern string, h http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		segments: strings.Split(strings.Trim(path, "/"), "/"),
+		handler:  h,
+	})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	matched := false
+	for _, rte := range rt.routes {
+		values, ok := rte.match(segments)
+		if !ok {
+			continue
+		}
+		matched = true
+		if rte.method != r.Method {
+			continue
+		}
+		rte.handler(w, r.WithContext(context.WithValue(r.Context(), pathValuesKey{}, values)))
+		return
+	}
+	if matched {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (rte route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rte.segments) {
+		return nil, false
+	}
+	values := map[string]string{}
+	for i, seg := range rte.segments {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			values[seg[1:len(seg)-1]] = segments[i]
+			continue
+		}
+		if seg != segments[i] {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// pathValue returns the wildcard segment name of the route r matched.
+func pathValue(r *http.Request, name string) string {
+	values, _ := r.Context().Value(pathValuesKey{}).(map[string]string)
+	return values[name]
+}
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	baidupush "github.com/leesper/baidupush-golang"
+)
+
+const (
+	maxBodySize  = 1 << 20
+	maxMsgSize   = 4096
+	maxBatchSize = 10000
+	maxTagSize   = 10
+	maxMsgExpire = 604800
+
+	// defaultTimeout is the time to serve a request, including the calls to
+	// the service and the backoff between retries.
+	defaultTimeout = 30 * time.Second
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// httpError is an error reported to clients with status.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+// handlerFunc serves an API request with the channel it names.
+type handlerFunc func(r *http.Request, bc *baidupush.Channel) (interface{}, error)
+
+type server struct {
+	mux      *router
+	channels map[string]*baidupush.Channel
+	clients  *clients
+	logger   *slog.Logger
+	timeout  time.Duration
+	now      func() time.Time
+}
+
+func newServer(channels map[string]*baidupush.Channel, clients *clients, logger *slog.Logger) *server {
+	s := &server{
+		mux:      &router{},
+		channels: channels,
+		clients:  clients,
+		logger:   logger,
+		timeout:  defaultTimeout,
+		now:      time.Now,
+	}
+
+	s.mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPI)
+	})
+	s.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	s.handle("POST /v1/{channel}/push/single", s.pushSingle)
+	s.handle("POST /v1/{channel}/push/all", s.pushAll)
+	s.handle("POST /v1/{channel}/push/tag", s.pushTag)
+	s.handle("POST /v1/{channel}/push/batch", s.pushBatch)
+	s.handle("GET /v1/{channel}/tags", s.listTags)
+	s.handle("POST /v1/{channel}/tags", s.createTag)
+	s.handle("DELETE /v1/{channel}/tags/{tag}", s.deleteTag)
+	s.handle("GET /v1/{channel}/tags/{tag}/devices/count", s.countTagDevices)
+	s.handle("POST /v1/{channel}/tags/{tag}/devices/add", s.addTagDevices)
+	s.handle("POST /v1/{channel}/tags/{tag}/devices/remove", s.removeTagDevices)
+	s.handle("GET /v1/{channel}/timers", s.listTimers)
+	s.handle("DELETE /v1/{channel}/timers/{timer}", s.cancelTimer)
+	s.handle("GET /v1/{channel}/topics", s.listTopics)
+	s.handle("GET /v1/{channel}/topics/{topic}/records", s.topicRecords)
+	s.handle("GET /v1/{channel}/topics/{topic}/stats", s.topicStats)
+	s.handle("GET /v1/{channel}/reports/devices", s.reportDevices)
+	s.handle("GET /v1/{channel}/messages/{msg}", s.messageStatus)
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handle registers h for pattern behind authentication and rate limiting, h
+// calls the service in the context of the request, canceled when the client
+// goes away or the timeout of server passes.
+func (s *server) handle(pattern string, h handlerFunc) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		c := s.clients.authenticate(r)
+		if c == nil {
+			writeError(w, &httpError{status: http.StatusUnauthorized, msg: "missing or invalid API token"})
+			return
+		}
+
+		if ok, wait := c.bucket.take(); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, &httpError{status: http.StatusTooManyRequests, msg: "rate limit exceeded"})
+			return
+		}
+
+		name := pathValue(r, "channel")
+		bc, ok := s.channels[name]
+		if !ok || !c.allowed(name) {
+			writeError(w, &httpError{status: http.StatusNotFound, msg: fmt.Sprintf("channel %s not found", name)})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		v, err := h(r, bc.WithContext(ctx))
+		if err != nil {
+			s.logger.Warn("baidupush-gateway: request failed", "client", c.name, "pattern", pattern, "error", redactError(err))
+			writeError(w, err)
+			return
+		}
+		s.logger.Info("baidupush-gateway: request served", "client", c.name, "pattern", pattern)
+		writeJSON(w, http.StatusOK, v)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError reports err. Errors of the service are passed on as bad gateway,
+// failures reaching it, whose messages may carry the signed request URL, are
+// reported as upstream unavailable or timed out, and the others are requests
+// rejected by the SDK before being sent.
+func writeError(w http.ResponseWriter, err error) {
+	status, msg := http.StatusBadRequest, err.Error()
+	var he *httpError
+	var se *baidupush.ServiceError
+	var urlErr *url.Error
+	var netErr net.Error
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &he):
+		status, msg = he.status, he.msg
+	case errors.As(err, &se):
+		status = http.StatusBadGateway
+	case errors.Is(err, context.DeadlineExceeded):
+		status, msg = http.StatusGatewayTimeout, "upstream timed out"
+	case errors.As(err, &urlErr) || errors.As(err, &netErr) || errors.As(err, &syntaxErr) || errors.Is(err, context.Canceled):
+		status, msg = http.StatusBadGateway, "upstream unavailable"
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"status": status, "message": msg},
+	})
+}
+
+// redactError returns the message of err without the query of a failed
+// request URL, which carries the API key and the signature.
+func redactError(err error) string {
+	msg := err.Error()
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if u, perr := url.Parse(urlErr.URL); perr == nil && u.RawQuery != "" {
+			u.RawQuery = ""
+			msg = strings.ReplaceAll(msg, urlErr.URL, u.String())
+		}
+	}
+	return msg
+}
+
+// pushRequest is the body of push APIs.
+type pushRequest struct {
+	ChannelID    string          `json:"channel_id"`
+	ChannelIDs   []string        `json:"channel_ids"`
+	Tag          string          `json:"tag"`
+	Msg          json.RawMessage `json:"msg"`
+	MsgType      *int            `json:"msg_type"`
+	MsgExpires   *int            `json:"msg_expires"`
+	DeployStatus *int            `json:"deploy_status"`
+	SendTime     *int64          `json:"send_time"`
+	TopicID      string          `json:"topic_id"`
+}
+
+// pushResponse is the result of push APIs.
+type pushResponse struct {
+	MsgID    string `json:"msg_id"`
+	TimerID  string `json:"timer_id,omitempty"`
+	SendTime int64  `json:"send_time"`
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return badRequest("invalid request body: trailing data")
+	}
+	return nil
+}
+
+// decodePush decodes a push request, validating the message and the optional
+// parameters, only those in allowed could be set.
+func (s *server) decodePush(r *http.Request, allowed ...string) (*pushRequest, string, url.Values, error) {
+	req := &pushRequest{}
+	if err := decodeBody(r, req); err != nil {
+		return nil, "", nil, err
+	}
+
+	msg, err := decodeMsg(req.Msg)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	set := map[string]bool{
+		"msg_type":      req.MsgType != nil,
+		"msg_expires":   req.MsgExpires != nil,
+		"deploy_status": req.DeployStatus != nil,
+		"send_time":     req.SendTime != nil,
+		"topic_id":      req.TopicID != "",
+	}
+	for _, k := range allowed {
+		delete(set, k)
+	}
+	for k, isSet := range set {
+		if isSet {
+			return nil, "", nil, badRequest("%s is not allowed in this API", k)
+		}
+	}
+
+	opts := url.Values{}
+	if req.MsgType != nil {
+		if *req.MsgType != baidupush.MsgTypeMessage && *req.MsgType != baidupush.MsgTypeNotice {
+			return nil, "", nil, badRequest("msg_type must be 0 or 1")
+		}
+		opts.Set("msg_type", strconv.Itoa(*req.MsgType))
+	}
+	if req.MsgExpires != nil {
+		if *req.MsgExpires < 0 || *req.MsgExpires > maxMsgExpire {
+			return nil, "", nil, badRequest("msg_expires must be 0-%d", maxMsgExpire)
+		}
+		opts.Set("msg_expires", strconv.Itoa(*req.MsgExpires))
+	}
+	if req.DeployStatus != nil {
+		if *req.DeployStatus != baidupush.DeployStatusDevelop && *req.DeployStatus != baidupush.DeployStatusProduct {
+			return nil, "", nil, badRequest("deploy_status must be 1 or 2")
+		}
+		opts.Set("deploy_status", strconv.Itoa(*req.DeployStatus))
+	}
+	if req.SendTime != nil {
+		sendTime := time.Unix(*req.SendTime, 0)
+		now := s.now()
+		if sendTime.Before(now.Add(time.Minute)) || sendTime.After(now.AddDate(1, 0, 0)) {
+			return nil, "", nil, badRequest("send_time must be at least 60s and at most 1 year later")
+		}
+		opts.Set("send_time", strconv.FormatInt(*req.SendTime, 10))
+	}
+	if req.TopicID != "" {
+		opts.Set("topic_id", req.TopicID)
+	}
+	return req, msg, opts, nil
+}
+
+// decodeMsg accepts a message as JSON string or object.
+func decodeMsg(raw json.RawMessage) (string, error) {
+	msg := ""
+	if len(raw) > 0 && raw[0] == '{' {
+		buf := &bytes.Buffer{}
+		if err := json.Compact(buf, raw); err != nil {
+			return "", badRequest("invalid msg: %v", err)
+		}
+		msg = buf.String()
+	} else if err := json.Unmarshal(raw, &msg); err != nil {
+		return "", badRequest("msg must be a string or an object")
+	}
+	if msg == "" {
+		return "", badRequest("msg is required")
+	}
+	if len(msg) > maxMsgSize {
+		return "", badRequest("msg is longer than %d bytes", maxMsgSize)
+	}
+	return msg, nil
+}
+
+func validateTag(tag string) error {
+	if len(tag) < 1 || len(tag) > 128 {
+		return badRequest("tag must be of length 1-128")
+	}
+	if tag == "default" {
+		return badRequest("tag default is reserved")
+	}
+	return nil
+}
+
+func validateChannelIDs(channelIDs []string, max int) error {
+	if len(channelIDs) < 1 || len(channelIDs) > max {
+		return badRequest("channel_ids must contain 1-%d channel IDs", max)
+	}
+	for _, id := range channelIDs {
+		if id == "" {
+			return badRequest("channel_ids must not contain empty channel ID")
+		}
+	}
+	return nil
+}
+
+func (s *server) pushSingle(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	req, msg, opts, err := s.decodePush(r, "msg_type", "msg_expires", "deploy_status")
+	if err != nil {
+		return nil, err
+	}
+	if req.ChannelID == "" || req.Tag != "" || req.ChannelIDs != nil {
+		return nil, badRequest("channel_id is required, tag and channel_ids are not allowed")
+	}
+	msgID, sendTime, err := bc.PushMsgToSingleDevice(req.ChannelID, msg, opts)
+	if err != nil {
+		return nil, err
+	}
+	return pushResponse{MsgID: msgID, SendTime: sendTime}, nil
+}
+
+func (s *server) pushAll(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	req, msg, opts, err := s.decodePush(r, "msg_type", "msg_expires", "deploy_status", "send_time")
+	if err != nil {
+		return nil, err
+	}
+	if req.ChannelID != "" || req.Tag != "" || req.ChannelIDs != nil {
+		return nil, badRequest("channel_id, tag and channel_ids are not allowed")
+	}
+	msgID, timerID, sendTime, err := bc.PushMsgToAllDevices(msg, opts)
+	if err != nil {
+		return nil, err
+	}
+	return pushResponse{MsgID: msgID, TimerID: timerID, SendTime: sendTime}, nil
+}
+
+func (s *server) pushTag(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	req, msg, opts, err := s.decodePush(r, "msg_type", "msg_expires", "deploy_status", "send_time")
+	if err != nil {
+		return nil, err
+	}
+	if req.ChannelID != "" || req.ChannelIDs != nil {
+		return nil, badRequest("channel_id and channel_ids are not allowed")
+	}
+	if len(req.Tag) < 1 || len(req.Tag) > 128 {
+		return nil, badRequest("tag must be of length 1-128")
+	}
+	msgID, timerID, sendTime, err := bc.PushMsgToTaggedDevices(req.Tag, msg, opts)
+	if err != nil {
+		return nil, err
+	}
+	return pushResponse{MsgID: msgID, TimerID: timerID, SendTime: sendTime}, nil
+}
+
+func (s *server) pushBatch(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	req, msg, opts, err := s.decodePush(r, "msg_type", "msg_expires", "topic_id")
+	if err != nil {
+		return nil, err
+	}
+	if req.ChannelID != "" || req.Tag != "" {
+		return nil, badRequest("channel_id and tag are not allowed")
+	}
+	if err = validateChannelIDs(req.ChannelIDs, maxBatchSize); err != nil {
+		return nil, err
+	}
+	msgID, sendTime, err := bc.PushMsgToBatchDevices(req.ChannelIDs, msg, opts)
+	if err != nil {
+		return nil, err
+	}
+	return pushResponse{MsgID: msgID, SendTime: sendTime}, nil
+}
+
+// pageQuery converts paging parameters in the URL query of r to options.
+func pageQuery(r *http.Request, extras ...string) (url.Values, error) {
+	opts := url.Values{}
+	q := r.URL.Query()
+	if v := q.Get("start"); v != "" {
+		start, err := strconv.Atoi(v)
+		if err != nil || start < 0 {
+			return nil, badRequest("start must be a non-negative integer")
+		}
+		opts.Set("start", v)
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 100 {
+			return nil, badRequest("limit must be 1-100")
+		}
+		opts.Set("limit", v)
+	}
+	for _, k := range extras {
+		if v := q.Get(k); v != "" {
+			opts.Set(k, v)
+		}
+	}
+	return opts, nil
+}
+
+type tagInfo struct {
+	TID        string `json:"tid"`
+	Tag        string `json:"tag"`
+	Info       string `json:"info"`
+	CreateTime int64  `json:"create_time"`
+}
+
+func (s *server) listTags(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	opts, err := pageQuery(r, "tag")
+	if err != nil {
+		return nil, err
+	}
+	total, infos, err := bc.QueryTagsInfo(opts)
+	if err != nil {
+		return nil, err
+	}
+	tags := []tagInfo{}
+	for _, info := range infos {
+		tags = append(tags, tagInfo{TID: info.TID, Tag: info.Tag, Info: info.Info, CreateTime: info.CreateTime})
+	}
+	return map[string]interface{}{"total_num": total, "result": tags}, nil
+}
+
+func (s *server) createTag(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	req := struct {
+		Tag string `json:"tag"`
+	}{}
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	if err := validateTag(req.Tag); err != nil {
+		return nil, err
+	}
+	tag, err := bc.CreateTag(req.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"tag": tag}, nil
+}
+
+func (s *server) deleteTag(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	if err := validateTag(pathValue(r, "tag")); err != nil {
+		return nil, err
+	}
+	tag, err := bc.DeleteTag(pathValue(r, "tag"))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"tag": tag}, nil
+}
+
+func (s *server) countTagDevices(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	num, err := bc.GetTagDevicesNumber(pathValue(r, "tag"))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"tag": pathValue(r, "tag"), "device_num": num}, nil
+}
+
+type tagDeviceResult struct {
+	ChannelID string `json:"channel_id"`
+	Result    int    `json:"result"`
+}
+
+func (s *server) manageTagDevices(r *http.Request, manage func(string, []string) ([]baidupush.TagResult, error)) (interface{}, error) {
+	req := struct {
+		ChannelIDs []string `json:"channel_ids"`
+	}{}
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	if err := validateTag(pathValue(r, "tag")); err != nil {
+		return nil, err
+	}
+	if err := validateChannelIDs(req.ChannelIDs, maxTagSize); err != nil {
+		return nil, err
+	}
+	results, err := manage(pathValue(r, "tag"), req.ChannelIDs)
+	if err != nil {
+		return nil, err
+	}
+	devices := []tagDeviceResult{}
+	for _, res := range results {
+		devices = append(devices, tagDeviceResult{ChannelID: res.ChnID, Result: res.Res})
+	}
+	return map[string]interface{}{"result": devices}, nil
+}
+
+func (s *server) addTagDevices(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	return s.manageTagDevices(r, bc.AddTagDevices)
+}
+
+func (s *server) removeTagDevices(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	return s.manageTagDevices(r, bc.DeleteTagDevices)
+}
+
+type timerResult struct {
+	TimerID   string `json:"timer_id"`
+	Msg       string `json:"msg"`
+	SendTime  int64  `json:"send_time"`
+	MsgType   int    `json:"msg_type"`
+	RangeType int    `json:"range_type"`
+}
+
+func (s *server) listTimers(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	opts, err := pageQuery(r, "timer_id")
+	if err != nil {
+		return nil, err
+	}
+	total, timers, err := bc.QueryTimerTasks(opts)
+	if err != nil {
+		return nil, err
+	}
+	results := []timerResult{}
+	for _, t := range timers {
+		results = append(results, timerResult{TimerID: t.ID, Msg: t.Msg, SendTime: t.SendTime, MsgType: t.MsgType, RangeType: t.RangeType})
+	}
+	return map[string]interface{}{"total_num": total, "result": results}, nil
+}
+
+func (s *server) cancelTimer(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	if err := bc.CancelTimerTask(pathValue(r, "timer")); err != nil {
+		return nil, err
+	}
+	return map[string]string{"timer_id": pathValue(r, "timer"), "status": "canceled"}, nil
+}
+
+type topicResult struct {
+	TopicID   string `json:"topic_id"`
+	PushCount int    `json:"push_cnt"`
+	AckCount  int    `json:"ack_cnt"`
+	FirstTime int64  `json:"ctime"`
+	LastTime  int64  `json:"mtime"`
+}
+
+func (s *server) listTopics(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	opts, err := pageQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	total, topics, err := bc.QueryTopicList(opts)
+	if err != nil {
+		return nil, err
+	}
+	results := []topicResult{}
+	for _, t := range topics {
+		results = append(results, topicResult{TopicID: t.Topic, PushCount: t.PushCount, AckCount: t.AckCount, FirstTime: t.FirstTime, LastTime: t.LastTime})
+	}
+	return map[string]interface{}{"total_num": total, "result": results}, nil
+}
+
+type messageResult struct {
+	MsgID    string `json:"msg_id"`
+	Status   int    `json:"status"`
+	Success  int    `json:"success"`
+	SendTime int64  `json:"send_time"`
+}
+
+func messageResults(results []baidupush.MessageResult) []messageResult {
+	msgs := []messageResult{}
+	for _, m := range results {
+		msgs = append(msgs, messageResult{MsgID: m.MsgID, Status: m.Status, Success: m.Success, SendTime: m.SendTime})
+	}
+	return msgs
+}
+
+func (s *server) topicRecords(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	opts, err := pageQuery(r, "range_start", "range_end")
+	if err != nil {
+		return nil, err
+	}
+	topic, results, err := bc.QueryTopicRecords(pathValue(r, "topic"), opts)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"topic_id": topic, "result": messageResults(results)}, nil
+}
+
+func (s *server) topicStats(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	total, stats, err := bc.ReportTopicStatistics(pathValue(r, "topic"))
+	if err != nil {
+		return nil, err
+	}
+	results := []map[string]interface{}{}
+	for _, st := range stats {
+		results = append(results, map[string]interface{}{"day": st.Day, "ack": st.Ack})
+	}
+	return map[string]interface{}{"total_num": total, "result": results}, nil
+}
+
+func (s *server) reportDevices(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	total, stats, err := bc.ReportDeviceStatistics()
+	if err != nil {
+		return nil, err
+	}
+	results := []map[string]interface{}{}
+	for _, st := range stats {
+		results = append(results, map[string]interface{}{
+			"day":         st.Day,
+			"new_term":    st.DailyNewUser,
+			"del_term":    st.DailyLostUser,
+			"online_term": st.DailyOnline,
+			"addup_term":  st.AddedupTerm,
+			"total_term":  st.AvailChnID,
+		})
+	}
+	return map[string]interface{}{"total_num": total, "result": results}, nil
+}
+
+func (s *server) messageStatus(r *http.Request, bc *baidupush.Channel) (interface{}, error) {
+	total, results, err := bc.QueryMsgStatus(pathValue(r, "msg"))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"total_num": total, "result": messageResults(results)}, nil
+}
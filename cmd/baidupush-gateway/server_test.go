@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	baidupush "github.com/leesper/baidupush-golang"
+)
+
+const token = "0123456789abcdef"
+
+// newTestGateway returns a gateway in front of a fake service answering push
+// APIs, the forms received by the service are appended to forms.
+func newTestGateway(t *testing.T, forms *[]url.Values, now func() time.Time) *httptest.Server {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*forms = append(*forms, r.Form)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"request_id":      1,
+			"response_params": map[string]interface{}{"msg_id": "m1", "send_time": 1486000000},
+		})
+	}))
+	t.Cleanup(service.Close)
+
+	host := strings.TrimPrefix(service.URL, "http://")
+	channels := map[string]*baidupush.Channel{
+		"android": baidupush.NewChannel(host, "key", "secret", baidupush.AndroidDeviceType),
+		"ios":     baidupush.NewChannel(host, "key", "secret", baidupush.AppleDeviceType),
+	}
+	clients, err := newClients([]clientConfig{
+		{Name: "billing", Token: token, Rate: 1, Burst: 2, Channels: []string{"android"}},
+	}, now)
+	if err != nil {
+		t.Fatal("new clients error", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	gateway := httptest.NewServer(newServer(channels, clients, logger))
+	t.Cleanup(gateway.Close)
+	return gateway
+}
+
+func call(t *testing.T, method, url, auth, body string) (int, map[string]interface{}) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", "Bearer "+auth)
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	result := map[string]interface{}{}
+	json.NewDecoder(rsp.Body).Decode(&result)
+	return rsp.StatusCode, result
+}
+
+func TestPushSingle(t *testing.T) {
+	forms := []url.Values{}
+	now := time.Now()
+	gateway := newTestGateway(t, &forms, func() time.Time { return now })
+
+	status, result := call(t, http.MethodPost, gateway.URL+"/v1/android/push/single", token,
+		`{"channel_id": "chn", "msg": {"title": "hello", "description": "world"}, "msg_type": 1}`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d want 200, result %v", status, result)
+	}
+	if result["msg_id"] != "m1" {
+		t.Errorf("msg_id %v want m1", result["msg_id"])
+	}
+	if len(forms) != 1 {
+		t.Fatalf("service received %d requests want 1", len(forms))
+	}
+	if msg := forms[0].Get("msg"); msg != `{"title":"hello","description":"world"}` {
+		t.Errorf("msg %s want compacted object", msg)
+	}
+	if forms[0].Get("channel_id") != "chn" || forms[0].Get("msg_type") != "1" {
+		t.Errorf("form %v want channel_id chn and msg_type 1", forms[0])
+	}
+}
+
+func TestRejectedRequests(t *testing.T) {
+	forms := []url.Values{}
+	now := time.Now()
+	gateway := newTestGateway(t, &forms, func() time.Time { return now })
+
+	cases := []struct {
+		name, method, path, auth, body string
+		status                         int
+	}{
+		{"no token", "POST", "/v1/android/push/all", "", `{"msg": "hi"}`, http.StatusUnauthorized},
+		{"wrong token", "POST", "/v1/android/push/all", "fedcba9876543210", `{"msg": "hi"}`, http.StatusUnauthorized},
+		{"channel not allowed", "POST", "/v1/ios/push/all", token, `{"msg": "hi"}`, http.StatusNotFound},
+		{"send_time not allowed", "POST", "/v1/android/push/single", token, `{"channel_id": "c", "msg": "hi", "send_time": 1}`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		now = now.Add(time.Hour) // refill the bucket
+		status, result := call(t, c.method, gateway.URL+c.path, c.auth, c.body)
+		if status != c.status {
+			t.Errorf("%s: status %d want %d, result %v", c.name, status, c.status, result)
+		}
+	}
+
+	invalid := []string{
+		`{"msg": ""}`,
+		`{"msg": 42}`,
+		`{"msg": "hi", "msg_type": 2}`,
+		`{"msg": "hi", "msg_expires": 604801}`,
+		`{"msg": "hi", "send_time": 1}`,
+		`{"msg": "hi", "unknown": 1}`,
+		`{"msg": "` + strings.Repeat("x", 4097) + `"}`,
+	}
+	for _, body := range invalid {
+		now = now.Add(time.Hour)
+		if status, _ := call(t, "POST", gateway.URL+"/v1/android/push/all", token, body); status != http.StatusBadRequest {
+			t.Errorf("body %.40s: status %d want 400", body, status)
+		}
+	}
+
+	if len(forms) != 0 {
+		t.Errorf("service received %d requests want 0", len(forms))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	forms := []url.Values{}
+	now := time.Now()
+	gateway := newTestGateway(t, &forms, func() time.Time { return now })
+
+	push := func() int {
+		status, _ := call(t, "POST", gateway.URL+"/v1/android/push/all", token, `{"msg": "hi"}`)
+		return status
+	}
+	for i := 0; i < 2; i++ {
+		if status := push(); status != http.StatusOK {
+			t.Fatalf("push %d: status %d want 200", i, status)
+		}
+	}
+	if status := push(); status != http.StatusTooManyRequests {
+		t.Errorf("status %d want 429", status)
+	}
+	now = now.Add(time.Second)
+	if status := push(); status != http.StatusOK {
+		t.Errorf("status after refill %d want 200", status)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	forms := []url.Values{}
+	gateway := newTestGateway(t, &forms, time.Now)
+
+	status, doc := call(t, http.MethodGet, gateway.URL+"/openapi.json", "", "")
+	if status != http.StatusOK {
+		t.Fatalf("status %d want 200", status)
+	}
+	paths := doc["paths"].(map[string]interface{})
+	for _, p := range []string{"/v1/{channel}/push/single", "/v1/{channel}/tags/{tag}/devices/add", "/v1/{channel}/messages/{msg}"} {
+		if _, ok := paths[p]; !ok {
+			t.Errorf("path %s not described", p)
+		}
+	}
+}
+
+func TestUpstreamUnavailable(t *testing.T) {
+	service := httptest.NewServer(http.NotFoundHandler())
+	service.Close()
+	host := strings.TrimPrefix(service.URL, "http://")
+	channels := map[string]*baidupush.Channel{
+		"android": baidupush.NewChannel(host, "the-api-key", "secret", baidupush.AndroidDeviceType),
+	}
+	clients, err := newClients([]clientConfig{
+		{Name: "billing", Token: token, Rate: 1, Burst: 2, Channels: []string{"android"}},
+	}, time.Now)
+	if err != nil {
+		t.Fatal("new clients error", err)
+	}
+	logs := &strings.Builder{}
+	gateway := httptest.NewServer(newServer(channels, clients, slog.New(slog.NewTextHandler(logs, nil))))
+	t.Cleanup(gateway.Close)
+
+	status, result := call(t, http.MethodGet, gateway.URL+"/v1/android/tags/vip/devices/count", token, "")
+	if status != http.StatusBadGateway {
+		t.Fatalf("status %d want 502, result %v", status, result)
+	}
+	if msg := result["error"].(map[string]interface{})["message"]; msg != "upstream unavailable" {
+		t.Errorf("message %v want upstream unavailable", msg)
+	}
+	for _, leaked := range []string{"the-api-key", "apikey=", "sign="} {
+		if strings.Contains(logs.String(), leaked) {
+			t.Errorf("log %s leaks %s", logs.String(), leaked)
+		}
+	}
+	if !strings.Contains(logs.String(), "request failed") {
+		t.Errorf("log %s want the failed request", logs.String())
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		msg    string
+	}{
+		{badRequest("tag is required"), http.StatusBadRequest, "tag is required"},
+		{errors.New("invalid parameter: deploy_status is not allowed in API PushMsgToBatchDevices"), http.StatusBadRequest, "invalid parameter: deploy_status is not allowed in API PushMsgToBatchDevices"},
+		{&baidupush.ServiceError{Code: 30602, Msg: "request params not valid"}, http.StatusBadGateway, "30602 - request params not valid"},
+		{&url.Error{Op: "Post", URL: "http://host/?sign=x", Err: errors.New("connection refused")}, http.StatusBadGateway, "upstream unavailable"},
+		{&url.Error{Op: "Post", URL: "http://host/?sign=x", Err: context.DeadlineExceeded}, http.StatusGatewayTimeout, "upstream timed out"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		writeError(w, c.err)
+		result := map[string]map[string]interface{}{}
+		json.NewDecoder(w.Body).Decode(&result)
+		if w.Code != c.status || result["error"]["message"] != c.msg {
+			t.Errorf("error %v written %d %v want %d %s", c.err, w.Code, result["error"]["message"], c.status, c.msg)
+		}
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(service.Close)
+	host := strings.TrimPrefix(service.URL, "http://")
+	channels := map[string]*baidupush.Channel{
+		"android": baidupush.NewChannel(host, "key", "secret", baidupush.AndroidDeviceType),
+	}
+	clients, _ := newClients([]clientConfig{{Name: "billing", Token: token, Rate: 1, Burst: 2}}, time.Now)
+	s := newServer(channels, clients, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.timeout = 50 * time.Millisecond
+	gateway := httptest.NewServer(s)
+	t.Cleanup(gateway.Close)
+
+	if status, result := call(t, http.MethodGet, gateway.URL+"/v1/android/tags/vip/devices/count", token, ""); status != http.StatusGatewayTimeout {
+		t.Errorf("status %d want 504, result %v", status, result)
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	baidupush "github.com/leesper/baidupush-golang"
+)
+
+// command runs with the arguments after its name.
+type command func(env *environment, args []string) error
+
+var commands = map[string]command{
+	"push":   subcommands("push", map[string]command{"single": pushSingle, "all": pushAll, "tag": pushTag, "batch": pushBatch}),
+	"tag":    subcommands("tag", map[string]command{"create": tagCreate, "delete": tagDelete, "add": tagAdd, "remove": tagRemove, "count": tagCount, "list": tagList}),
+	"timer":  subcommands("timer", map[string]command{"list": timerList, "cancel": timerCancel}),
+	"topic":  subcommands("topic", map[string]command{"list": topicList, "records": topicRecords, "stats": topicStats}),
+	"report": subcommands("report", map[string]command{"devices": reportDevices}),
+	"status": status,
+}
+
+func subcommands(name string, subs map[string]command) command {
+	return func(env *environment, args []string) error {
+		if len(args) == 0 {
+			return fmt.Errorf("%w: %s requires a subcommand", errUsage, name)
+		}
+		sub, ok := subs[args[0]]
+		if !ok {
+			return fmt.Errorf("%w: unknown subcommand %s %s", errUsage, name, args[0])
+		}
+		return sub(env, args[1:])
+	}
+}
+
+// parseArgs parses flags in fs and checks the number of positional arguments
+// is at least min and at most max, max < 0 means no limit.
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errUsage, fs.Name(), err)
+	}
+	n := fs.NArg()
+	if n < min || (max >= 0 && n > max) {
+		return nil, fmt.Errorf("%w: %s: wrong number of arguments", errUsage, fs.Name())
+	}
+	return fs.Args(), nil
+}
+
+// pushOptions are optional parameters of push APIs.
+type pushOptions struct {
+	msgType, msgExpires, deployStatus, sendTime, topic string
+}
+
+// register adds flags of the optional parameters named by keys to fs.
+func (po *pushOptions) register(fs *flag.FlagSet, keys ...string) {
+	for _, k := range keys {
+		switch k {
+		case "msg_type":
+			fs.StringVar(&po.msgType, "msg-type", "", "type of message, notice or message")
+		case "msg_expires":
+			fs.StringVar(&po.msgExpires, "msg-expires", "", "seconds before message expires, 0-604800")
+		case "deploy_status":
+			fs.StringVar(&po.deployStatus, "deploy-status", "", "iOS deploy status, dev or prod")
+		case "send_time":
+			fs.StringVar(&po.sendTime, "send-time", "", "send time of timed message, RFC 3339 or UNIX timestamp")
+		case "topic_id":
+			fs.StringVar(&po.topic, "topic", "", "topic of message")
+		}
+	}
+}
+
+func (po *pushOptions) values() (url.Values, error) {
+	opts := url.Values{}
+	switch po.msgType {
+	case "":
+	case "notice":
+		opts.Set("msg_type", strconv.Itoa(baidupush.MsgTypeNotice))
+	case "message":
+		opts.Set("msg_type", strconv.Itoa(baidupush.MsgTypeMessage))
+	default:
+		return nil, fmt.Errorf("%w: invalid msg type %q - must be notice or message", errUsage, po.msgType)
+	}
+	if po.msgExpires != "" {
+		opts.Set("msg_expires", po.msgExpires)
+	}
+	switch po.deployStatus {
+	case "":
+	case "dev":
+		opts.Set("deploy_status", strconv.Itoa(baidupush.DeployStatusDevelop))
+	case "prod":
+		opts.Set("deploy_status", strconv.Itoa(baidupush.DeployStatusProduct))
+	default:
+		return nil, fmt.Errorf("%w: invalid deploy status %q - must be dev or prod", errUsage, po.deployStatus)
+	}
+	if po.sendTime != "" {
+		ts, err := parseTime(po.sendTime)
+		if err != nil {
+			return nil, err
+		}
+		opts.Set("send_time", ts)
+	}
+	if po.topic != "" {
+		opts.Set("topic_id", po.topic)
+	}
+	return opts, nil
+}
+
+// pageOptions are the start and limit parameters of listing APIs.
+type pageOptions struct {
+	start, limit int
+}
+
+func (po *pageOptions) register(fs *flag.FlagSet) {
+	fs.IntVar(&po.start, "start", 0, "start position of returned records")
+	fs.IntVar(&po.limit, "limit", 0, "number of returned records, 1-100")
+}
+
+// paged reports whether a page is asked for rather than all records.
+func (po *pageOptions) paged() bool {
+	return po.start > 0 || po.limit > 0
+}
+
+func (po *pageOptions) values() url.Values {
+	opts := url.Values{}
+	if po.start > 0 {
+		opts.Set("start", strconv.Itoa(po.start))
+	}
+	if po.limit > 0 {
+		opts.Set("limit", strconv.Itoa(po.limit))
+	}
+	return opts
+}
+
+// parseTime converts RFC 3339 time or UNIX timestamp s to UNIX timestamp.
+func parseTime(s string) (string, error) {
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return s, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid time %q - must be RFC 3339 or UNIX timestamp", errUsage, s)
+	}
+	return strconv.FormatInt(t.Unix(), 10), nil
+}
+
+// readMsg returns msg, or standard input if msg is "-".
+func (env *environment) readMsg(msg string) (string, error) {
+	if msg != "-" {
+		return msg, nil
+	}
+	data, err := io.ReadAll(env.stdin)
+	return strings.TrimSpace(string(data)), err
+}
+
+// pushResult is the result of push commands.
+type pushResult struct {
+	MsgID    string `json:"msg_id"`
+	TimerID  string `json:"timer_id,omitempty"`
+	SendTime int64  `json:"send_time"`
+}
+
+func (env *environment) printPush(r pushResult) error {
+	t := table{headers: []string{"MSG_ID", "TIMER_ID", "SEND_TIME"}}
+	timerID := r.TimerID
+	if timerID == "" {
+		timerID = "-"
+	}
+	t.add(r.MsgID, timerID, unixTime(r.SendTime))
+	return env.out.print(r, t)
+}
+
+// pushSetup parses the flags and arguments of push commands.
+func (env *environment) pushSetup(name string, args []string, min, max int, keys ...string) (*baidupush.Channel, []string, url.Values, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	po := &pushOptions{}
+	po.register(fs, keys...)
+	rest, err := parseArgs(fs, args, min, max)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	opts, err := po.values()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	bc, err := env.channel()
+	return bc, rest, opts, err
+}
+
+func pushSingle(env *environment, args []string) error {
+	bc, rest, opts, err := env.pushSetup("push single", args, 2, 2, "msg_type", "msg_expires", "deploy_status")
+	if err != nil {
+		return err
+	}
+	msg, err := env.readMsg(rest[1])
+	if err != nil {
+		return err
+	}
+	msgID, sendTime, err := bc.PushMsgToSingleDevice(rest[0], msg, opts)
+	if err != nil {
+		return err
+	}
+	return env.printPush(pushResult{MsgID: msgID, SendTime: sendTime})
+}
+
+func pushAll(env *environment, args []string) error {
+	bc, rest, opts, err := env.pushSetup("push all", args, 1, 1, "msg_type", "msg_expires", "deploy_status", "send_time")
+	if err != nil {
+		return err
+	}
+	msg, err := env.readMsg(rest[0])
+	if err != nil {
+		return err
+	}
+	msgID, timerID, sendTime, err := bc.PushMsgToAllDevices(msg, opts)
+	if err != nil {
+		return err
+	}
+	return env.printPush(pushResult{MsgID: msgID, TimerID: timerID, SendTime: sendTime})
+}
+
+func pushTag(env *environment, args []string) error {
+	bc, rest, opts, err := env.pushSetup("push tag", args, 2, 2, "msg_type", "msg_expires", "deploy_status", "send_time")
+	if err != nil {
+		return err
+	}
+	msg, err := env.readMsg(rest[1])
+	if err != nil {
+		return err
+	}
+	msgID, timerID, sendTime, err := bc.PushMsgToTaggedDevices(rest[0], msg, opts)
+	if err != nil {
+		return err
+	}
+	return env.printPush(pushResult{MsgID: msgID, TimerID: timerID, SendTime: sendTime})
+}
+
+func pushBatch(env *environment, args []string) error {
+	bc, rest, opts, err := env.pushSetup("push batch", args, 2, -1, "msg_type", "msg_expires", "topic_id")
+	if err != nil {
+		return err
+	}
+	msg, err := env.readMsg(rest[0])
+	if err != nil {
+		return err
+	}
+	msgID, sendTime, err := bc.PushMsgToBatchDevices(rest[1:], msg, opts)
+	if err != nil {
+		return err
+	}
+	return env.printPush(pushResult{MsgID: msgID, SendTime: sendTime})
+}
+
+func tagCreate(env *environment, args []string) error {
+	return manageTag(env, "tag create", args, (*baidupush.Channel).CreateTag)
+}
+
+func tagDelete(env *environment, args []string) error {
+	return manageTag(env, "tag delete", args, (*baidupush.Channel).DeleteTag)
+}
+
+func manageTag(env *environment, name string, args []string, manage func(*baidupush.Channel, string) (string, error)) error {
+	rest, err := parseArgs(flag.NewFlagSet(name, flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	bc, err := env.channel()
+	if err != nil {
+		return err
+	}
+	tag, err := manage(bc, rest[0])
+	if err != nil {
+		return err
+	}
+	t := table{headers: []string{"TAG"}}
+	t.add(tag)
+	return env.out.print(map[string]string{"tag": tag}, t)
+}
+
+func tagAdd(env *environment, args []string) error {
+	return manageTagDevices(env, "tag add", args, (*baidupush.Channel).AddTagDevices)
+}
+
+func tagRemove(env *environment, args []string) error {
+	return manageTagDevices(env, "tag remove", args, (*baidupush.Channel).DeleteTagDevices)
+}
+
+func manageTagDevices(env *environment, name string, args []string, manage func(*baidupush.Channel, string, []string) ([]baidupush.TagResult, error)) error {
+	rest, err := parseArgs(flag.NewFlagSet(name, flag.ContinueOnError), args, 2, -1)
+	if err != nil {
+		return err
+	}
+	bc, err := env.channel()
+	if err != nil {
+		return err
+	}
+	results, err := manage(bc, rest[0], rest[1:])
+	if err != nil {
+		return err
+	}
+	t := table{headers: []string{"CHANNEL_ID", "RESULT"}}
+	for _, r := range results {
+		res := "ok"
+		if r.Res != 0 {
+			res = "failed"
+		}
+		t.add(r.ChnID, res)
+	}
+	return env.out.print(results, t)
+}
+
+func tagCount(env *environment, args []string) error {
+	rest, err := parseArgs(flag.NewFlagSet("tag count", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	bc, err := env.channel()
+	if err != nil {
+		return err
+	}
+	num, err := bc.GetTagDevicesNumber(rest[0])
+	if err != nil {
+		return err
+	}
+	t := table{headers: []string{"TAG", "DEVICES"}}
+	t.add(rest[0], num)
+	return env.out.print(map[string]interface{}{"tag": rest[0], "device_num": num}, t)
+}
+
+func tagList(env *environment, args []string) error {
+	fs := flag.NewFlagSet("tag list", flag.ContinueOnError)
+	page := &pageOptions{}
+	page.register(fs)
+	tag := fs.String("tag", "", "only list the tag")
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	bc, err := env.channel()
+	if err != nil {
+		return err
+	}
+	if e := env.out.exporter(); e != nil && !page.paged() && *tag == "" {
+		_, err = bc.ExportTagsInfo(e)
+		return err
+	}
+	opts := page.values()
+	if *tag != "" {
+		opts.Set("tag", *tag)
+	}
+	total, infos, err := bc.QueryTagsInfo(opts)
+	if err != nil {
+		return err
+	}
+	t := table{headers: []string{"TID", "TAG", "INFO", "CREATE_TIME"}}
+	for _, info := range infos {
+		t.add(info.TID, info.Tag, info.Info, unixTime(info.CreateTime))
+	}
+	return env.out.print(map[string]interface{}{"total_num": total, "result": infos}, t)
+}
+
+func timerList(env *environment, args []string) error {
+	fs := flag.NewFlagSet("timer list", flag.ContinueOnError)
+	page := &pageOptions{}
+	page.register(fs)
+	timerID := fs.String("timer", "", "only list the timer task")
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	bc, err := env.channel()
+	if err != nil {
+		return err
+	}
+	if e := env.out.exporter(); e != nil && !page.paged() && *timerID == "" {
+		_, err = bc.ExportTimerTasks(e)
+		return err
+	}
+	opts := page.values()
+	if *timerID != "" {
+		opts.Set("timer_id", *timerID)
+	}
+	total, timers, err := bc.QueryTimerTasks(opts)
+	if err != nil {
+		return err
+	}
+	t := table{headers: []string{"TIMER_ID", "SEND_TIME", "MSG_TYPE", "RANGE_TYPE", "MSG"}}
+	for _, timer := range timers {
+		t.add(timer.ID, unixTime(timer.SendTime), timer.MsgType, timer.RangeType, timer.Msg)
+	}
+	return env.out.print(map[string]interface{}{"total_num": total, "result": timers}, t)
+}
+
+func timerCancel(env *environment, args []string) error {
+	rest, err := parseArgs(flag.NewFlagSet("timer cancel", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	bc, err := env.channel()
+	if err != nil {
+		return err
+	}
+	if err = bc.CancelTimerTask(rest[0]); err != nil {
+		return err
+	}
+	t := table{headers: []string{"TIMER_ID", "STATUS"}}
+	t.add(rest[0], "canceled")
+	return env.out.print(map[string]string{"timer_id": rest[0], "status": "canceled"}, t)
+}
+
+func topicList(env *environment, args []string) error {
+	fs := flag.NewFlagSet("topic list", flag.ContinueOnError)
+	page := &pageOptions{}
+	page.register(fs)
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	bc, err := env.channel()
+	if err != nil {
+		return err
+	}
+	if e := env.out.exporter(); e != nil && !page.paged() {
+		_, err = bc.ExportTopicList(e)
+		return err
+	}
+	total, topics, err := bc.QueryTopicList(page.values())
+	if err != nil {
+		return err
+	}
+	t := table{headers: []string{"TOPIC_ID", "PUSH_COUNT", "ACK_COUNT", "FIRST_TIME", "LAST_TIME"}}
+	for _, topic := range topics {
+		t.add(topic.Topic, topic.PushCount, topic.AckCount, unixTime(topic.FirstTime), unixTime(topic.LastTime))
+	}
+	return env.out.print(map[string]interface{}{"total_num": total, "result": topics}, t)
+}
+
+func topicRecords(env *environment, args []string) error {
+	fs := flag.NewFlagSet("topic records", flag.ContinueOnError)
+	page := &pageOptions{}
+	page.register(fs)
+	from := fs.String("from", "", "start time to query, RFC 3339 or UNIX timestamp")
+	to := fs.String("to", "", "end time to query, RFC 3339 or UNIX timestamp")
+	rest, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	opts := page.values()
+	for key, val := range map[string]string{"range_start": *from, "range_end": *to} {
+		if val == "" {
+			continue
+		}
+		ts, err := parseTime(val)
+		if err != nil {
+			return err
+		}
+		opts.Set(key, ts)
+	}
+	bc, err := env.channel()
+	if err != nil {
+		return err
+	}
+	if e := env.out.exporter(); e != nil && !page.paged() {
+		_, err = bc.ExportTopicRecords(e, rest[0], opts)
+		return err
+	}
+	topic, results, err := bc.QueryTopicRecords(rest[0], opts)
+	if err != nil {
+		return err
+	}
+	return env.printMessages(map[string]interface{}{"topic_id": topic, "result": results}, results)
+}
+
+func topicStats(env *environment, args []string) error {
+	rest, err := parseArgs(flag.NewFlagSet("topic stats", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	bc, err := env.channel()
+	if err != nil {
+		return err
+	}
+	if e := env.out.exporter(); e != nil {
+		_, err = bc.ExportTopicStatistics(e, rest[0])
+		return err
+	}
+	total, stats, err := bc.ReportTopicStatistics(rest[0])
+	if err != nil {
+		return err
+	}
+	t := table{headers: []string{"DAY", "ACK"}}
+	for _, stat := range stats {
+		t.add(unixTime(stat.Day), stat.Ack)
+	}
+	return env.out.print(map[string]interface{}{"total_num": total, "result": stats}, t)
+}
+
+func reportDevices(env *environment, args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("report devices", flag.ContinueOnError), args, 0, 0); err != nil {
+		return err
+	}
+	bc, err := env.channel()
+	if err != nil {
+		return err
+	}
+	if e := env.out.exporter(); e != nil {
+		_, err = bc.ExportDeviceStatistics(e)
+		return err
+	}
+	total, stats, err := bc.ReportDeviceStatistics()
+	if err != nil {
+		return err
+	}
+	t := table{headers: []string{"DAY", "NEW", "LOST", "ONLINE", "ADDEDUP", "AVAILABLE"}}
+	for _, stat := range stats {
+		t.add(unixTime(stat.Day), stat.DailyNewUser, stat.DailyLostUser, stat.DailyOnline, stat.AddedupTerm, stat.AvailChnID)
+	}
+	return env.out.print(map[string]interface{}{"total_num": total, "result": stats}, t)
+}
+
+func status(env *environment, args []string) error {
+	rest, err := parseArgs(flag.NewFlagSet("status", flag.ContinueOnError), args, 1, -1)
+	if err != nil {
+		return err
+	}
+	bc, err := env.channel()
+	if err != nil {
+		return err
+	}
+	if e := env.out.exporter(); e != nil {
+		_, err = bc.ExportMsgStatus(e, rest)
+		return err
+	}
+	msgID := rest[0]
+	if len(rest) > 1 {
+		data, err := json.Marshal(rest)
+		if err != nil {
+			return err
+		}
+		msgID = string(data)
+	}
+	total, results, err := bc.QueryMsgStatus(msgID)
+	if err != nil {
+		return err
+	}
+	return env.printMessages(map[string]interface{}{"total_num": total, "result": results}, results)
+}
+
+func (env *environment) printMessages(v interface{}, results []baidupush.MessageResult) error {
+	t := table{headers: []string{"MSG_ID", "STATUS", "SUCCESS", "SEND_TIME"}}
+	for _, r := range results {
+		t.add(r.MsgID, r.Status, r.Success, unixTime(r.SendTime))
+	}
+	return env.out.print(v, t)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+
+	baidupush "github.com/leesper/baidupush-golang"
+)
+
+// environment is what commands run with.
+type environment struct {
+	stdin      io.Reader
+	out        *output
+	flags      baidupush.ChannelConfig
+	configPath string
+	getenv     func(string) string
+}
+
+// channel returns a channel configured by flags, then environment variables,
+// then the config file.
+func (env *environment) channel() (*baidupush.Channel, error) {
+	configPath := env.configPath
+	if configPath == "" {
+		configPath = env.getenv("BAIDUPUSH_CONFIG")
+	}
+
+	cfg := &baidupush.Config{}
+	if configPath != "" {
+		var err error
+		if cfg, err = baidupush.ReadConfig(configPath); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ApplyEnv(env.getenv); err != nil {
+		return nil, err
+	}
+
+	flags := map[*string]string{
+		&cfg.Host:       env.flags.Host,
+		&cfg.APIKey:     env.flags.APIKey,
+		&cfg.Secret:     env.flags.Secret,
+		&cfg.DeviceType: env.flags.DeviceType,
+	}
+	for field, val := range flags {
+		if val != "" {
+			*field = val
+		}
+	}
+	return cfg.ChannelConfig.NewChannel()
+}
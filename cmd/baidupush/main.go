@@ -0,0 +1,117 @@
+// Command baidupush operates Baidu Cloud Push Service from the command line.
+//
+// Usage:
+//
+//	baidupush [global flags] <command> <subcommand> [flags] [args]
+//
+// Commands:
+//
+//	push single <channel_id> <msg>     push a message to a single device
+//	push all <msg>                     push a message to all devices
+//	push tag <tag> <msg>               push a message to devices under tag
+//	push batch <msg> <channel_id>...   push a message to a batch of devices
+//	tag create <tag>                   create a tag
+//	tag delete <tag>                   delete a tag
+//	tag add <tag> <channel_id>...      add devices to tag
+//	tag remove <tag> <channel_id>...   delete devices from tag
+//	tag count <tag>                    number of devices under tag
+//	tag list                           list tags
+//	timer list                         list timer tasks not executed yet
+//	timer cancel <timer_id>            cancel a timer task
+//	topic list                         list topics
+//	topic records <topic_id>           records of messages under topic
+//	topic stats <topic_id>             statistics of topic
+//	report devices                     statistics of devices
+//	status <msg_id>...                 status of messages
+//
+// A msg argument of "-" is read from standard input.
+//
+// Output formats csv and jsonl export the records of list, records, stats,
+// report and status commands for analysis, walking all pages unless -start or
+// -limit is given, with timestamps in RFC 3339.
+//
+// Credentials are taken from flags, then the environment variables
+// BAIDUPUSH_HOST, BAIDUPUSH_API_KEY, BAIDUPUSH_SECRET and BAIDUPUSH_DEVICE_TYPE,
+// then the config file given by -config or BAIDUPUSH_CONFIG, which is read by
+// baidupush.ReadConfig and could be YAML, JSON or TOML:
+//
+//	{"host": "api.tuisong.baidu.com", "api_key": "...", "secret": "...", "device_type": "android"}
+//
+// Timeouts, retries and rate limits are taken from the config file and the
+// other BAIDUPUSH_* environment variables of baidupush.Config.ApplyEnv.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	baidupush "github.com/leesper/baidupush-golang"
+)
+
+var errUsage = errors.New("usage error")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Getenv, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit code.
+func run(args []string, getenv func(string) string, stdin io.Reader, stdout, stderr io.Writer) int {
+	global := flag.NewFlagSet("baidupush", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	global.Usage = func() {
+		fmt.Fprintln(stderr, "usage: baidupush [global flags] <command> <subcommand> [flags] [args]")
+		fmt.Fprintln(stderr, "\ncommands: push, tag, timer, topic, report, status; see go doc for details")
+		fmt.Fprintln(stderr, "\nglobal flags:")
+		global.PrintDefaults()
+	}
+
+	flags := baidupush.ChannelConfig{}
+	configPath := global.String("config", "", "path of YAML, JSON or TOML config file (env BAIDUPUSH_CONFIG)")
+	global.StringVar(&flags.Host, "host", "", "host of the service (env BAIDUPUSH_HOST)")
+	global.StringVar(&flags.APIKey, "apikey", "", "API key (env BAIDUPUSH_API_KEY)")
+	global.StringVar(&flags.Secret, "secret", "", "API secret (env BAIDUPUSH_SECRET)")
+	global.StringVar(&flags.DeviceType, "device", "", "device type, android or ios (env BAIDUPUSH_DEVICE_TYPE)")
+	format := global.String("output", "table", "output format, table, json, csv or jsonl")
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+
+	out, err := newOutput(*format, stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, "baidupush:", err)
+		return 2
+	}
+
+	if global.NArg() == 0 {
+		global.Usage()
+		return 2
+	}
+
+	cmd, ok := commands[global.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "baidupush: unknown command %q\n", global.Arg(0))
+		global.Usage()
+		return 2
+	}
+
+	env := &environment{
+		stdin:      stdin,
+		out:        out,
+		flags:      flags,
+		configPath: *configPath,
+		getenv:     getenv,
+	}
+	err = cmd(env, global.Args()[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintln(stderr, "baidupush:", err)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "baidupush:", err)
+		return 1
+	}
+	return 0
+}
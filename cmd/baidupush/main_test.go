@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeService answers push and tag APIs, recording the form of each request.
+func fakeService(t *testing.T, forms *[]map[string]string) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form := map[string]string{"path": r.URL.Path}
+		for k := range r.Form {
+			form[k] = r.Form.Get(k)
+		}
+		*forms = append(*forms, form)
+
+		rsp := map[string]interface{}{"request_id": 1}
+		switch r.URL.Path {
+		case "/rest/3.0/push/all":
+			rsp["response_params"] = map[string]interface{}{"msg_id": "m1", "timer_id": "t1", "send_time": 1486000000}
+		case "/rest/3.0/tag/device_num":
+			rsp["response_params"] = map[string]interface{}{"device_num": 42}
+		case "/rest/3.0/topic/query_list":
+			result := []interface{}{}
+			if r.Form.Get("start") == "0" {
+				result = append(result, map[string]interface{}{"topic_id": "spring", "push_cnt": 10, "ack_cnt": 4, "ctime": 1486000000, "mtime": 1486000000})
+			}
+			rsp["response_params"] = map[string]interface{}{"total_num": 1, "result": result}
+		default:
+			rsp["error_code"] = 30611
+		}
+		json.NewEncoder(w).Encode(rsp)
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestPushAllJSON(t *testing.T) {
+	forms := []map[string]string{}
+	host := fakeService(t, &forms)
+	env := map[string]string{"BAIDUPUSH_API_KEY": "key", "BAIDUPUSH_SECRET": "secret"}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+	args := []string{"-host", host, "-device", "ios", "-output", "json", "push", "all", "-msg-type", "notice", "-send-time", "2017-02-10T00:00:00Z", "-"}
+	code := run(args, func(k string) string { return env[k] }, strings.NewReader(`{"title":"hello"}`+"\n"), stdout, stderr)
+	if code != 0 {
+		t.Fatalf("exit code %d want 0, stderr %s", code, stderr)
+	}
+
+	result := pushResult{}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatal("decode output error", err)
+	}
+	if result.MsgID != "m1" || result.TimerID != "t1" || result.SendTime != 1486000000 {
+		t.Errorf("result %+v want m1 t1 1486000000", result)
+	}
+
+	form := forms[0]
+	want := map[string]string{"apikey": "key", "device_type": "4", "msg_type": "1", "send_time": "1486684800", "msg": `{"title":"hello"}`}
+	for k, v := range want {
+		if form[k] != v {
+			t.Errorf("%s = %q want %q", k, form[k], v)
+		}
+	}
+}
+
+func TestTagCountTableWithConfigFile(t *testing.T) {
+	forms := []map[string]string{}
+	host := fakeService(t, &forms)
+	config := filepath.Join(t.TempDir(), "baidupush.json")
+	data := `{"host": "` + host + `", "api_key": "file-key", "secret": "file-secret"}`
+	if err := os.WriteFile(config, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"BAIDUPUSH_CONFIG": config, "BAIDUPUSH_API_KEY": "env-key"}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+	code := run([]string{"tag", "count", "vip"}, func(k string) string { return env[k] }, nil, stdout, stderr)
+	if code != 0 {
+		t.Fatalf("exit code %d want 0, stderr %s", code, stderr)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || strings.Fields(lines[1])[1] != "42" {
+		t.Errorf("output %q want a row with 42 devices", stdout)
+	}
+	if forms[0]["apikey"] != "env-key" || forms[0]["device_type"] != "3" {
+		t.Errorf("apikey %s device type %s want env-key 3", forms[0]["apikey"], forms[0]["device_type"])
+	}
+}
+
+func TestTopicListJSONLines(t *testing.T) {
+	forms := []map[string]string{}
+	host := fakeService(t, &forms)
+	getenv := func(k string) string { return map[string]string{"BAIDUPUSH_API_KEY": "k", "BAIDUPUSH_SECRET": "s"}[k] }
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+	if code := run([]string{"-host", host, "-output", "jsonl", "topic", "list"}, getenv, nil, stdout, stderr); code != 0 {
+		t.Fatalf("exit code %d want 0, stderr %s", code, stderr)
+	}
+	want := `{"topic_id":"spring","push_cnt":10,"ack_cnt":4,"ctime":"2017-02-02T01:46:40Z","mtime":"2017-02-02T01:46:40Z"}` + "\n"
+	if stdout.String() != want {
+		t.Errorf("output %q want %q", stdout, want)
+	}
+	if forms[0]["start"] != "0" || forms[0]["limit"] != "100" {
+		t.Errorf("page %s %s want 0 100", forms[0]["start"], forms[0]["limit"])
+	}
+}
+
+func TestErrors(t *testing.T) {
+	forms := []map[string]string{}
+	host := fakeService(t, &forms)
+	getenv := func(k string) string { return map[string]string{"BAIDUPUSH_API_KEY": "k", "BAIDUPUSH_SECRET": "s"}[k] }
+
+	cases := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-host", host, "tag", "delete", "vip"}, 1},
+		{[]string{"-host", host, "tag", "delete"}, 2},
+		{[]string{"-host", host, "tag", "rename", "vip"}, 2},
+		{[]string{"-host", host, "unknown"}, 2},
+		{[]string{"-host", host, "-output", "xml", "report", "devices"}, 2},
+		{[]string{"-host", host, "push", "single", "-msg-type", "loud", "chn", "hi"}, 2},
+	}
+	for _, c := range cases {
+		stderr := &bytes.Buffer{}
+		if code := run(c.args, getenv, nil, &bytes.Buffer{}, stderr); code != c.code {
+			t.Errorf("%v exit code %d want %d, stderr %s", c.args, code, c.code, stderr)
+		}
+	}
+}
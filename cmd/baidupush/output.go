@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	baidupush "github.com/leesper/baidupush-golang"
+)
+
+// table is the tabular form of a command result.
+type table struct {
+	headers []string
+	rows    [][]string
+}
+
+func (t *table) add(cells ...interface{}) {
+	row := make([]string, len(cells))
+	for i, c := range cells {
+		row[i] = fmt.Sprint(c)
+	}
+	t.rows = append(t.rows, row)
+}
+
+// output prints command results as table, JSON, CSV or JSON Lines.
+type output struct {
+	json   bool
+	export baidupush.ExportFormat
+	w      io.Writer
+}
+
+func newOutput(format string, w io.Writer) (*output, error) {
+	switch format {
+	case "table":
+		return &output{w: w}, nil
+	case "json":
+		return &output{json: true, w: w}, nil
+	case "csv", "jsonl":
+		return &output{export: baidupush.ExportFormat(format), w: w}, nil
+	}
+	return nil, fmt.Errorf("invalid output format %q - must be table, json, csv or jsonl", format)
+}
+
+// exporter returns an exporter of all records if the output is CSV or JSON
+// Lines, or nil otherwise.
+func (o *output) exporter() *baidupush.Exporter {
+	if o.export == "" {
+		return nil
+	}
+	return baidupush.NewExporter(o.w, o.export)
+}
+
+// print writes v as indented JSON or a JSON line, or t as aligned columns or
+// CSV.
+func (o *output) print(v interface{}, t table) error {
+	switch {
+	case o.json:
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case o.export == baidupush.ExportJSONLines:
+		return json.NewEncoder(o.w).Encode(v)
+	case o.export == baidupush.ExportCSV:
+		cw := csv.NewWriter(o.w)
+		cw.Write(t.headers)
+		cw.WriteAll(t.rows)
+		return cw.Error()
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.headers, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// unixTime formats a UNIX timestamp in RFC 3339, or "-" if not set.
+func unixTime(ts int64) string {
+	if ts == 0 {
+		return "-"
+	}
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
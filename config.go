@@ -0,0 +1,327 @@
+package baidupush
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultChannelName is the name of the channel configured at the top level
+// of Config.
+const DefaultChannelName = "default"
+
+// Duration is a time.Duration written like "1.5s" or "2m" in config files.
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	val, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(val)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// RetryConfig configures the RetryPolicy of a channel, calls are not retried
+// if MaxAttempts is 0.
+type RetryConfig struct {
+	MaxAttempts int      `json:"max_attempts" yaml:"max_attempts" toml:"max_attempts"`
+	MinBackoff  Duration `json:"min_backoff" yaml:"min_backoff" toml:"min_backoff"`
+	MaxBackoff  Duration `json:"max_backoff" yaml:"max_backoff" toml:"max_backoff"`
+}
+
+// RateLimitConfig configures the rate limit of a channel, calls are not
+// limited if Rate is 0.
+type RateLimitConfig struct {
+	Rate  float64 `json:"rate" yaml:"rate" toml:"rate"`
+	Burst int     `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+// ChannelConfig configures a channel.
+type ChannelConfig struct {
+	// Host defaults to DefaultBaiduPushService.
+	Host   string `json:"host,omitempty" yaml:"host,omitempty" toml:"host,omitempty"`
+	APIKey string `json:"api_key,omitempty" yaml:"api_key,omitempty" toml:"api_key,omitempty"`
+	Secret string `json:"secret,omitempty" yaml:"secret,omitempty" toml:"secret,omitempty"`
+	// DeviceType is android(default) or ios.
+	DeviceType string `json:"device_type,omitempty" yaml:"device_type,omitempty" toml:"device_type,omitempty"`
+	// DeployStatus is development or production, for iOS only.
+	DeployStatus string          `json:"deploy_status,omitempty" yaml:"deploy_status,omitempty" toml:"deploy_status,omitempty"`
+	Timeout      Duration        `json:"timeout,omitempty" yaml:"timeout,omitempty" toml:"timeout,omitempty"`
+	Retry        RetryConfig     `json:"retry" yaml:"retry" toml:"retry"`
+	RateLimit    RateLimitConfig `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+}
+
+// inherit fills fields not set yet from parent, one by one down to the fields
+// of Retry and RateLimit.
+func (cfg *ChannelConfig) inherit(parent ChannelConfig) {
+	if cfg.Host == "" {
+		cfg.Host = parent.Host
+	}
+	if cfg.APIKey == "" {
+		cfg.APIKey = parent.APIKey
+	}
+	if cfg.Secret == "" {
+		cfg.Secret = parent.Secret
+	}
+	if cfg.DeviceType == "" {
+		cfg.DeviceType = parent.DeviceType
+	}
+	if cfg.DeployStatus == "" {
+		cfg.DeployStatus = parent.DeployStatus
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = parent.Timeout
+	}
+	if cfg.Retry.MaxAttempts == 0 {
+		cfg.Retry.MaxAttempts = parent.Retry.MaxAttempts
+	}
+	if cfg.Retry.MinBackoff == 0 {
+		cfg.Retry.MinBackoff = parent.Retry.MinBackoff
+	}
+	if cfg.Retry.MaxBackoff == 0 {
+		cfg.Retry.MaxBackoff = parent.Retry.MaxBackoff
+	}
+	if cfg.RateLimit.Rate == 0 {
+		cfg.RateLimit.Rate = parent.RateLimit.Rate
+	}
+	if cfg.RateLimit.Burst == 0 {
+		cfg.RateLimit.Burst = parent.RateLimit.Burst
+	}
+}
+
+// NewChannel returns a channel configured by cfg, opts are applied after the
+// options of cfg.
+func (cfg ChannelConfig) NewChannel(opts ...ChannelOption) (*Channel, error) {
+	if cfg.APIKey == "" || cfg.Secret == "" {
+		return nil, fmt.Errorf("API key and secret are required")
+	}
+	device, err := ParseDeviceType(cfg.DeviceType)
+	if err != nil {
+		return nil, err
+	}
+	host := cfg.Host
+	if host == "" {
+		host = DefaultBaiduPushService
+	}
+
+	cfgOpts := []ChannelOption{}
+	if cfg.DeployStatus != "" {
+		status, err := ParseDeployStatus(cfg.DeployStatus)
+		if err != nil {
+			return nil, err
+		}
+		cfgOpts = append(cfgOpts, WithDeployStatus(status))
+	}
+	if cfg.Timeout < 0 {
+		return nil, fmt.Errorf("invalid timeout %s", time.Duration(cfg.Timeout))
+	}
+	if cfg.Timeout > 0 {
+		cfgOpts = append(cfgOpts, WithTimeout(time.Duration(cfg.Timeout)))
+	}
+	if retry := cfg.Retry; retry.MaxAttempts > 0 {
+		policy := RetryPolicy{
+			MaxAttempts: retry.MaxAttempts,
+			MinBackoff:  time.Duration(retry.MinBackoff),
+			MaxBackoff:  time.Duration(retry.MaxBackoff),
+		}
+		if policy.MinBackoff == 0 {
+			policy.MinBackoff = DefaultRetryPolicy.MinBackoff
+		}
+		if policy.MaxBackoff < policy.MinBackoff {
+			policy.MaxBackoff = policy.MinBackoff
+		}
+		cfgOpts = append(cfgOpts, WithRetryPolicy(policy))
+	}
+	if limit := cfg.RateLimit; limit.Rate > 0 {
+		cfgOpts = append(cfgOpts, WithRateLimit(limit.Rate, limit.Burst))
+	}
+
+	return NewChannel(host, cfg.APIKey, cfg.Secret, device, append(cfgOpts, opts...)...), nil
+}
+
+// Config configures channels by name. The channel configured at the top level
+// is named DefaultChannelName, the ones in Channels inherit fields not set
+// from the top level.
+type Config struct {
+	ChannelConfig `yaml:",inline"`
+	Channels      map[string]ChannelConfig `json:"channels,omitempty" yaml:"channels,omitempty" toml:"channels,omitempty"`
+}
+
+// ReadConfig reads config file path, in YAML, JSON or TOML told by its
+// extension.
+func ReadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".json":
+		err = json.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		err = fmt.Errorf("unknown format %q - must be .yaml, .yml, .json or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides the top level of cfg by environment variables looked up
+// by getenv: BAIDUPUSH_HOST, BAIDUPUSH_API_KEY, BAIDUPUSH_SECRET,
+// BAIDUPUSH_DEVICE_TYPE, BAIDUPUSH_DEPLOY_STATUS, BAIDUPUSH_TIMEOUT,
+// BAIDUPUSH_RETRY_MAX_ATTEMPTS, BAIDUPUSH_RETRY_MIN_BACKOFF,
+// BAIDUPUSH_RETRY_MAX_BACKOFF, BAIDUPUSH_RATE_LIMIT and BAIDUPUSH_RATE_BURST.
+//
+// Channels in cfg.Channels are not overridden, they take the values of the
+// variables only through the fields they inherit from the top level, so a
+// field set by a named channel in the file wins over its variable.
+func (cfg *Config) ApplyEnv(getenv func(string) string) error {
+	strs := map[string]*string{
+		"BAIDUPUSH_HOST":          &cfg.Host,
+		"BAIDUPUSH_API_KEY":       &cfg.APIKey,
+		"BAIDUPUSH_SECRET":        &cfg.Secret,
+		"BAIDUPUSH_DEVICE_TYPE":   &cfg.DeviceType,
+		"BAIDUPUSH_DEPLOY_STATUS": &cfg.DeployStatus,
+	}
+	for name, field := range strs {
+		if val := getenv(name); val != "" {
+			*field = val
+		}
+	}
+
+	durations := map[string]*Duration{
+		"BAIDUPUSH_TIMEOUT":           &cfg.Timeout,
+		"BAIDUPUSH_RETRY_MIN_BACKOFF": &cfg.Retry.MinBackoff,
+		"BAIDUPUSH_RETRY_MAX_BACKOFF": &cfg.Retry.MaxBackoff,
+	}
+	for name, field := range durations {
+		if val := getenv(name); val != "" {
+			if err := field.UnmarshalText([]byte(val)); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	ints := map[string]*int{
+		"BAIDUPUSH_RETRY_MAX_ATTEMPTS": &cfg.Retry.MaxAttempts,
+		"BAIDUPUSH_RATE_BURST":         &cfg.RateLimit.Burst,
+	}
+	for name, field := range ints {
+		if val := getenv(name); val != "" {
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			*field = n
+		}
+	}
+
+	if val := getenv("BAIDUPUSH_RATE_LIMIT"); val != "" {
+		rate, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("BAIDUPUSH_RATE_LIMIT: %v", err)
+		}
+		cfg.RateLimit.Rate = rate
+	}
+	return nil
+}
+
+// NewChannels returns the channels configured by cfg by name, opts are applied
+// to every channel.
+func (cfg *Config) NewChannels(opts ...ChannelOption) (map[string]*Channel, error) {
+	configs := map[string]ChannelConfig{}
+	for name, c := range cfg.Channels {
+		c.inherit(cfg.ChannelConfig)
+		configs[name] = c
+	}
+	if _, ok := configs[DefaultChannelName]; !ok && cfg.APIKey != "" {
+		configs[DefaultChannelName] = cfg.ChannelConfig
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no channels configured")
+	}
+
+	channels := map[string]*Channel{}
+	for name, c := range configs {
+		bc, err := c.NewChannel(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %v", name, err)
+		}
+		channels[name] = bc
+	}
+	return channels, nil
+}
+
+// LoadConfig returns the channels configured by config file path and then
+// BAIDUPUSH_* environment variables overriding the file, see ApplyEnv. The
+// file defaults to BAIDUPUSH_CONFIG, channels are configured by environment
+// variables only if there is none.
+func LoadConfig(path string, opts ...ChannelOption) (map[string]*Channel, error) {
+	if path == "" {
+		path = os.Getenv("BAIDUPUSH_CONFIG")
+	}
+
+	cfg := &Config{}
+	if path != "" {
+		var err error
+		if cfg, err = ReadConfig(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ApplyEnv(os.Getenv); err != nil {
+		return nil, err
+	}
+	return cfg.NewChannels(opts...)
+}
+
+// ParseDeviceType parses android, ios or their device type numbers, an empty
+// string is android.
+func ParseDeviceType(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "", "android":
+		return AndroidDeviceType, nil
+	case "ios", "apple":
+		return AppleDeviceType, nil
+	}
+	device, err := strconv.Atoi(s)
+	if err != nil || (device != AndroidDeviceType && device != AppleDeviceType) {
+		return 0, fmt.Errorf("invalid device type %q - must be android or ios", s)
+	}
+	return device, nil
+}
+
+// ParseDeployStatus parses development, production or their deploy status
+// numbers.
+func ParseDeployStatus(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "development", "develop", "dev":
+		return DeployStatusDevelop, nil
+	case "production", "product", "prod":
+		return DeployStatusProduct, nil
+	}
+	status, err := strconv.Atoi(s)
+	if err != nil || (status != DeployStatusDevelop && status != DeployStatusProduct) {
+		return 0, fmt.Errorf("invalid deploy status %q - must be development or production", s)
+	}
+	return status, nil
+}
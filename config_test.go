@@ -0,0 +1,221 @@
+package baidupush
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal("write config error", err)
+	}
+	return path
+}
+
+func TestReadConfigFormats(t *testing.T) {
+	want := &Config{
+		ChannelConfig: ChannelConfig{
+			Host:      "push.example.com",
+			Timeout:   Duration(5 * time.Second),
+			Retry:     RetryConfig{MaxAttempts: 3, MinBackoff: Duration(time.Second), MaxBackoff: Duration(time.Minute)},
+			RateLimit: RateLimitConfig{Rate: 10, Burst: 20},
+		},
+		Channels: map[string]ChannelConfig{
+			"android": {APIKey: "android-key", Secret: "android-secret"},
+			"ios":     {APIKey: "ios-key", Secret: "ios-secret", DeviceType: "ios", DeployStatus: "development"},
+		},
+	}
+
+	files := map[string]string{
+		"baidupush.yaml": `
+host: push.example.com
+timeout: 5s
+retry: {max_attempts: 3, min_backoff: 1s, max_backoff: 1m}
+rate_limit: {rate: 10, burst: 20}
+channels:
+  android: {api_key: android-key, secret: android-secret}
+  ios: {api_key: ios-key, secret: ios-secret, device_type: ios, deploy_status: development}
+`,
+		"baidupush.json": `{
+  "host": "push.example.com",
+  "timeout": "5s",
+  "retry": {"max_attempts": 3, "min_backoff": "1s", "max_backoff": "1m"},
+  "rate_limit": {"rate": 10, "burst": 20},
+  "channels": {
+    "android": {"api_key": "android-key", "secret": "android-secret"},
+    "ios": {"api_key": "ios-key", "secret": "ios-secret", "device_type": "ios", "deploy_status": "development"}
+  }
+}`,
+		"baidupush.toml": `
+host = "push.example.com"
+timeout = "5s"
+retry = {max_attempts = 3, min_backoff = "1s", max_backoff = "1m"}
+rate_limit = {rate = 10.0, burst = 20}
+
+[channels.android]
+api_key = "android-key"
+secret = "android-secret"
+
+[channels.ios]
+api_key = "ios-key"
+secret = "ios-secret"
+device_type = "ios"
+deploy_status = "development"
+`,
+	}
+	for name, content := range files {
+		cfg, err := ReadConfig(writeConfig(t, name, content))
+		if err != nil {
+			t.Errorf("read %s error %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("config of %s %+v want %+v", name, cfg, want)
+		}
+	}
+
+	if _, err := ReadConfig(writeConfig(t, "baidupush.ini", "host = x")); err == nil {
+		t.Error("config of unknown format read")
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		if call.params.Get("channel_id") == "busy" || call.params.Get("tag") == "busy" {
+			return nil, 30600
+		}
+		return pushOK(call)
+	})
+	path := writeConfig(t, "baidupush.yaml", `
+host: `+srv.host()+`
+api_key: file-key
+secret: file-secret
+channels:
+  ios: {device_type: ios, deploy_status: development}
+`)
+	t.Setenv("BAIDUPUSH_CONFIG", path)
+	t.Setenv("BAIDUPUSH_API_KEY", "env-key")
+	t.Setenv("BAIDUPUSH_RETRY_MAX_ATTEMPTS", "3")
+	t.Setenv("BAIDUPUSH_RETRY_MIN_BACKOFF", "1ms")
+	t.Setenv("BAIDUPUSH_TIMEOUT", "2s")
+
+	channels, err := LoadConfig("")
+	if err != nil {
+		t.Fatal("load config error", err)
+	}
+	if len(channels) != 2 || channels[DefaultChannelName] == nil || channels["ios"] == nil {
+		t.Fatalf("channels %v want default and ios", channels)
+	}
+
+	ios := channels["ios"]
+	if ios.client.Timeout != 2*time.Second {
+		t.Errorf("timeout %s want 2s", ios.client.Timeout)
+	}
+	if _, _, err = ios.PushMsgToSingleDevice("chn", `{"aps":{"alert":"hi"}}`, nil); err != nil {
+		t.Fatal("push error", err)
+	}
+	params := srv.received()[0].params
+	if params.Get("apikey") != "env-key" || params.Get("device_type") != "4" || params.Get("deploy_status") != "1" {
+		t.Errorf("push params %v want env-key, device type 4 and deploy status 1", params)
+	}
+
+	if _, err = ios.GetTagDevicesNumber("busy"); ErrorCode(err) != 30600 {
+		t.Errorf("error %v want 30600", err)
+	}
+	if n := len(srv.received()); n != 4 {
+		t.Errorf("%d calls want 1 push and 3 attempts", n)
+	}
+
+	t.Setenv("BAIDUPUSH_TIMEOUT", "soon")
+	if _, err = LoadConfig(""); err == nil {
+		t.Error("invalid BAIDUPUSH_TIMEOUT accepted")
+	}
+}
+
+func TestConfigInheritsFieldByField(t *testing.T) {
+	cfg := &Config{
+		ChannelConfig: ChannelConfig{
+			APIKey:    "k",
+			Secret:    "s",
+			Retry:     RetryConfig{MaxAttempts: 3, MinBackoff: Duration(time.Millisecond), MaxBackoff: Duration(time.Second)},
+			RateLimit: RateLimitConfig{Rate: 10, Burst: 20},
+		},
+		Channels: map[string]ChannelConfig{
+			"ios": {
+				DeviceType: "ios",
+				Retry:      RetryConfig{MaxAttempts: 5},
+				RateLimit:  RateLimitConfig{Burst: 2},
+			},
+		},
+	}
+	if err := cfg.ApplyEnv(func(name string) string {
+		return map[string]string{"BAIDUPUSH_RETRY_MAX_ATTEMPTS": "4", "BAIDUPUSH_SECRET": "env-secret"}[name]
+	}); err != nil {
+		t.Fatal("apply env error", err)
+	}
+	channels, err := cfg.NewChannels()
+	if err != nil {
+		t.Fatal("new channels error", err)
+	}
+
+	ios := channels["ios"]
+	want := RetryPolicy{MaxAttempts: 5, MinBackoff: time.Millisecond, MaxBackoff: time.Second}
+	if ios.retry != want {
+		t.Errorf("retry policy %+v want %+v", ios.retry, want)
+	}
+	if ios.limiter.rate != 10 || ios.limiter.burst != 2 {
+		t.Errorf("rate limit %v burst %v want 10 and 2", ios.limiter.rate, ios.limiter.burst)
+	}
+	if creds, _ := ios.credentials.Credentials(); creds.Secret != "env-secret" {
+		t.Errorf("secret %s want env-secret inherited", creds.Secret)
+	}
+	if channels[DefaultChannelName].retry.MaxAttempts != 4 {
+		t.Errorf("default retry %+v want 4 attempts from env", channels[DefaultChannelName].retry)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	invalid := []Config{
+		{},
+		{ChannelConfig: ChannelConfig{APIKey: "k"}},
+		{ChannelConfig: ChannelConfig{APIKey: "k", Secret: "s", DeviceType: "windows"}},
+		{ChannelConfig: ChannelConfig{APIKey: "k", Secret: "s", DeployStatus: "staging"}},
+		{Channels: map[string]ChannelConfig{"android": {APIKey: "k"}}},
+	}
+	for _, cfg := range invalid {
+		if _, err := cfg.NewChannels(); err == nil {
+			t.Errorf("config %+v accepted", cfg)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	slept := time.Duration(0)
+	l := newRateLimiter(2, 2)
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration) { slept += d }
+
+	l.wait()
+	l.wait()
+	if slept != 0 {
+		t.Errorf("slept %s within burst", slept)
+	}
+	l.wait()
+	if slept != 500*time.Millisecond {
+		t.Errorf("slept %s want 500ms", slept)
+	}
+
+	now = now.Add(10 * time.Second)
+	slept = 0
+	l.wait()
+	l.wait()
+	if slept != 0 {
+		t.Errorf("slept %s after bucket refilled", slept)
+	}
+}
@@ -0,0 +1,193 @@
+package baidupush
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Credentials are the API key and secret of an app.
+type Credentials struct {
+	APIKey string `json:"api_key"`
+	Secret string `json:"secret"`
+}
+
+// CredentialsProvider provides the credentials signing a request, it is
+// consulted once for every request so that credentials could be rotated
+// without rebuilding channels.
+type CredentialsProvider interface {
+	Credentials() (Credentials, error)
+}
+
+// WithCredentialsProvider makes channel sign requests with the credentials
+// provided by p instead of the key and secret given to NewChannel.
+func WithCredentialsProvider(p CredentialsProvider) ChannelOption {
+	return func(bc *Channel) {
+		bc.credentials = p
+	}
+}
+
+// StaticCredentials provides credentials never changed.
+type StaticCredentials Credentials
+
+// Credentials implements CredentialsProvider.
+func (c StaticCredentials) Credentials() (Credentials, error) {
+	return Credentials(c), nil
+}
+
+// CredentialsFunc is a callback providing credentials.
+type CredentialsFunc func() (Credentials, error)
+
+// Credentials implements CredentialsProvider.
+func (f CredentialsFunc) Credentials() (Credentials, error) {
+	return f()
+}
+
+// EnvCredentials provides credentials read from environment variables keyVar
+// and secretVar on every request.
+func EnvCredentials(keyVar, secretVar string) CredentialsProvider {
+	return CredentialsFunc(func() (Credentials, error) {
+		creds := Credentials{APIKey: os.Getenv(keyVar), Secret: os.Getenv(secretVar)}
+		if creds.APIKey == "" || creds.Secret == "" {
+			return creds, fmt.Errorf("environment variables %s and %s are required", keyVar, secretVar)
+		}
+		return creds, nil
+	})
+}
+
+// RotatingCredentials provides credentials swapped atomically by Rotate, a
+// request always gets the API key and the secret of the same credentials. It
+// is safe for concurrent use.
+type RotatingCredentials struct {
+	current atomic.Pointer[Credentials]
+}
+
+// NewRotatingCredentials returns a provider of creds until rotated.
+func NewRotatingCredentials(creds Credentials) *RotatingCredentials {
+	rc := &RotatingCredentials{}
+	rc.Rotate(creds)
+	return rc
+}
+
+// Rotate replaces the credentials provided.
+func (rc *RotatingCredentials) Rotate(creds Credentials) {
+	rc.current.Store(&creds)
+}
+
+// Credentials implements CredentialsProvider, it fails if no credentials were
+// rotated in.
+func (rc *RotatingCredentials) Credentials() (Credentials, error) {
+	creds := rc.current.Load()
+	if creds == nil {
+		return Credentials{}, errors.New("no credentials loaded")
+	}
+	return *creds, nil
+}
+
+// FileCredentials provides credentials stored in a JSON file like
+// {"api_key": "...", "secret": "..."}, the file is polled for changes and
+// reloaded, a change not loaded successfully leaves the former credentials in
+// use.
+type FileCredentials struct {
+	RotatingCredentials
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	lastErr error
+	done    chan struct{}
+	closed  sync.Once
+}
+
+// NewFileCredentials loads the credentials in file path and checks it for
+// changes every interval until closed, an interval not positive disables
+// checking, the file is then only loaded again by Reload.
+func NewFileCredentials(path string, interval time.Duration) (*FileCredentials, error) {
+	fc := &FileCredentials{
+		path: path,
+		done: make(chan struct{}),
+	}
+	if err := fc.Reload(); err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return fc, nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-fc.done:
+				return
+			case <-ticker.C:
+				if fc.changed() {
+					fc.Reload()
+				}
+			}
+		}
+	}()
+	return fc, nil
+}
+
+// changed reports whether the file was modified since last loaded.
+func (fc *FileCredentials) changed() bool {
+	info, err := os.Stat(fc.path)
+	if err != nil {
+		return false
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return !info.ModTime().Equal(fc.modTime) || info.Size() != fc.size
+}
+
+// Reload loads the file now.
+func (fc *FileCredentials) Reload() error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	info, err := os.Stat(fc.path)
+	if err == nil {
+		fc.modTime, fc.size = info.ModTime(), info.Size()
+		err = fc.load()
+	}
+	fc.lastErr = err
+	return err
+}
+
+func (fc *FileCredentials) load() error {
+	data, err := os.ReadFile(fc.path)
+	if err != nil {
+		return err
+	}
+	creds := Credentials{}
+	if err = json.Unmarshal(data, &creds); err != nil {
+		return fmt.Errorf("credentials file %s: %v", fc.path, err)
+	}
+	if creds.APIKey == "" || creds.Secret == "" {
+		return fmt.Errorf("credentials file %s: api_key and secret are required", fc.path)
+	}
+	fc.Rotate(creds)
+	return nil
+}
+
+// Err returns the error of the latest loading, or nil if it succeeded.
+func (fc *FileCredentials) Err() error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.lastErr
+}
+
+// Close stops watching the file, the credentials loaded are still provided.
+func (fc *FileCredentials) Close() error {
+	fc.closed.Do(func() {
+		close(fc.done)
+	})
+	return nil
+}
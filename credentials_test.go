@@ -0,0 +1,160 @@
+package baidupush
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRotationNeverMixesCredentials(t *testing.T) {
+	secrets := map[string]string{}
+	for i := 0; i < 4; i++ {
+		secrets[fmt.Sprintf("key-%d", i)] = fmt.Sprintf("secret-%d", i)
+	}
+
+	var mismatches atomic.Int64
+	var srv *fakeServer
+	srv = newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		params := url.Values{}
+		for k, v := range call.params {
+			params[k] = v
+		}
+		sign := params.Get("sign")
+		params.Del("sign")
+		urlStr := fmt.Sprintf("http://%s/rest/3.0/%s/%s", srv.host(), call.apiClass, call.apiMethod)
+		if generateSign(call.httpMethod, urlStr, secrets[params.Get("apikey")], params) != sign {
+			mismatches.Add(1)
+			return nil, 30602
+		}
+		return pushOK(call)
+	})
+
+	creds := NewRotatingCredentials(Credentials{APIKey: "key-0", Secret: "secret-0"})
+	bc := NewChannel(srv.host(), "", "", AndroidDeviceType, WithCredentialsProvider(creds))
+
+	done := make(chan struct{})
+	rotated := make(chan struct{})
+	go func() {
+		defer close(rotated)
+		for i := 1; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				n := i % len(secrets)
+				creds.Rotate(Credentials{APIKey: fmt.Sprintf("key-%d", n), Secret: fmt.Sprintf("secret-%d", n)})
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if _, _, err := bc.PushMsgToSingleDevice("chn", "hello", nil); err != nil {
+					t.Error("push during rotation error", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-rotated
+
+	if n := mismatches.Load(); n != 0 {
+		t.Errorf("%d requests signed with mixed credentials", n)
+	}
+	keys := map[string]bool{}
+	for _, call := range srv.received() {
+		keys[call.params.Get("apikey")] = true
+	}
+	if len(keys) < 2 {
+		t.Logf("only keys %v used, rotation may not have overlapped requests", keys)
+	}
+}
+
+func TestFileCredentialsReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal("write credentials error", err)
+		}
+	}
+
+	write(`{"api_key": "old-key", "secret": "old-secret"}`)
+	fc, err := NewFileCredentials(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal("new file credentials error", err)
+	}
+	defer fc.Close()
+
+	write(`{"api_key": "new-key", "secret": "a-new-secret"}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if creds, _ := fc.Credentials(); creds.APIKey == "new-key" {
+			if creds.Secret != "a-new-secret" {
+				t.Errorf("secret %s want a-new-secret", creds.Secret)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("credentials file change not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	write(`{"api_key": "broken"`)
+	if err = fc.Reload(); err == nil || fc.Err() == nil {
+		t.Error("broken credentials file loaded")
+	}
+	if creds, _ := fc.Credentials(); creds.APIKey != "new-key" {
+		t.Errorf("api key %s after broken reload want new-key", creds.APIKey)
+	}
+}
+
+func TestCredentialsWithoutReloading(t *testing.T) {
+	if _, err := (&RotatingCredentials{}).Credentials(); err == nil {
+		t.Error("zero rotating credentials provided credentials")
+	}
+
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(path, []byte(`{"api_key": "key", "secret": "secret"}`), 0600); err != nil {
+		t.Fatal("write credentials error", err)
+	}
+	fc, err := NewFileCredentials(path, 0)
+	if err != nil {
+		t.Fatal("new file credentials error", err)
+	}
+	defer fc.Close()
+	if creds, err := fc.Credentials(); err != nil || creds.APIKey != "key" {
+		t.Errorf("credentials %v error %v want key", creds, err)
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	provider := EnvCredentials("TEST_BAIDUPUSH_KEY", "TEST_BAIDUPUSH_SECRET")
+	t.Setenv("TEST_BAIDUPUSH_KEY", "env-key")
+	t.Setenv("TEST_BAIDUPUSH_SECRET", "")
+	if _, err := provider.Credentials(); err == nil {
+		t.Error("credentials without secret provided")
+	}
+
+	t.Setenv("TEST_BAIDUPUSH_SECRET", "env-secret")
+	srv := newFakeServer(t, pushOK)
+	bc := NewChannel(srv.host(), "", "", AndroidDeviceType, WithCredentialsProvider(provider))
+	if _, _, err := bc.PushMsgToSingleDevice("chn", "hello", nil); err != nil {
+		t.Fatal("push error", err)
+	}
+	if key := srv.received()[0].params.Get("apikey"); key != "env-key" {
+		t.Errorf("api key %s want env-key", key)
+	}
+}
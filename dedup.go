@@ -0,0 +1,147 @@
+package baidupush
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDedupCapacity is the number of keys remembered by the default dedup store.
+	DefaultDedupCapacity = 10000
+	// DefaultDedupTTL is how long the default dedup store remembers a key.
+	DefaultDedupTTL = 24 * time.Hour
+)
+
+// DedupStore remembers the results of pushes by idempotency key. A store
+// shared by channels of different apps should be given keys unique among them.
+type DedupStore interface {
+	// Get returns the result of the push made with key, if it is still remembered.
+	Get(key string) (PushResult, bool)
+	// Put remembers the result of the push made with key.
+	Put(key string, result PushResult)
+}
+
+// WithDedupStore replaces the default in-memory store remembering pushes
+// with idempotency keys.
+func WithDedupStore(store DedupStore) ChannelOption {
+	return func(bc *Channel) {
+		bc.dedup = store
+	}
+}
+
+type dedupItem struct {
+	key     string
+	result  PushResult
+	expires time.Time
+}
+
+// MemoryDedupStore is a DedupStore keeping at most capacity keys in memory for
+// ttl each, the least recently used key is evicted first. It is safe for
+// concurrent use.
+type MemoryDedupStore struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	lru      *list.List
+	now      func() time.Time
+}
+
+// NewMemoryDedupStore returns a store remembering at most capacity keys for ttl.
+func NewMemoryDedupStore(capacity int, ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    map[string]*list.Element{},
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// Get implements DedupStore.
+func (s *MemoryDedupStore) Get(key string) (PushResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.items[key]
+	if !ok {
+		return PushResult{}, false
+	}
+	item := elem.Value.(*dedupItem)
+	if !s.now().Before(item.expires) {
+		s.lru.Remove(elem)
+		delete(s.items, key)
+		return PushResult{}, false
+	}
+	s.lru.MoveToFront(elem)
+	return item.result, true
+}
+
+// Put implements DedupStore.
+func (s *MemoryDedupStore) Put(key string, result PushResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := s.now().Add(s.ttl)
+	if elem, ok := s.items[key]; ok {
+		item := elem.Value.(*dedupItem)
+		item.result, item.expires = result, expires
+		s.lru.MoveToFront(elem)
+		return
+	}
+
+	s.items[key] = s.lru.PushFront(&dedupItem{key: key, result: result, expires: expires})
+	for s.lru.Len() > s.capacity {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.items, oldest.Value.(*dedupItem).key)
+	}
+}
+
+// Len returns the number of keys remembered, including expired ones not evicted yet.
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+// keyLocks serializes pushes made with the same idempotency key, so that
+// concurrent retries do not push twice.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (kl *keyLocks) lock(key string) {
+	kl.mu.Lock()
+	if kl.locks == nil {
+		kl.locks = map[string]*keyLock{}
+	}
+	l, ok := kl.locks[key]
+	if !ok {
+		l = &keyLock{}
+		kl.locks[key] = l
+	}
+	l.refs++
+	kl.mu.Unlock()
+
+	l.Lock()
+}
+
+func (kl *keyLocks) unlock(key string) {
+	kl.mu.Lock()
+	l := kl.locks[key]
+	l.refs--
+	if l.refs == 0 {
+		delete(kl.locks, key)
+	}
+	kl.mu.Unlock()
+
+	l.Unlock()
+}
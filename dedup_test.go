@@ -0,0 +1,106 @@
+package baidupush
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPushIdempotencyKey(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	bc := NewChannel(srv.host(), "key", "secret", AppleDeviceType)
+	req := PushRequest{Kind: PushSingle, ChannelID: "chn", Msg: "hello", IdempotencyKey: "order-42-shipped"}
+
+	var wg sync.WaitGroup
+	results := make([]PushResult, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := bc.Push(req)
+			if err != nil {
+				t.Error("push error", err)
+			}
+			results[i] = result
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(srv.received()); n != 1 {
+		t.Fatalf("service received %d pushes want 1", n)
+	}
+	duplicates := 0
+	for _, result := range results {
+		if result.MsgID != "msg-single_device" {
+			t.Errorf("msg ID %s want msg-single_device", result.MsgID)
+		}
+		if result.Duplicate {
+			duplicates++
+		}
+	}
+	if duplicates != len(results)-1 {
+		t.Errorf("duplicates %d want %d", duplicates, len(results)-1)
+	}
+
+	req.IdempotencyKey = "order-43-shipped"
+	if result, _ := bc.Push(req); result.Duplicate {
+		t.Error("push with another key is duplicate")
+	}
+	req.IdempotencyKey = ""
+	bc.Push(req)
+	bc.Push(req)
+	if n := len(srv.received()); n != 4 {
+		t.Errorf("service received %d pushes want 4", n)
+	}
+}
+
+func TestPushFailureNotRemembered(t *testing.T) {
+	fail := true
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		if fail {
+			return nil, 30600
+		}
+		return pushOK(call)
+	})
+	store := NewMemoryDedupStore(10, time.Hour)
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType, WithDedupStore(store))
+	req := PushRequest{Kind: PushAll, Msg: "hello", IdempotencyKey: "k"}
+
+	if _, err := bc.Push(req); err == nil {
+		t.Fatal("push succeeded, want error")
+	}
+	fail = false
+	result, err := bc.Push(req)
+	if err != nil || result.Duplicate {
+		t.Errorf("retry duplicate %t error %v want a new push", result.Duplicate, err)
+	}
+	if store.Len() != 1 {
+		t.Errorf("store length %d want 1", store.Len())
+	}
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryDedupStore(2, time.Minute)
+	store.now = func() time.Time { return now }
+
+	store.Put("a", PushResult{MsgID: "1"})
+	store.Put("b", PushResult{MsgID: "2"})
+	store.Get("a") // b becomes the least recently used
+	store.Put("c", PushResult{MsgID: "3"})
+
+	if _, ok := store.Get("b"); ok {
+		t.Error("b not evicted")
+	}
+	if r, ok := store.Get("a"); !ok || r.MsgID != "1" {
+		t.Errorf("a = %v %t want 1 true", r, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := store.Get("c"); ok {
+		t.Error("c not expired")
+	}
+	if store.Len() != 1 {
+		t.Errorf("length %d want 1", store.Len())
+	}
+}
@@ -0,0 +1,359 @@
+package baidupush
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Device is a device of a user, on which an app got a channel ID.
+type Device struct {
+	UserID    string    `json:"user_id"`
+	ChannelID string    `json:"channel_id"`
+	Platform  Platform  `json:"platform"`
+	App       string    `json:"app"`
+	LastSeen  time.Time `json:"last_seen"`
+	Locale    string    `json:"locale,omitempty"`
+	// DeadSince is when the channel ID was found dead, see DeviceMarker, or
+	// nil while it is alive.
+	DeadSince *time.Time `json:"dead_since,omitempty"`
+}
+
+// DeviceStore records the devices of users by channel ID.
+type DeviceStore interface {
+	// Register adds device, or replaces the device of the same channel ID
+	// which might belong to another user before.
+	Register(device Device) error
+	// Unregister removes the device of channelID, it is not an error if
+	// there is none.
+	Unregister(channelID string) error
+	// Devices returns the devices of user ordered by channel ID.
+	Devices(userID string) ([]Device, error)
+}
+
+// MemoryDeviceStore is a DeviceStore in memory. It is safe for concurrent use.
+type MemoryDeviceStore struct {
+	mu      sync.Mutex
+	devices map[string]Device          // by channel ID
+	users   map[string]map[string]bool // channel IDs by user ID
+	now     func() time.Time
+}
+
+// NewMemoryDeviceStore returns an empty store.
+func NewMemoryDeviceStore() *MemoryDeviceStore {
+	return &MemoryDeviceStore{
+		devices: map[string]Device{},
+		users:   map[string]map[string]bool{},
+		now:     time.Now,
+	}
+}
+
+// Register implements DeviceStore, LastSeen defaults to now.
+func (s *MemoryDeviceStore) Register(device Device) error {
+	if err := device.validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if device.LastSeen.IsZero() {
+		device.LastSeen = s.now()
+	}
+	s.register(device)
+	return nil
+}
+
+func (s *MemoryDeviceStore) register(device Device) {
+	s.unregister(device.ChannelID)
+	s.devices[device.ChannelID] = device
+	if s.users[device.UserID] == nil {
+		s.users[device.UserID] = map[string]bool{}
+	}
+	s.users[device.UserID][device.ChannelID] = true
+}
+
+// Unregister implements DeviceStore.
+func (s *MemoryDeviceStore) Unregister(channelID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unregister(channelID)
+	return nil
+}
+
+func (s *MemoryDeviceStore) unregister(channelID string) {
+	device, ok := s.devices[channelID]
+	if !ok {
+		return
+	}
+	delete(s.devices, channelID)
+	delete(s.users[device.UserID], channelID)
+	if len(s.users[device.UserID]) == 0 {
+		delete(s.users, device.UserID)
+	}
+}
+
+// MarkDead implements DeviceMarker.
+func (s *MemoryDeviceStore) MarkDead(channelID string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markDead(channelID, t)
+	return nil
+}
+
+func (s *MemoryDeviceStore) markDead(channelID string, t time.Time) {
+	if device, ok := s.devices[channelID]; ok {
+		device.DeadSince = &t
+		s.devices[channelID] = device
+	}
+}
+
+// Devices implements DeviceStore.
+func (s *MemoryDeviceStore) Devices(userID string) ([]Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := []Device{}
+	for channelID := range s.users[userID] {
+		devices = append(devices, s.devices[channelID])
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ChannelID < devices[j].ChannelID })
+	return devices, nil
+}
+
+// all returns every device ordered by channel ID.
+func (s *MemoryDeviceStore) all() []Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	devices := []Device{}
+	for _, device := range s.devices {
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ChannelID < devices[j].ChannelID })
+	return devices
+}
+
+func (d Device) validate() error {
+	if d.UserID == "" || d.ChannelID == "" || d.App == "" {
+		return errors.New("user ID, channel ID and app of device are required")
+	}
+	if d.Platform.DeviceType() == 0 {
+		return fmt.Errorf("device %s: invalid platform %q - must be android or ios", d.ChannelID, d.Platform)
+	}
+	return nil
+}
+
+// deviceRecord is a line in the log of FileDeviceStore, a device registered
+// or the channel ID of a device unregistered.
+type deviceRecord struct {
+	Device     *Device `json:"device,omitempty"`
+	Unregister string  `json:"unregister,omitempty"`
+}
+
+// FileDeviceStore is a DeviceStore persisted in a file, all devices are kept
+// in memory as well.
+//
+// The file is an append-only log of JSON lines, each one a device registered
+// or unregistered, it is rewritten with current devices by Compact. It is safe
+// for concurrent use.
+type FileDeviceStore struct {
+	mu  sync.Mutex
+	log *jsonLog
+	mem *MemoryDeviceStore
+}
+
+// OpenFileDeviceStore opens the store in file path, creating it if not existed.
+func OpenFileDeviceStore(path string) (*FileDeviceStore, error) {
+	s := &FileDeviceStore{mem: NewMemoryDeviceStore()}
+	var err error
+	s.log, err = openJSONLog(path, "device store", s.apply)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// apply keeps the device of record in memory, or forgets it.
+func (s *FileDeviceStore) apply(record deviceRecord) {
+	if record.Device != nil {
+		s.mem.register(*record.Device)
+	} else {
+		s.mem.unregister(record.Unregister)
+	}
+}
+
+// Register implements DeviceStore, LastSeen defaults to now.
+func (s *FileDeviceStore) Register(device Device) error {
+	if err := device.validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if device.LastSeen.IsZero() {
+		device.LastSeen = s.mem.now()
+	}
+	if err := s.log.append(deviceRecord{Device: &device}); err != nil {
+		return err
+	}
+	return s.mem.Register(device)
+}
+
+// Unregister implements DeviceStore.
+func (s *FileDeviceStore) Unregister(channelID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.append(deviceRecord{Unregister: channelID}); err != nil {
+		return err
+	}
+	return s.mem.Unregister(channelID)
+}
+
+// MarkDead implements DeviceMarker.
+func (s *FileDeviceStore) MarkDead(channelID string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mem.mu.Lock()
+	device, ok := s.mem.devices[channelID]
+	s.mem.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	device.DeadSince = &t
+	if err := s.log.append(deviceRecord{Device: &device}); err != nil {
+		return err
+	}
+	return s.mem.MarkDead(channelID, t)
+}
+
+// Devices implements DeviceStore.
+func (s *FileDeviceStore) Devices(userID string) ([]Device, error) {
+	return s.mem.Devices(userID)
+}
+
+// Compact rewrites the log with current devices only.
+func (s *FileDeviceStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := []interface{}{}
+	for _, device := range s.mem.all() {
+		device := device
+		values = append(values, deviceRecord{Device: &device})
+	}
+	return s.log.compact(values)
+}
+
+// Close closes the file of store.
+func (s *FileDeviceStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.log.close()
+}
+
+// UserPusher pushes to users of apps through the devices recorded for them,
+// the channel of each platform is taken from a registry.
+type UserPusher struct {
+	registry *Registry
+	devices  DeviceStore
+}
+
+// NewUserPusher returns a pusher resolving devices of users by devices and
+// channels of apps by registry.
+func NewUserPusher(registry *Registry, devices DeviceStore) *UserPusher {
+	return &UserPusher{
+		registry: registry,
+		devices:  devices,
+	}
+}
+
+// UserPushResult merges the results of pushing to users on each platform.
+type UserPushResult struct {
+	CrossPushResult
+	// Devices are the devices pushed to on each platform.
+	Devices map[Platform][]Device
+	// NoDevices are the users without devices of the app.
+	NoDevices []string
+}
+
+// PushToUser pushes msg to every live device of user running app, opts are
+// optional parameters of every platform as in CrossPush.
+func (p *UserPusher) PushToUser(app, userID string, msg MessageRenderer, opts url.Values) (UserPushResult, error) {
+	return p.PushToUsers(app, []string{userID}, msg, opts)
+}
+
+// PushToUsers pushes msg to every live device of users running app, a platform
+// with one device is pushed to by PushSingle and the others by PushBatch as
+// in Registry.PushNotification, which takes several pushes for more than
+// MaxBatchDevices Android devices or more than one iOS device.
+// Users without devices are reported in the result, it is an error only if
+// none of users has a device. The error returned joins errors of all failed
+// platforms.
+func (p *UserPusher) PushToUsers(app string, userIDs []string, msg MessageRenderer, opts url.Values) (UserPushResult, error) {
+	merged := UserPushResult{
+		CrossPushResult: CrossPushResult{
+			Results: map[Platform][]PushResult{},
+			Errors:  map[Platform]error{},
+		},
+		Devices: map[Platform][]Device{},
+	}
+
+	channelIDs := map[Platform][]string{}
+	for _, userID := range userIDs {
+		devices, err := p.devices.Devices(userID)
+		if err != nil {
+			return merged, fmt.Errorf("devices of user %s: %w", userID, err)
+		}
+		found := false
+		for _, device := range devices {
+			if device.App != app || device.DeadSince != nil {
+				continue
+			}
+			found = true
+			merged.Devices[device.Platform] = append(merged.Devices[device.Platform], device)
+			channelIDs[device.Platform] = append(channelIDs[device.Platform], device.ChannelID)
+		}
+		if !found {
+			merged.NoDevices = append(merged.NoDevices, userID)
+		}
+	}
+	if len(channelIDs) == 0 {
+		return merged, fmt.Errorf("no devices of app %s for users %v", app, userIDs)
+	}
+
+	platforms := []Platform{}
+	for platform := range channelIDs {
+		platforms = append(platforms, platform)
+	}
+	sort.Slice(platforms, func(i, j int) bool { return platforms[i] < platforms[j] })
+
+	errs := []error{}
+	for _, platform := range platforms {
+		cp := CrossPush{
+			Kind:    PushBatch,
+			Devices: channelIDs,
+			Message: msg,
+			Opts:    opts,
+		}
+		if len(channelIDs[platform]) == 1 {
+			cp.Kind = PushSingle
+		}
+		results, err := p.registry.pushPlatform(app, platform, cp)
+		if len(results) > 0 {
+			merged.Results[platform] = results
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %w", platform, err)
+			merged.Errors[platform] = err
+			errs = append(errs, err)
+		}
+	}
+	return merged, errors.Join(errs...)
+}
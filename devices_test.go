@@ -0,0 +1,152 @@
+package baidupush
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileDeviceStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.log")
+	store, err := OpenFileDeviceStore(path)
+	if err != nil {
+		t.Fatal("open device store error", err)
+	}
+
+	seen := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	devices := []Device{
+		{UserID: "u1", ChannelID: "a1", Platform: Android, App: "shop", LastSeen: seen, Locale: "zh-CN"},
+		{UserID: "u1", ChannelID: "i1", Platform: IOS, App: "shop", LastSeen: seen},
+		{UserID: "u2", ChannelID: "a2", Platform: Android, App: "shop", LastSeen: seen},
+	}
+	for _, device := range devices {
+		if err = store.Register(device); err != nil {
+			t.Fatal("register error", err)
+		}
+	}
+	// the phone of u2 is sold to u1
+	moved := devices[2]
+	moved.UserID = "u1"
+	store.Register(moved)
+	store.Unregister("i1")
+	if err = store.Register(Device{UserID: "u3", ChannelID: "x", Platform: "windows", App: "shop"}); err == nil {
+		t.Error("device of invalid platform registered")
+	}
+	store.Close()
+
+	// a crash in the middle of writing a line
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	file.WriteString(`{"device":{"user_id":"u4"`)
+	file.Close()
+
+	for i := 0; i < 2; i++ {
+		store, err = OpenFileDeviceStore(path)
+		if err != nil {
+			t.Fatal("reopen device store error", err)
+		}
+		got, _ := store.Devices("u1")
+		if want := []Device{devices[0], moved}; !reflect.DeepEqual(got, want) {
+			t.Errorf("devices of u1 %v want %v", got, want)
+		}
+		if got, _ = store.Devices("u2"); len(got) != 0 {
+			t.Errorf("devices of u2 %v want none", got)
+		}
+		if err = store.Compact(); err != nil {
+			t.Fatal("compact error", err)
+		}
+		store.Close()
+	}
+}
+
+func TestPushToUsers(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	registry, _ := NewRegistry([]AppConfig{
+		{App: "shop", Platform: Android, Host: srv.host(), APIKey: "android-key", Secret: "s1"},
+		{App: "shop", Platform: IOS, Host: srv.host(), APIKey: "ios-key", Secret: "s2"},
+	})
+	store := NewMemoryDeviceStore()
+	store.Register(Device{UserID: "u1", ChannelID: "a1", Platform: Android, App: "shop"})
+	store.Register(Device{UserID: "u1", ChannelID: "i1", Platform: IOS, App: "shop"})
+	store.Register(Device{UserID: "u2", ChannelID: "a2", Platform: Android, App: "shop"})
+	store.Register(Device{UserID: "u3", ChannelID: "n1", Platform: Android, App: "news"})
+	pusher := NewUserPusher(registry, store)
+
+	n := Notification{Title: "Sale", Description: "50% off"}
+	result, err := pusher.PushToUsers("shop", []string{"u1", "u2", "u3"}, n, nil)
+	if err != nil {
+		t.Fatal("push to users error", err)
+	}
+	if len(result.Results) != 2 || len(result.Devices[Android]) != 2 || len(result.Devices[IOS]) != 1 {
+		t.Errorf("result %+v want 2 android devices and 1 iOS device", result)
+	}
+	if !reflect.DeepEqual(result.NoDevices, []string{"u3"}) {
+		t.Errorf("users without devices %v want u3", result.NoDevices)
+	}
+
+	calls := srv.received()
+	if calls[0].apiMethod != "batch_device" || calls[0].params.Get("channel_ids") != `["a1","a2"]` {
+		t.Errorf("android push %s %v want batch to a1 and a2", calls[0].apiMethod, calls[0].params)
+	}
+	if calls[1].apiMethod != "single_device" || calls[1].params.Get("channel_id") != "i1" || calls[1].params.Get("msg_type") != "1" {
+		t.Errorf("iOS push %s %v want notice to i1", calls[1].apiMethod, calls[1].params)
+	}
+
+	if _, err = pusher.PushToUser("shop", "u3", n, nil); err == nil {
+		t.Error("push to user without devices succeeded")
+	}
+}
+
+func TestPushToUsersSplitsPushes(t *testing.T) {
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		if call.params.Get("channel_id") == "i1" {
+			return nil, 40001
+		}
+		return pushOK(call)
+	})
+	registry, _ := NewRegistry([]AppConfig{
+		{App: "shop", Platform: Android, Host: srv.host(), APIKey: "android-key", Secret: "s1"},
+		{App: "shop", Platform: IOS, Host: srv.host(), APIKey: "ios-key", Secret: "s2"},
+	})
+	store := NewMemoryDeviceStore()
+	userIDs := []string{}
+	for i := 0; i < MaxBatchDevices+1; i++ {
+		userID := fmt.Sprintf("u%05d", i)
+		store.Register(Device{UserID: userID, ChannelID: "a" + userID, Platform: Android, App: "shop"})
+		userIDs = append(userIDs, userID)
+	}
+	for i := 0; i < 3; i++ {
+		store.Register(Device{UserID: "u00000", ChannelID: fmt.Sprintf("i%d", i), Platform: IOS, App: "shop"})
+	}
+
+	result, err := NewUserPusher(registry, store).PushToUsers("shop", userIDs, Notification{Title: "Sale"}, nil)
+	if ErrorCode(result.Errors[IOS]) != 40001 || ErrorCode(err) != 40001 {
+		t.Errorf("error %v want 40001 of i1", err)
+	}
+	if len(result.Results[Android]) != 2 || len(result.Results[IOS]) != 2 {
+		t.Errorf("results %v want 2 android batches and 2 iOS pushes", result.Results)
+	}
+
+	calls := srv.received()
+	batches := []int{}
+	singles := []string{}
+	for _, call := range calls {
+		switch call.apiMethod {
+		case "batch_device":
+			ids := []string{}
+			json.Unmarshal([]byte(call.params.Get("channel_ids")), &ids)
+			batches = append(batches, len(ids))
+		case "single_device":
+			singles = append(singles, call.params.Get("channel_id"))
+		}
+	}
+	if !reflect.DeepEqual(batches, []int{MaxBatchDevices, 1}) {
+		t.Errorf("android batches of %v devices want %d and 1", batches, MaxBatchDevices)
+	}
+	if !reflect.DeepEqual(singles, []string{"i0", "i1", "i2"}) {
+		t.Errorf("iOS single pushes to %v want i0 i1 i2", singles)
+	}
+}
@@ -1,55 +1,88 @@
 package baidupush
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// ServiceError is an error code returned by Baidu Cloud Push Service.
+type ServiceError struct {
+	Code int
+	Msg  string
+}
+
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("%d - %s", e.Code, e.Msg)
+}
+
+// Temporary reports whether the request failing with e could succeed later.
+func (e *ServiceError) Temporary() bool {
+	switch e.Code {
+	case 30600, 30606, 30699:
+		return true
+	}
+	return false
+}
+
+// ErrorCode returns the error code of service if err is a ServiceError, or 0.
+func ErrorCode(err error) int {
+	var se *ServiceError
+	if errors.As(err, &se) {
+		return se.Code
+	}
+	return 0
+}
 
 var (
 	errCodeMap = map[int]error{
-		30600: fmt.Errorf("%d - %s", 30600, "internal server error"),
-		30601: fmt.Errorf("%d - %s", 30601, "method not allowed"),
-		30602: fmt.Errorf("%d - %s", 30602, "request params not valid"),
-		30603: fmt.Errorf("%d - %s", 30603, "authentication failed"),
-		30604: fmt.Errorf("%d - %s", 30604, "quota use up, payment required"),
-		30605: fmt.Errorf("%d - %s", 30605, "data required not found"),
-		30606: fmt.Errorf("%d - %s", 30606, "request time expires timeout"),
-		30607: fmt.Errorf("%d - %s", 30607, "channel token timeout"),
-		30608: fmt.Errorf("%d - %s", 30608, "bind relation not found"),
-		30609: fmt.Errorf("%d - %s", 30609, "bind number too many"),
-		30610: fmt.Errorf("%d - %s", 30610, "duplicate operation"),
-		30611: fmt.Errorf("%d - %s", 30611, "tag not found"),
-		30612: fmt.Errorf("%d - %s", 30612, "app forbidden, need whitelist authorization"),
-		30613: fmt.Errorf("%d - %s", 30613, "app need initiated first in push console"),
-		30616: fmt.Errorf("%d - %s", 30616, "app is not approved, can not use the push service"),
-		30617: fmt.Errorf("%d - %s", 30617, "app do not have broadcast push capability"),
-		30618: fmt.Errorf("%d - %s", 30618, "app do not have unicast or groupcast push capability"),
-		30619: fmt.Errorf("%d - %s", 30619, "default tag is reserved"),
-		30620: fmt.Errorf("%d - %s", 30620, "one app could only have one kind of device platform"),
-		30621: fmt.Errorf("%d - %s", 30621, "package name invalid"),
-		30699: fmt.Errorf("%d - %s", 30699, "requests are too frequent to be temporarily rejected or need whitelist authorization"),
-		40001: fmt.Errorf("%d - %s", 40001, "invalid iOS device token"),
-		40002: fmt.Errorf("%d - %s", 40002, "invalid iOS message"),
-		40003: fmt.Errorf("%d - %s", 40003, "iOS bad device token"),
-		40004: fmt.Errorf("%d - %s", 40004, "iOS certification error"),
-		40005: fmt.Errorf("%d - %s", 40005, "iOS duplicate message"),
-		40006: fmt.Errorf("%d - %s", 40006, "iOS production certification invalid"),
-		40007: fmt.Errorf("%d - %s", 40007, "iOS development certification invalid"),
-		40008: fmt.Errorf("%d - %s", 40008, "iOS production certification expire"),
-		40009: fmt.Errorf("%d - %s", 40009, "iOS development certification expire"),
-		40010: fmt.Errorf("%d - %s", 40010, "type error, need a development certification"),
-		40011: fmt.Errorf("%d - %s", 40011, "type error, need a production certification"),
-		40012: fmt.Errorf("%d - %s", 40012, "iOS certification file invalid"),
-		41001: fmt.Errorf("%d - %s", 41001, "timer task not exist"),
-		41002: fmt.Errorf("%d - %s", 41002, "timer task duplicated"),
-		41003: fmt.Errorf("%d - %s", 41003, "timer task num exceed"),
-		41004: fmt.Errorf("%d - %s", 41004, "timer task will be executed, can not be canceled"),
-		41005: fmt.Errorf("%d - %s", 41005, "timer task has been executed"),
-		50001: fmt.Errorf("%d - %s", 50001, "generate CSRF token failed"),
-		50002: fmt.Errorf("%d - %s", 50002, "invalid CSRF token"),
-		50003: fmt.Errorf("%d - %s", 50003, "CSRF token expired"),
-		50004: fmt.Errorf("%d - %s", 50004, "passport not login"),
-		50005: fmt.Errorf("%d - %s", 50005, "invalid BDUSS"),
-		50006: fmt.Errorf("%d - %s", 50006, "required to register as a developer"),
-		50007: fmt.Errorf("%d - %s", 50007, "invalid developer"),
-		50008: fmt.Errorf("%d - %s", 50008, "invalid app name"),
+		30600: &ServiceError{Code: 30600, Msg: "internal server error"},
+		30601: &ServiceError{Code: 30601, Msg: "method not allowed"},
+		30602: &ServiceError{Code: 30602, Msg: "request params not valid"},
+		30603: &ServiceError{Code: 30603, Msg: "authentication failed"},
+		30604: &ServiceError{Code: 30604, Msg: "quota use up, payment required"},
+		30605: &ServiceError{Code: 30605, Msg: "data required not found"},
+		30606: &ServiceError{Code: 30606, Msg: "request time expires timeout"},
+		30607: &ServiceError{Code: 30607, Msg: "channel token timeout"},
+		30608: &ServiceError{Code: 30608, Msg: "bind relation not found"},
+		30609: &ServiceError{Code: 30609, Msg: "bind number too many"},
+		30610: &ServiceError{Code: 30610, Msg: "duplicate operation"},
+		30611: &ServiceError{Code: 30611, Msg: "tag not found"},
+		30612: &ServiceError{Code: 30612, Msg: "app forbidden, need whitelist authorization"},
+		30613: &ServiceError{Code: 30613, Msg: "app need initiated first in push console"},
+		30616: &ServiceError{Code: 30616, Msg: "app is not approved, can not use the push service"},
+		30617: &ServiceError{Code: 30617, Msg: "app do not have broadcast push capability"},
+		30618: &ServiceError{Code: 30618, Msg: "app do not have unicast or groupcast push capability"},
+		30619: &ServiceError{Code: 30619, Msg: "default tag is reserved"},
+		30620: &ServiceError{Code: 30620, Msg: "one app could only have one kind of device platform"},
+		30621: &ServiceError{Code: 30621, Msg: "package name invalid"},
+		30699: &ServiceError{Code: 30699, Msg: "requests are too frequent to be temporarily rejected or need whitelist authorization"},
+		40001: &ServiceError{Code: 40001, Msg: "invalid iOS device token"},
+		40002: &ServiceError{Code: 40002, Msg: "invalid iOS message"},
+		40003: &ServiceError{Code: 40003, Msg: "iOS bad device token"},
+		40004: &ServiceError{Code: 40004, Msg: "iOS certification error"},
+		40005: &ServiceError{Code: 40005, Msg: "iOS duplicate message"},
+		40006: &ServiceError{Code: 40006, Msg: "iOS production certification invalid"},
+		40007: &ServiceError{Code: 40007, Msg: "iOS development certification invalid"},
+		40008: &ServiceError{Code: 40008, Msg: "iOS production certification expire"},
+		40009: &ServiceError{Code: 40009, Msg: "iOS development certification expire"},
+		40010: &ServiceError{Code: 40010, Msg: "type error, need a development certification"},
+		40011: &ServiceError{Code: 40011, Msg: "type error, need a production certification"},
+		40012: &ServiceError{Code: 40012, Msg: "iOS certification file invalid"},
+		41001: &ServiceError{Code: 41001, Msg: "timer task not exist"},
+		41002: &ServiceError{Code: 41002, Msg: "timer task duplicated"},
+		41003: &ServiceError{Code: 41003, Msg: "timer task num exceed"},
+		41004: &ServiceError{Code: 41004, Msg: "timer task will be executed, can not be canceled"},
+		41005: &ServiceError{Code: 41005, Msg: "timer task has been executed"},
+		50001: &ServiceError{Code: 50001, Msg: "generate CSRF token failed"},
+		50002: &ServiceError{Code: 50002, Msg: "invalid CSRF token"},
+		50003: &ServiceError{Code: 50003, Msg: "CSRF token expired"},
+		50004: &ServiceError{Code: 50004, Msg: "passport not login"},
+		50005: &ServiceError{Code: 50005, Msg: "invalid BDUSS"},
+		50006: &ServiceError{Code: 50006, Msg: "required to register as a developer"},
+		50007: &ServiceError{Code: 50007, Msg: "invalid developer"},
+		50008: &ServiceError{Code: 50008, Msg: "invalid app name"},
 	}
 )
 
@@ -59,3 +92,16 @@ func checkErrorCode(code int) error {
 	}
 	return nil
 }
+
+// isTemporary reports whether a request failing with err is worth retrying,
+// only network errors and the temporary errors of service are, invalid
+// requests or responses fail the same way again.
+func isTemporary(err error) bool {
+	var se *ServiceError
+	if errors.As(err, &se) {
+		return se.Temporary()
+	}
+	var ne net.Error
+	var ue *url.Error
+	return errors.As(err, &ne) || errors.As(err, &ue)
+}
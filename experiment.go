@@ -0,0 +1,239 @@
+package baidupush
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// Variant is a message tried by an Experiment, pushed under its own topic so
+// that its acks are reported apart.
+type Variant struct {
+	Name    string
+	Msg     string
+	TopicID string
+}
+
+// Experiment tries variants of a campaign on a share of an audience before
+// the winner is pushed to the rest, a canary release being an experiment of a
+// single variant. Devices are bucketed by the hash of the experiment ID and
+// their channel IDs, so the same device always falls into the same bucket.
+type Experiment struct {
+	ID       string
+	Variants []Variant
+	// Percent is the share of the audience tried, 0-100, split evenly among
+	// the variants.
+	Percent float64
+}
+
+// NewExperiment returns an experiment trying variants on percent of an
+// audience.
+func NewExperiment(id string, percent float64, variants ...Variant) (*Experiment, error) {
+	if id == "" {
+		return nil, errors.New("experiment ID is required")
+	}
+	if percent <= 0 || percent > 100 {
+		return nil, fmt.Errorf("experiment %s: percent %v out of (0, 100]", id, percent)
+	}
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("experiment %s: no variants", id)
+	}
+	names, topics := map[string]bool{}, map[string]bool{}
+	for _, v := range variants {
+		if v.Name == "" || v.TopicID == "" {
+			return nil, fmt.Errorf("experiment %s: variant name and topic ID are required", id)
+		}
+		if names[v.Name] || topics[v.TopicID] {
+			return nil, fmt.Errorf("experiment %s: duplicate variant %s or topic %s", id, v.Name, v.TopicID)
+		}
+		names[v.Name], topics[v.TopicID] = true, true
+	}
+	return &Experiment{ID: id, Variants: variants, Percent: percent}, nil
+}
+
+// Bucket returns the position of channelID in the audience, in [0, 100).
+func (e *Experiment) Bucket(channelID string) float64 {
+	sum := sha256.Sum256([]byte(e.ID + "\x00" + channelID))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53) * 100
+}
+
+// Variant returns the variant named name, or false if not found.
+func (e *Experiment) Variant(name string) (Variant, bool) {
+	for _, v := range e.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variant{}, false
+}
+
+// Assign returns channel IDs of devices tried by every variant, keyed by
+// variant name.
+func (e *Experiment) Assign(channelIDs []string) map[string][]string {
+	assignment := map[string][]string{}
+	share := e.Percent / float64(len(e.Variants))
+	for _, id := range channelIDs {
+		bucket := e.Bucket(id)
+		if bucket >= e.Percent {
+			continue
+		}
+		i := int(bucket / share)
+		if i >= len(e.Variants) {
+			i = len(e.Variants) - 1
+		}
+		name := e.Variants[i].Name
+		assignment[name] = append(assignment[name], id)
+	}
+	return assignment
+}
+
+// Segment returns channel IDs of devices bucketed in [from, to), to push to
+// the audience in stages. Segment(channelIDs, e.Percent, 100) is the rest of
+// the audience not tried.
+func (e *Experiment) Segment(channelIDs []string, from, to float64) []string {
+	segment := []string{}
+	for _, id := range channelIDs {
+		if bucket := e.Bucket(id); bucket >= from && bucket < to {
+			segment = append(segment, id)
+		}
+	}
+	return segment
+}
+
+// PushExperiment pushes every variant of e to the devices assigned to it out
+// of channelIDs, returning the batches keyed by variant name. opts are as
+// those of PushVariant.
+//
+// The error returned joins errors of all failed batches.
+func (bc *Channel) PushExperiment(e *Experiment, channelIDs []string, opts url.Values) (map[string][]TemplateBatch, error) {
+	assignment := e.Assign(channelIDs)
+	pushed := map[string][]TemplateBatch{}
+	errs := []error{}
+	for _, v := range e.Variants {
+		if len(assignment[v.Name]) == 0 {
+			continue
+		}
+		batches, err := bc.PushVariant(e, v.Name, assignment[v.Name], opts)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		pushed[v.Name] = batches
+	}
+	return pushed, errors.Join(errs...)
+}
+
+// PushVariant pushes the variant of e named name to channelIDs under its
+// topic, by PushMsgToBatchDevices in batches of MaxBatchDevices, such as the
+// winner to a segment of the rest. opts are optional parameters of every
+// batch, msg_type defaults to MsgTypeNotice.
+//
+// The error returned joins errors of all failed batches.
+func (bc *Channel) PushVariant(e *Experiment, name string, channelIDs []string, opts url.Values) ([]TemplateBatch, error) {
+	v, ok := e.Variant(name)
+	if !ok {
+		return nil, fmt.Errorf("experiment %s: no variant %s", e.ID, name)
+	}
+	variantOpts := url.Values{}
+	for k, vals := range opts {
+		variantOpts[k] = vals
+	}
+	variantOpts.Set("topic_id", v.TopicID)
+	return bc.pushBatches([]string{v.Msg}, map[string][]string{v.Msg: channelIDs}, variantOpts)
+}
+
+// VariantReport reports how a variant did.
+type VariantReport struct {
+	Variant string
+	TopicID string
+	// Devices is the number of devices the variant was pushed to.
+	Devices int
+	// Acks are the acks reported by ReportTopicStatistics.
+	Acks int
+	// Success are the messages arrived reported by QueryTopicRecords.
+	Success  int
+	Days     []TopicStatistics
+	Messages []MessageResult
+}
+
+// AckRate returns the acks per device pushed to.
+func (r VariantReport) AckRate() float64 {
+	if r.Devices == 0 {
+		return 0
+	}
+	return float64(r.Acks) / float64(r.Devices)
+}
+
+// ExperimentReport summarizes an experiment.
+type ExperimentReport struct {
+	Variants []VariantReport
+	// Winner is the variant of the highest ack rate, or empty if no variant
+	// was acked.
+	Winner string
+}
+
+// ReportExperiment reports the variants of e from ReportTopicStatistics and
+// all pages of QueryTopicRecords of their topics, devices are the numbers of
+// devices pushed to keyed by variant name, such as the lengths of Assign.
+func (bc *Channel) ReportExperiment(e *Experiment, devices map[string]int) (ExperimentReport, error) {
+	report := ExperimentReport{}
+	best := 0.0
+	for _, v := range e.Variants {
+		r := VariantReport{Variant: v.Name, TopicID: v.TopicID, Devices: devices[v.Name]}
+
+		_, days, err := bc.ReportTopicStatistics(v.TopicID)
+		if err != nil {
+			return report, fmt.Errorf("experiment %s variant %s: %w", e.ID, v.Name, err)
+		}
+		sort.Slice(days, func(i, j int) bool { return days[i].Day < days[j].Day })
+		r.Days = days
+		for _, day := range days {
+			r.Acks += day.Ack
+		}
+
+		r.Messages, err = bc.topicRecords(v.TopicID)
+		if err != nil {
+			return report, fmt.Errorf("experiment %s variant %s: %w", e.ID, v.Name, err)
+		}
+		for _, msg := range r.Messages {
+			r.Success += msg.Success
+		}
+
+		if rate := r.AckRate(); rate > best {
+			best, report.Winner = rate, v.Name
+		}
+		report.Variants = append(report.Variants, r)
+	}
+	return report, nil
+}
+
+// allRecords returns all pages of records returned by query.
+func allRecords(query func(opts url.Values) ([]MessageResult, error)) ([]MessageResult, error) {
+	records := []MessageResult{}
+	err := walkPages(func(opts url.Values) (int, []MessageResult, error) {
+		page, err := query(opts)
+		return -1, page, err
+	}, func(record MessageResult) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// topicRecords returns all pages of QueryTopicRecords of topicID.
+func (bc *Channel) topicRecords(topicID string) ([]MessageResult, error) {
+	return allRecords(func(opts url.Values) ([]MessageResult, error) {
+		_, page, err := bc.QueryTopicRecords(topicID, opts)
+		return page, err
+	})
+}
+
+// timerRecords returns all pages of QueryTimerRecords of timerID.
+func (bc *Channel) timerRecords(timerID string) ([]MessageResult, error) {
+	return allRecords(func(opts url.Values) ([]MessageResult, error) {
+		_, page, err := bc.QueryTimerRecords(timerID, opts)
+		return page, err
+	})
+}
@@ -0,0 +1,118 @@
+package baidupush
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestExperimentAssign(t *testing.T) {
+	e, err := NewExperiment("spring", 20, Variant{Name: "a", Msg: "A", TopicID: "spring-a"}, Variant{Name: "b", Msg: "B", TopicID: "spring-b"})
+	if err != nil {
+		t.Fatal("new experiment error", err)
+	}
+	ids := []string{}
+	for i := 0; i < 10000; i++ {
+		ids = append(ids, fmt.Sprintf("chn%d", i))
+	}
+
+	assignment := e.Assign(ids)
+	for _, name := range []string{"a", "b"} {
+		if n := len(assignment[name]); n < 800 || n > 1200 {
+			t.Errorf("variant %s assigned %d devices", name, n)
+		}
+	}
+	if !reflect.DeepEqual(assignment, e.Assign(ids)) {
+		t.Error("assignment not deterministic")
+	}
+
+	tried := append(append([]string{}, assignment["a"]...), assignment["b"]...)
+	rest := e.Segment(ids, e.Percent, 100)
+	if len(tried)+len(rest) != len(ids) {
+		t.Errorf("tried %d rest %d", len(tried), len(rest))
+	}
+	all := append(tried, rest...)
+	sort.Strings(all)
+	sorted := append([]string{}, ids...)
+	sort.Strings(sorted)
+	if !reflect.DeepEqual(all, sorted) {
+		t.Error("segments overlap")
+	}
+
+	bad := [][]Variant{nil, {{Name: "a"}}, {{Name: "a", TopicID: "t"}, {Name: "a", TopicID: "u"}}}
+	for _, variants := range bad {
+		if _, err := NewExperiment("x", 10, variants...); err == nil {
+			t.Errorf("variants %+v accepted", variants)
+		}
+	}
+	if _, err := NewExperiment("x", 0, Variant{Name: "a", TopicID: "t"}); err == nil {
+		t.Error("percent 0 accepted")
+	}
+}
+
+func TestExperimentPushAndReport(t *testing.T) {
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		topic := call.params.Get("topic_id")
+		switch call.apiMethod {
+		case "statistic_topic":
+			ack := map[string]int{"spring-a": 3, "spring-b": 1}[topic]
+			return map[string]interface{}{"total_num": 2, "result": map[string]interface{}{
+				"1709251200": map[string]interface{}{"ack": ack},
+				"1709164800": map[string]interface{}{"ack": ack},
+			}}, 0
+		case "query_topic_records":
+			return map[string]interface{}{"topic_id": topic, "result": []interface{}{
+				map[string]interface{}{"msg_id": "m-" + topic, "status": 0, "send_time": 1709251200, "success": 5},
+			}}, 0
+		}
+		return pushOK(call)
+	})
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+
+	e, _ := NewExperiment("spring", 50, Variant{Name: "a", Msg: "A", TopicID: "spring-a"}, Variant{Name: "b", Msg: "B", TopicID: "spring-b"})
+	ids := []string{}
+	for i := 0; i < 40; i++ {
+		ids = append(ids, fmt.Sprintf("chn%d", i))
+	}
+	assignment := e.Assign(ids)
+
+	pushed, err := bc.PushExperiment(e, ids, nil)
+	if err != nil {
+		t.Fatal("push experiment error", err)
+	}
+	for _, call := range srv.received() {
+		name := map[string]string{"spring-a": "a", "spring-b": "b"}[call.params.Get("topic_id")]
+		got := []string{}
+		json.Unmarshal([]byte(call.params.Get("channel_ids")), &got)
+		if name == "" || call.params.Get("msg") != map[string]string{"a": "A", "b": "B"}[name] || !reflect.DeepEqual(got, assignment[name]) {
+			t.Errorf("variant push %v", call.params)
+		}
+	}
+	if len(pushed) != 2 {
+		t.Errorf("pushed %+v", pushed)
+	}
+
+	report, err := bc.ReportExperiment(e, map[string]int{"a": 10, "b": 10})
+	if err != nil {
+		t.Fatal("report error", err)
+	}
+	if report.Winner != "a" || report.Variants[0].Acks != 6 || report.Variants[0].Success != 5 || report.Variants[0].AckRate() != 0.6 {
+		t.Errorf("report %+v", report)
+	}
+	if days := report.Variants[1].Days; days[0].Day != 1709164800 {
+		t.Errorf("days not sorted %+v", days)
+	}
+
+	calls := len(srv.received())
+	if _, err := bc.PushVariant(e, report.Winner, e.Segment(ids, e.Percent, 100), nil); err != nil {
+		t.Fatal("push winner error", err)
+	}
+	if call := srv.received()[calls]; call.params.Get("topic_id") != "spring-a" {
+		t.Errorf("winner push %v", call.params)
+	}
+	if _, err := bc.PushVariant(e, "c", ids, nil); err == nil {
+		t.Error("unknown variant pushed")
+	}
+}
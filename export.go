@@ -0,0 +1,394 @@
+package baidupush
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ExportFormat is the file format of an Exporter.
+type ExportFormat string
+
+const (
+	// ExportCSV is comma-separated values with a header row.
+	ExportCSV ExportFormat = "csv"
+	// ExportJSONLines is a JSON object a line.
+	ExportJSONLines ExportFormat = "jsonl"
+)
+
+// ParseExportFormat parses csv or jsonl.
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch f := ExportFormat(s); f {
+	case ExportCSV, ExportJSONLines:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid export format %q - must be csv or jsonl", s)
+}
+
+// ExportColumnType is the type of the values of an exported column.
+type ExportColumnType string
+
+const (
+	// ExportInteger is a column of integers, JSON numbers in JSON Lines.
+	ExportInteger ExportColumnType = "integer"
+	// ExportString is a column of strings.
+	ExportString ExportColumnType = "string"
+	// ExportTimestamp is a column of times as RFC 3339 in UTC, empty in CSV
+	// or null in JSON Lines if not set.
+	ExportTimestamp ExportColumnType = "timestamp"
+)
+
+// ExportColumn is a column of exported records.
+type ExportColumn struct {
+	Name string           `json:"name"`
+	Type ExportColumnType `json:"type"`
+}
+
+// ExportSchema returns the columns exported of records of the type of record
+// in order, such as ExportSchema(TopicResult{}), so that loaders could create
+// tables of the right types, CSV being text only.
+func ExportSchema(record interface{}) ([]ExportColumn, error) {
+	columns, err := exportColumns(record)
+	if err != nil {
+		return nil, err
+	}
+	return schemaOf(columns), nil
+}
+
+// exportColumn is a named value of a record exported.
+type exportColumn struct {
+	name  string
+	value interface{}
+}
+
+// columnType returns the type of the values of c.
+func (c exportColumn) columnType() ExportColumnType {
+	switch c.value.(type) {
+	case exportTime:
+		return ExportTimestamp
+	case int, int64:
+		return ExportInteger
+	}
+	return ExportString
+}
+
+func schemaOf(columns []exportColumn) []ExportColumn {
+	schema := make([]ExportColumn, len(columns))
+	for i, c := range columns {
+		schema[i] = ExportColumn{Name: c.name, Type: c.columnType()}
+	}
+	return schema
+}
+
+// exportTime is a UNIX timestamp exported as RFC 3339 in UTC, or empty if
+// not set.
+type exportTime int64
+
+func (t exportTime) String() string {
+	if t == 0 {
+		return ""
+	}
+	return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+}
+
+func (t exportTime) MarshalJSON() ([]byte, error) {
+	if t == 0 {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.String())
+}
+
+// exportColumns flattens record into columns of snake case names as the
+// service calls them, with timestamps as RFC 3339.
+func exportColumns(record interface{}) ([]exportColumn, error) {
+	switch r := record.(type) {
+	case DeviceStatistics:
+		return []exportColumn{
+			{"day", exportTime(r.Day)},
+			{"new_term", r.DailyNewUser},
+			{"del_term", r.DailyLostUser},
+			{"online_term", r.DailyOnline},
+			{"addup_term", r.AddedupTerm},
+			{"total_term", r.AvailChnID},
+		}, nil
+	case TopicStatistics:
+		return []exportColumn{{"day", exportTime(r.Day)}, {"ack", r.Ack}}, nil
+	case TopicResult:
+		return []exportColumn{
+			{"topic_id", r.Topic},
+			{"push_cnt", r.PushCount},
+			{"ack_cnt", r.AckCount},
+			{"ctime", exportTime(r.FirstTime)},
+			{"mtime", exportTime(r.LastTime)},
+		}, nil
+	case TagInfo:
+		return []exportColumn{
+			{"tid", r.TID},
+			{"tag", r.Tag},
+			{"info", r.Info},
+			{"create_time", exportTime(r.CreateTime)},
+		}, nil
+	case TimerResult:
+		return []exportColumn{
+			{"timer_id", r.ID},
+			{"send_time", exportTime(r.SendTime)},
+			{"msg_type", r.MsgType},
+			{"range_type", r.RangeType},
+			{"msg", r.Msg},
+		}, nil
+	case MessageResult:
+		return []exportColumn{
+			{"msg_id", r.MsgID},
+			{"status", r.Status},
+			{"success", r.Success},
+			{"send_time", exportTime(r.SendTime)},
+		}, nil
+	}
+	return nil, fmt.Errorf("cannot export %T", record)
+}
+
+// Exporter streams results of reports and queries to a writer in CSV or JSON
+// Lines, one record at a time, all records of the same type. Columns are
+// flat and named after the parameters of the service, with timestamps as
+// RFC 3339 in UTC, so that the files load into columnar stores as is. The
+// types of columns are told by Schema.
+type Exporter struct {
+	format  ExportFormat
+	w       io.Writer
+	csv     *csv.Writer
+	headers []string
+	schema  []ExportColumn
+	count   int
+}
+
+// NewExporter returns an exporter writing to w in format.
+func NewExporter(w io.Writer, format ExportFormat) *Exporter {
+	e := &Exporter{format: format, w: w}
+	if format == ExportCSV {
+		e.csv = csv.NewWriter(w)
+	}
+	return e
+}
+
+// Count returns the number of records written.
+func (e *Exporter) Count() int {
+	return e.count
+}
+
+// Schema returns the columns of the records written, nil if none was
+// written, see ExportSchema.
+func (e *Exporter) Schema() []ExportColumn {
+	return e.schema
+}
+
+// Write writes record, a DeviceStatistics, TopicStatistics, TopicResult,
+// TagInfo, TimerResult or MessageResult.
+func (e *Exporter) Write(record interface{}) error {
+	columns, err := exportColumns(record)
+	if err != nil {
+		return err
+	}
+	if e.headers == nil {
+		e.headers = make([]string, len(columns))
+		for i, c := range columns {
+			e.headers[i] = c.name
+		}
+		e.schema = schemaOf(columns)
+		if e.csv != nil {
+			if err = e.csv.Write(e.headers); err != nil {
+				return err
+			}
+		}
+	} else if len(columns) != len(e.headers) || columns[0].name != e.headers[0] {
+		return fmt.Errorf("cannot export %T with records of other types", record)
+	}
+
+	if e.csv != nil {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			row[i] = fmt.Sprint(c.value)
+		}
+		err = e.csv.Write(row)
+	} else {
+		err = e.writeJSONLine(columns)
+	}
+	if err == nil {
+		e.count++
+	}
+	return err
+}
+
+// writeJSONLine writes columns as a JSON object in order.
+func (e *Exporter) writeJSONLine(columns []exportColumn) error {
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, c := range columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(c.name)
+		value, err := json.Marshal(c.value)
+		if err != nil {
+			return err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// Flush writes any records buffered.
+func (e *Exporter) Flush() error {
+	if e.csv == nil {
+		return nil
+	}
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+// walkPages calls visit with every record of all pages fetched by fetch from
+// start 0, 100 records a page, until fewer records are returned or total
+// reached, total being negative if unknown.
+func walkPages[T any](fetch func(opts url.Values) (int, []T, error), visit func(T) error) error {
+	for start := 0; ; {
+		opts := url.Values{}
+		opts.Set("start", strconv.Itoa(start))
+		opts.Set("limit", "100")
+		total, page, err := fetch(opts)
+		if err != nil {
+			return err
+		}
+		for _, record := range page {
+			if err = visit(record); err != nil {
+				return err
+			}
+		}
+		start += len(page)
+		if len(page) < 100 || (total >= 0 && start >= total) {
+			return nil
+		}
+	}
+}
+
+// exportPages exports all pages fetched by fetch, returning the number of
+// records exported.
+func exportPages[T any](e *Exporter, fetch func(opts url.Values) (int, []T, error)) (int, error) {
+	n := 0
+	err := walkPages(fetch, func(record T) error {
+		if err := e.Write(record); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, e.Flush()
+}
+
+func exportAll[T any](e *Exporter, records []T) (int, error) {
+	for i, record := range records {
+		if err := e.Write(record); err != nil {
+			return i, err
+		}
+	}
+	return len(records), e.Flush()
+}
+
+// ExportTagsInfo exports all pages of QueryTagsInfo.
+func (bc *Channel) ExportTagsInfo(e *Exporter) (int, error) {
+	return exportPages(e, bc.QueryTagsInfo)
+}
+
+// ExportTimerTasks exports all pages of QueryTimerTasks.
+func (bc *Channel) ExportTimerTasks(e *Exporter) (int, error) {
+	return exportPages(e, bc.QueryTimerTasks)
+}
+
+// ExportTopicList exports all pages of QueryTopicList.
+func (bc *Channel) ExportTopicList(e *Exporter) (int, error) {
+	return exportPages(e, bc.QueryTopicList)
+}
+
+// ExportTopicRecords exports all pages of QueryTopicRecords of topicID, opts
+// are optional parameters range_start and range_end.
+func (bc *Channel) ExportTopicRecords(e *Exporter, topicID string, opts url.Values) (int, error) {
+	return exportPages(e, func(page url.Values) (int, []MessageResult, error) {
+		_, records, err := bc.QueryTopicRecords(topicID, withPage(opts, page))
+		return -1, records, err
+	})
+}
+
+// ExportTimerRecords exports all pages of QueryTimerRecords of timerID, opts
+// are optional parameters range_start and range_end.
+func (bc *Channel) ExportTimerRecords(e *Exporter, timerID string, opts url.Values) (int, error) {
+	return exportPages(e, func(page url.Values) (int, []MessageResult, error) {
+		_, records, err := bc.QueryTimerRecords(timerID, withPage(opts, page))
+		return -1, records, err
+	})
+}
+
+// ExportMsgStatus exports QueryMsgStatus of msgIDs, queried 10 a time.
+func (bc *Channel) ExportMsgStatus(e *Exporter, msgIDs []string) (int, error) {
+	n := 0
+	for _, chunk := range chunkStrings(msgIDs, 10) {
+		msgID := chunk[0]
+		if len(chunk) > 1 {
+			data, _ := json.Marshal(chunk)
+			msgID = string(data)
+		}
+		_, results, err := bc.QueryMsgStatus(msgID)
+		if err != nil {
+			return n, err
+		}
+		exported, err := exportAll(e, results)
+		n += exported
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ExportDeviceStatistics exports ReportDeviceStatistics ordered by day.
+func (bc *Channel) ExportDeviceStatistics(e *Exporter) (int, error) {
+	_, stats, err := bc.ReportDeviceStatistics()
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Day < stats[j].Day })
+	return exportAll(e, stats)
+}
+
+// ExportTopicStatistics exports ReportTopicStatistics of topicID ordered by
+// day.
+func (bc *Channel) ExportTopicStatistics(e *Exporter, topicID string) (int, error) {
+	_, stats, err := bc.ReportTopicStatistics(topicID)
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Day < stats[j].Day })
+	return exportAll(e, stats)
+}
+
+// withPage returns opts with the start and limit of page.
+func withPage(opts, page url.Values) url.Values {
+	merged := url.Values{}
+	for k, v := range opts {
+		merged[k] = v
+	}
+	for k, v := range page {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,87 @@
+package baidupush
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExporter(t *testing.T) {
+	csvOut, jsonOut := &bytes.Buffer{}, &bytes.Buffer{}
+	records := []MessageResult{
+		{MsgID: "m1", Status: 0, Success: 3, SendTime: 1709251200},
+		{MsgID: "m,2", Status: 1},
+	}
+	for _, out := range []struct {
+		buf    *bytes.Buffer
+		format ExportFormat
+	}{{csvOut, ExportCSV}, {jsonOut, ExportJSONLines}} {
+		e := NewExporter(out.buf, out.format)
+		if n, err := exportAll(e, records); n != 2 || err != nil {
+			t.Fatalf("export %s %d error %v", out.format, n, err)
+		}
+		if err := e.Write(TagInfo{}); err == nil {
+			t.Errorf("%s mixed records exported", out.format)
+		}
+	}
+
+	wantCSV := "msg_id,status,success,send_time\nm1,0,3,2024-03-01T00:00:00Z\n\"m,2\",1,0,\n"
+	if csvOut.String() != wantCSV {
+		t.Errorf("csv %q want %q", csvOut, wantCSV)
+	}
+	wantJSON := `{"msg_id":"m1","status":0,"success":3,"send_time":"2024-03-01T00:00:00Z"}` + "\n" +
+		`{"msg_id":"m,2","status":1,"success":0,"send_time":null}` + "\n"
+	if jsonOut.String() != wantJSON {
+		t.Errorf("jsonl %q want %q", jsonOut, wantJSON)
+	}
+
+	schema, err := ExportSchema(MessageResult{})
+	want := []ExportColumn{{"msg_id", ExportString}, {"status", ExportInteger}, {"success", ExportInteger}, {"send_time", ExportTimestamp}}
+	if err != nil || !reflect.DeepEqual(schema, want) {
+		t.Errorf("schema %v error %v want %v", schema, err, want)
+	}
+	if e := NewExporter(&bytes.Buffer{}, ExportCSV); e.Schema() != nil {
+		t.Errorf("schema %v before writing", e.Schema())
+	} else if e.Write(records[0]); !reflect.DeepEqual(e.Schema(), want) {
+		t.Errorf("exporter schema %v want %v", e.Schema(), want)
+	}
+	if _, err = ExportSchema(Device{}); err == nil {
+		t.Error("schema of device returned")
+	}
+
+	if _, err := ParseExportFormat("parquet"); err == nil {
+		t.Error("parquet parsed")
+	}
+}
+
+func TestExportPages(t *testing.T) {
+	const total = 250
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		start, _ := strconv.Atoi(call.params.Get("start"))
+		limit, _ := strconv.Atoi(call.params.Get("limit"))
+		result := []interface{}{}
+		for i := start; i < start+limit && i < total; i++ {
+			result = append(result, map[string]interface{}{
+				"topic_id": fmt.Sprintf("topic-%d", i), "push_cnt": i, "ack_cnt": 0, "ctime": 0, "mtime": 1709251200,
+			})
+		}
+		return map[string]interface{}{"total_num": total, "result": result}, 0
+	})
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+
+	out := &bytes.Buffer{}
+	n, err := bc.ExportTopicList(NewExporter(out, ExportCSV))
+	if err != nil || n != total {
+		t.Fatalf("exported %d error %v", n, err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != total+1 || lines[0] != "topic_id,push_cnt,ack_cnt,ctime,mtime" || lines[total] != "topic-249,249,0,,2024-03-01T00:00:00Z" {
+		t.Errorf("lines %d first %q last %q", len(lines), lines[0], lines[len(lines)-1])
+	}
+	if calls := len(srv.received()); calls != 3 {
+		t.Errorf("%d pages queried", calls)
+	}
+}
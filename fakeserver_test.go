@@ -0,0 +1,87 @@
+package baidupush
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCall records a request received by fakeServer.
+type fakeCall struct {
+	apiClass, apiMethod string
+	httpMethod          string
+	params              url.Values
+	header              http.Header
+}
+
+// fakeHandler returns the response params of an API call, or a non-zero error
+// code to report.
+type fakeHandler func(call fakeCall) (interface{}, int)
+
+// fakeServer imitates Baidu Cloud Push Service locally.
+type fakeServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	calls     []fakeCall
+	requestID int64
+}
+
+func newFakeServer(t *testing.T, handler fakeHandler) *fakeServer {
+	fs := &fakeServer{}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/rest/3.0/"), "/")
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		call := fakeCall{
+			apiClass:   parts[0],
+			apiMethod:  parts[1],
+			httpMethod: r.Method,
+			params:     r.Form,
+			header:     r.Header,
+		}
+
+		fs.mu.Lock()
+		fs.calls = append(fs.calls, call)
+		fs.requestID++
+		rsp := map[string]interface{}{"request_id": fs.requestID}
+		fs.mu.Unlock()
+
+		params, code := handler(call)
+		if code != 0 {
+			rsp["error_code"] = code
+			rsp["error_msg"] = "fake error"
+		} else {
+			rsp["response_params"] = params
+		}
+		json.NewEncoder(w).Encode(rsp)
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+// host returns the address to pass to NewChannel.
+func (fs *fakeServer) host() string {
+	return strings.TrimPrefix(fs.URL, "http://")
+}
+
+func (fs *fakeServer) received() []fakeCall {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]fakeCall{}, fs.calls...)
+}
+
+// pushOK answers every push API with a message ID.
+func pushOK(call fakeCall) (interface{}, int) {
+	return map[string]interface{}{"msg_id": "msg-" + call.apiMethod, "send_time": 1486000000}, 0
+}
@@ -0,0 +1,161 @@
+package baidupush
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// jsonLog is an append-only log of JSON lines in a file, the storage of the
+// file-backed stores such as Outbox. Every append is synced to disk before it
+// returns, and the log is rewritten atomically by compact. It is not safe for
+// concurrent use, stores guard it by their own locks.
+type jsonLog struct {
+	path string
+	name string
+	file *os.File
+}
+
+// openJSONLog opens the log in file path, creating it if not existed, and
+// passes every value in it to apply in order. A truncated last line left by a
+// crash is dropped, name describes the log in errors.
+func openJSONLog[T any](path, name string, apply func(T)) (*jsonLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &jsonLog{path: path, name: name, file: file}
+	reader := bufio.NewReader(file)
+	offset := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(bytes.TrimSpace(line)) == 0 {
+			return l, nil
+		}
+		if err == io.EOF {
+			if err = file.Truncate(offset); err == nil {
+				return l, nil
+			}
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		var v T
+		if err = json.Unmarshal(line, &v); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s %s corrupted at offset %d: %v", name, path, offset, err)
+		}
+		apply(v)
+		offset += int64(len(line))
+	}
+}
+
+// append writes values as lines and syncs them to disk, a failed append is
+// cut off the log so that no half written line is followed by others.
+func (l *jsonLog) append(values ...interface{}) error {
+	data, err := marshalLines(values)
+	if err != nil {
+		return err
+	}
+	info, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = l.file.Write(data); err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		l.file.Truncate(info.Size())
+	}
+	return err
+}
+
+// compact replaces the log with values. The new file is written and synced
+// aside, renamed over the log and kept open, so that the log never appends to
+// a file replaced.
+func (l *jsonLog) compact(values []interface{}) error {
+	data, err := marshalLines(values)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := l.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, l.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(filepath.Dir(l.path))
+
+	l.file.Close()
+	l.file = tmp
+	return nil
+}
+
+// close closes the file of the log.
+func (l *jsonLog) close() error {
+	return l.file.Close()
+}
+
+func marshalLines(values []interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	for _, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	return buf.Bytes(), nil
+}
+
+// syncDir makes a rename in dir durable, errors are ignored because not all
+// platforms support syncing directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// writeFileAtomic replaces the file path with data by renaming a synced
+// temporary file over it, so that the file is never seen half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
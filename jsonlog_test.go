@@ -0,0 +1,54 @@
+package baidupush
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestJSONLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.log")
+	open := func() ([]int, *jsonLog) {
+		t.Helper()
+		values := []int{}
+		l, err := openJSONLog(path, "values", func(v int) { values = append(values, v) })
+		if err != nil {
+			t.Fatal("open log error", err)
+		}
+		return values, l
+	}
+
+	_, l := open()
+	if err := l.append(1, 2); err != nil {
+		t.Fatal("append error", err)
+	}
+	l.append(3)
+	l.close()
+
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString("4")
+	f.Close()
+
+	values, l := open()
+	if !reflect.DeepEqual(values, []int{1, 2, 3}) {
+		t.Errorf("values %v want the truncated line dropped", values)
+	}
+	if err := l.compact([]interface{}{3}); err != nil {
+		t.Fatal("compact error", err)
+	}
+	if err := l.append(5); err != nil {
+		t.Fatal("append after compaction error", err)
+	}
+	l.close()
+
+	if values, l = open(); !reflect.DeepEqual(values, []int{3, 5}) {
+		t.Errorf("values %v want 3 5", values)
+	}
+	l.close()
+
+	os.WriteFile(path, []byte("1\nnot json\n2\n"), 0600)
+	if _, err := openJSONLog(path, "values", func(int) {}); err == nil {
+		t.Error("corrupted log opened")
+	}
+}
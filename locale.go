@@ -0,0 +1,160 @@
+package baidupush
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bundle holds the variants of a notification in locales like "zh-CN",
+// "zh-TW" and "en". The variant of a locale is looked up in the locale, its
+// fallbacks, its parent language such as "zh" for "zh-TW", and at last the
+// default locale.
+type Bundle struct {
+	defaultLocale string
+	variants      map[string]Notification
+	fallbacks     map[string][]string
+}
+
+// NewBundle returns an empty bundle falling back to defaultLocale.
+func NewBundle(defaultLocale string) *Bundle {
+	return &Bundle{
+		defaultLocale: normalizeLocale(defaultLocale),
+		variants:      map[string]Notification{},
+		fallbacks:     map[string][]string{},
+	}
+}
+
+// Add sets the variant of locale.
+func (b *Bundle) Add(locale string, n Notification) *Bundle {
+	b.variants[normalizeLocale(locale)] = n
+	return b
+}
+
+// SetFallbacks sets the locales looked up in order if locale has no variant,
+// before its parent language and the default locale.
+func (b *Bundle) SetFallbacks(locale string, fallbacks ...string) *Bundle {
+	chain := []string{}
+	for _, fallback := range fallbacks {
+		chain = append(chain, normalizeLocale(fallback))
+	}
+	b.fallbacks[normalizeLocale(locale)] = chain
+	return b
+}
+
+// Locales returns the locales having variants in order.
+func (b *Bundle) Locales() []string {
+	locales := []string{}
+	for locale := range b.variants {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Resolve returns the variant for locale and the locale it is in.
+func (b *Bundle) Resolve(locale string) (Notification, string, error) {
+	locale = normalizeLocale(locale)
+	chain := append([]string{locale}, b.fallbacks[locale]...)
+	if i := strings.Index(locale, "-"); i > 0 {
+		chain = append(chain, locale[:i])
+	}
+	chain = append(chain, b.defaultLocale)
+
+	for _, l := range chain {
+		if n, ok := b.variants[l]; ok {
+			return n, l, nil
+		}
+	}
+	return Notification{}, "", fmt.Errorf("no variant for locale %q", locale)
+}
+
+// normalizeLocale turns locales like "zh_cn" into "zh-CN".
+func normalizeLocale(locale string) string {
+	parts := strings.Split(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"), "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		if len(parts[i]) == 2 {
+			parts[i] = strings.ToUpper(parts[i])
+		} else if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:])
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// PushLocalized groups devices by the locale of the variant they get from b,
+// and pushes each group its variant by PushMsgToBatchDevices, in batches of
+// MaxBatchDevices. Devices on other platforms than channel are skipped. opts
+// are optional parameters of every batch, msg_type defaults to MsgTypeNotice.
+//
+// Batches are returned by locale, the error returned joins errors of all
+// failed batches.
+func (bc *Channel) PushLocalized(b *Bundle, devices []Device, opts url.Values) (map[string][]TemplateBatch, error) {
+	platform := devicePlatform(bc.deviceType)
+	groups := map[string][]string{}
+	msgLocales := map[string]string{}
+	msgs := []string{}
+	for _, device := range devices {
+		if device.Platform != platform {
+			continue
+		}
+		n, locale, err := b.Resolve(device.Locale)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %w", device.ChannelID, err)
+		}
+		msg, err := n.Render(platform)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := groups[msg]; !ok {
+			msgs = append(msgs, msg)
+			msgLocales[msg] = locale
+		}
+		groups[msg] = append(groups[msg], device.ChannelID)
+	}
+
+	batches, err := bc.pushBatches(msgs, groups, opts)
+	byLocale := map[string][]TemplateBatch{}
+	for _, batch := range batches {
+		locale := msgLocales[batch.Msg]
+		byLocale[locale] = append(byLocale[locale], batch)
+	}
+	return byLocale, err
+}
+
+// PushLocalizedTags pushes the variant of every locale in b to the devices
+// tagged by tag(locale), such as "locale:zh-CN". opts are optional parameters
+// of every push, msg_type defaults to MsgTypeNotice.
+//
+// Results are returned by locale, the error returned joins errors of all
+// failed locales.
+func (bc *Channel) PushLocalizedTags(b *Bundle, tag func(locale string) string, opts url.Values) (map[string]PushResult, error) {
+	platform := devicePlatform(bc.deviceType)
+	results := map[string]PushResult{}
+	errs := []error{}
+	for _, locale := range b.Locales() {
+		msg, err := b.variants[locale].Render(platform)
+		if err != nil {
+			return results, err
+		}
+		req := PushRequest{Kind: PushTag, Tag: tag(locale), Msg: msg, Opts: url.Values{}}
+		for k, v := range opts {
+			req.Opts[k] = v
+		}
+		if req.Opts.Get("msg_type") == "" {
+			req.Opts.Set("msg_type", strconv.Itoa(MsgTypeNotice))
+		}
+
+		result, err := bc.Push(req)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", locale, err))
+			continue
+		}
+		results[locale] = result
+	}
+	return results, errors.Join(errs...)
+}
@@ -0,0 +1,78 @@
+package baidupush
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBundleResolve(t *testing.T) {
+	b := NewBundle("en").
+		Add("en", Notification{Title: "Sale"}).
+		Add("zh-CN", Notification{Title: "促销"}).
+		Add("zh-TW", Notification{Title: "促銷"}).
+		SetFallbacks("zh-HK", "zh-TW")
+
+	cases := map[string]string{
+		"zh_cn": "zh-CN",
+		"zh-HK": "zh-TW",
+		"zh-SG": "en",
+		"fr":    "en",
+		"":      "en",
+	}
+	for locale, want := range cases {
+		if _, got, err := b.Resolve(locale); err != nil || got != want {
+			t.Errorf("locale %q resolved to %q %v want %q", locale, got, err, want)
+		}
+	}
+
+	if _, _, err := NewBundle("en").Resolve("fr"); err == nil {
+		t.Error("empty bundle resolved")
+	}
+}
+
+func TestPushLocalized(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+	b := NewBundle("en").
+		Add("en", Notification{Title: "Sale"}).
+		Add("zh-CN", Notification{Title: "促销"}).
+		Add("zh-TW", Notification{Title: "促銷"})
+
+	devices := []Device{
+		{ChannelID: "a1", Platform: Android, Locale: "zh-CN"},
+		{ChannelID: "a2", Platform: Android, Locale: "zh-TW"},
+		{ChannelID: "a3", Platform: Android, Locale: "zh-CN"},
+		{ChannelID: "a4", Platform: Android, Locale: "de"},
+		{ChannelID: "i1", Platform: IOS, Locale: "zh-CN"},
+	}
+	byLocale, err := bc.PushLocalized(b, devices, nil)
+	if err != nil {
+		t.Fatal("push localized error", err)
+	}
+	want := map[string]string{"zh-CN": "a1,a3", "zh-TW": "a2", "en": "a4"}
+	for locale, ids := range want {
+		batches := byLocale[locale]
+		if len(batches) != 1 || strings.Join(batches[0].ChannelIDs, ",") != ids {
+			t.Errorf("batches of %s %+v want %s", locale, batches, ids)
+		}
+	}
+	msg := AndroidMessage{}
+	json.Unmarshal([]byte(byLocale["zh-TW"][0].Msg), &msg)
+	if msg.Title != "促銷" {
+		t.Errorf("zh-TW title %s", msg.Title)
+	}
+
+	results, err := bc.PushLocalizedTags(b, func(locale string) string { return "locale:" + locale }, nil)
+	if err != nil || len(results) != 3 {
+		t.Fatalf("results %v error %v want 3 locales", results, err)
+	}
+	calls := srv.received()[3:]
+	tags := []string{}
+	for _, call := range calls {
+		tags = append(tags, call.params.Get("tag"))
+	}
+	if strings.Join(tags, ",") != "locale:en,locale:zh-CN,locale:zh-TW" {
+		t.Errorf("tags %v", tags)
+	}
+}
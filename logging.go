@@ -0,0 +1,132 @@
+package baidupush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+type logConfig struct {
+	logger       *slog.Logger
+	successLevel slog.Level
+	failureLevel slog.Level
+	redactMsg    bool
+}
+
+func defaultLogConfig() logConfig {
+	return logConfig{
+		successLevel: slog.LevelDebug,
+		failureLevel: slog.LevelError,
+	}
+}
+
+// WithLogger logs every API call made by channel to logger, including API
+// class, method, device type, request ID, latency and error code. The API key,
+// the signature and the secret never appear in logs.
+func WithLogger(logger *slog.Logger) ChannelOption {
+	return func(bc *Channel) {
+		bc.log.logger = logger
+	}
+}
+
+// WithLogLevels sets the levels of logging successful and failed API calls,
+// defaults to slog.LevelDebug and slog.LevelError.
+func WithLogLevels(success, failure slog.Level) ChannelOption {
+	return func(bc *Channel) {
+		bc.log.successLevel = success
+		bc.log.failureLevel = failure
+	}
+}
+
+// WithMsgRedacted hides the msg parameter in logs, only its length is logged.
+func WithMsgRedacted() ChannelOption {
+	return func(bc *Channel) {
+		bc.log.redactMsg = true
+	}
+}
+
+func (bc *Channel) logCall(apiClass, apiMethod, httpMethod string, query url.Values, secret string, requestID int64, code int, latency time.Duration, err error) {
+	logger := bc.log.logger
+	if logger == nil {
+		return
+	}
+
+	level, msg := bc.log.successLevel, "baidupush: API call succeeded"
+	if err != nil {
+		level, msg = bc.log.failureLevel, "baidupush: API call failed"
+	}
+
+	ctx := context.Background()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("api_class", apiClass),
+		slog.String("api_method", apiMethod),
+		slog.String("http_method", httpMethod),
+		slog.Int("device_type", bc.deviceType),
+		slog.Int64("request_id", requestID),
+		slog.Duration("latency", latency),
+		slog.Int("error_code", code),
+		{Key: "params", Value: slog.GroupValue(bc.redactParams(query, secret)...)},
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", redactError(err, secret)))
+	}
+	logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// redactParams turns query into log attributes sorted by key, with the API
+// key, the signature, the secret and optionally the message hidden.
+func (bc *Channel) redactParams(query url.Values, secret string) []slog.Attr {
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := []slog.Attr{}
+	for _, k := range keys {
+		val := strings.Join(query[k], ",")
+		switch {
+		case k == "apikey" || k == "sign":
+			val = redacted
+		case k == "msg" && bc.log.redactMsg:
+			val = fmt.Sprintf("%s(%d bytes)", redacted, len(val))
+		default:
+			val = redactSecret(val, secret)
+		}
+		attrs = append(attrs, slog.String(k, val))
+	}
+	return attrs
+}
+
+func redactSecret(s, secret string) string {
+	if secret == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, secret, redacted)
+}
+
+// redactError returns the message of err with the secret hidden and the query
+// of a failed request, which carries the API key and the signature, dropped
+// from its URL.
+func redactError(err error, secret string) string {
+	msg := err.Error()
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if u, perr := url.Parse(urlErr.URL); perr == nil && u.RawQuery != "" {
+			u.RawQuery = ""
+			msg = strings.ReplaceAll(msg, urlErr.URL, u.String())
+		}
+	}
+	return redactSecret(msg, secret)
+}
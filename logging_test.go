@@ -0,0 +1,101 @@
+package baidupush
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoggingRedactsCredentials(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	bc := NewChannel(srv.host(), "the-api-key", "the-secret", AndroidDeviceType, WithLogger(logger), WithMsgRedacted())
+
+	if _, _, err := bc.PushMsgToSingleDevice("chn", `{"title":"the-secret"}`, nil); err != nil {
+		t.Fatal("push to single error", err)
+	}
+
+	out := buf.String()
+	for _, leaked := range []string{"the-api-key", "the-secret", srv.received()[0].params.Get("sign")} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("log %s leaks %s", out, leaked)
+		}
+	}
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal("decode log record error", err)
+	}
+	if record["level"] != "DEBUG" {
+		t.Errorf("level %v want DEBUG", record["level"])
+	}
+	if record["api_class"] != "push" || record["api_method"] != "single_device" {
+		t.Errorf("api %v/%v want push/single_device", record["api_class"], record["api_method"])
+	}
+	if record["request_id"] != float64(bc.GetRequestID()) {
+		t.Errorf("request ID %v want %d", record["request_id"], bc.GetRequestID())
+	}
+	params := record["params"].(map[string]interface{})
+	if params["channel_id"] != "chn" {
+		t.Errorf("channel_id %v want chn", params["channel_id"])
+	}
+	if params["msg"] != "[REDACTED](22 bytes)" {
+		t.Errorf("msg %v want redacted", params["msg"])
+	}
+}
+
+func TestLoggingFailedCall(t *testing.T) {
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		return nil, 30611
+	})
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType, WithLogger(logger), WithLogLevels(slog.LevelInfo, slog.LevelWarn))
+
+	if _, err := bc.GetTagDevicesNumber("missing"); err == nil {
+		t.Fatal("get tag devices number succeeded, want error")
+	}
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal("decode log record error", err)
+	}
+	if record["level"] != "WARN" {
+		t.Errorf("level %v want WARN", record["level"])
+	}
+	if record["error_code"] != float64(30611) {
+		t.Errorf("error code %v want 30611", record["error_code"])
+	}
+	if record["error"] != "30611 - tag not found" {
+		t.Errorf("error %v want 30611 - tag not found", record["error"])
+	}
+}
+
+func TestLoggingRedactsTransportError(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	srv.Close()
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	bc := NewChannel(srv.host(), "the-api-key", "the-secret", AndroidDeviceType, WithLogger(logger))
+
+	if _, err := bc.GetTagDevicesNumber("tag"); err == nil {
+		t.Fatal("get tag devices number succeeded, want error")
+	}
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal("decode log record error", err)
+	}
+	msg, _ := record["error"].(string)
+	if !strings.Contains(msg, "/rest/3.0/tag/device_num") {
+		t.Errorf("error %q want the request path", msg)
+	}
+	for _, leaked := range []string{"the-api-key", "apikey=", "sign="} {
+		if strings.Contains(msg, leaked) {
+			t.Errorf("error %q leaks %s", msg, leaked)
+		}
+	}
+}
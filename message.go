@@ -0,0 +1,138 @@
+package baidupush
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Platform is the device platform of an app, an app of Baidu Cloud Push
+// Service could only have one.
+type Platform string
+
+const (
+	// Android is the platform of AndroidDeviceType.
+	Android Platform = "android"
+	// IOS is the platform of AppleDeviceType.
+	IOS Platform = "ios"
+)
+
+// DeviceType returns the device type number of platform, or 0 if unknown.
+func (p Platform) DeviceType() int {
+	switch p {
+	case Android:
+		return AndroidDeviceType
+	case IOS:
+		return AppleDeviceType
+	}
+	return 0
+}
+
+// devicePlatform returns the platform of device type number device.
+func devicePlatform(device int) Platform {
+	if device == AppleDeviceType {
+		return IOS
+	}
+	return Android
+}
+
+// AndroidMessage is the msg of a notification pushed to Android devices.
+type AndroidMessage struct {
+	Title                  string                 `json:"title,omitempty"`
+	Description            string                 `json:"description"`
+	NotificationBuilderID  int                    `json:"notification_builder_id,omitempty"`
+	NotificationBasicStyle int                    `json:"notification_basic_style,omitempty"`
+	OpenType               int                    `json:"open_type,omitempty"`
+	URL                    string                 `json:"url,omitempty"`
+	PkgContent             string                 `json:"pkg_content,omitempty"`
+	CustomContent          map[string]interface{} `json:"custom_content,omitempty"`
+}
+
+// String returns the JSON form of m to push.
+func (m AndroidMessage) String() string {
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
+// APS is the Apple Push Service part of an iOS message.
+type APS struct {
+	Alert string `json:"alert"`
+	Sound string `json:"sound,omitempty"`
+	Badge int    `json:"badge,omitempty"`
+}
+
+// IOSMessage is the msg of a notification pushed to iOS devices, the custom
+// content is put beside aps.
+type IOSMessage struct {
+	APS           APS
+	CustomContent map[string]interface{}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m IOSMessage) MarshalJSON() ([]byte, error) {
+	fields := map[string]interface{}{}
+	for k, v := range m.CustomContent {
+		fields[k] = v
+	}
+	fields["aps"] = m.APS
+	return json.Marshal(fields)
+}
+
+// String returns the JSON form of m to push.
+func (m IOSMessage) String() string {
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
+// Notification is a notification independent of platform.
+type Notification struct {
+	Title         string
+	Description   string
+	URL           string // opened on Android devices when clicked
+	Sound         string // played on iOS devices
+	Badge         int    // shown on iOS devices
+	CustomContent map[string]interface{}
+}
+
+// AndroidMessage renders n for Android devices.
+func (n Notification) AndroidMessage() AndroidMessage {
+	m := AndroidMessage{
+		Title:                  n.Title,
+		Description:            n.Description,
+		NotificationBasicStyle: 7,
+		CustomContent:          n.CustomContent,
+	}
+	if n.URL != "" {
+		m.OpenType = 1
+		m.URL = n.URL
+	}
+	return m
+}
+
+// IOSMessage renders n for iOS devices, the alert is the description, or
+// the title if there is no description.
+func (n Notification) IOSMessage() IOSMessage {
+	alert := n.Description
+	if alert == "" {
+		alert = n.Title
+	}
+	return IOSMessage{
+		APS:           APS{Alert: alert, Sound: n.Sound, Badge: n.Badge},
+		CustomContent: n.CustomContent,
+	}
+}
+
+// Render implements MessageRenderer.
+func (n Notification) Render(platform Platform) (string, error) {
+	switch platform {
+	case Android:
+		return n.AndroidMessage().String(), nil
+	case IOS:
+		return n.IOSMessage().String(), nil
+	}
+	return "", fmt.Errorf("invalid platform %q", platform)
+}
+
+// MessageRenderer renders a notification into the msg of a platform.
+type MessageRenderer interface {
+	Render(platform Platform) (string, error)
+}
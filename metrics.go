@@ -0,0 +1,131 @@
+package baidupush
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics is a prometheus.Collector of metrics about API calls made by the
+// channels using it, register it in your own registry to expose them.
+type Metrics struct {
+	requests   *prometheus.CounterVec
+	latency    *prometheus.HistogramVec
+	errors     *prometheus.CounterVec
+	pushes     *prometheus.CounterVec
+	tagDevices *prometheus.CounterVec
+}
+
+// NewMetrics returns metrics with names prefixed by namespace, such as
+// namespace_baidupush_requests_total.
+//
+// Metrics:
+//
+// baidupush_requests_total: API calls by api_class and api_method.
+//
+// baidupush_request_duration_seconds: Latency histogram of API calls by api_class and api_method.
+//
+// baidupush_errors_total: Failed API calls by api_class, api_method and error_code,
+// error_code is "transport" if the service was not reached or answered in a wrong way.
+//
+// baidupush_messages_pushed_total: Messages pushed by push_method.
+//
+// baidupush_tag_devices_total: Devices added to or deleted from tags by api_method and result.
+func NewMetrics(namespace string) *Metrics {
+	labels := []string{"api_class", "api_method"}
+	return &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "baidupush",
+			Name:      "requests_total",
+			Help:      "Number of API calls to Baidu Cloud Push Service.",
+		}, labels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "baidupush",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of API calls to Baidu Cloud Push Service.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "baidupush",
+			Name:      "errors_total",
+			Help:      "Number of failed API calls to Baidu Cloud Push Service by error code.",
+		}, append(labels, "error_code")),
+		pushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "baidupush",
+			Name:      "messages_pushed_total",
+			Help:      "Number of messages pushed successfully.",
+		}, []string{"push_method"}),
+		tagDevices: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "baidupush",
+			Name:      "tag_devices_total",
+			Help:      "Number of devices added to or deleted from tags.",
+		}, []string{"api_method", "result"}),
+	}
+}
+
+// WithMetrics records metrics about API calls made by channel to m, the same
+// metrics could be shared by many channels.
+func WithMetrics(m *Metrics) ChannelOption {
+	return func(bc *Channel) {
+		bc.metrics = m
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.latency.Describe(ch)
+	m.errors.Describe(ch)
+	m.pushes.Describe(ch)
+	m.tagDevices.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.latency.Collect(ch)
+	m.errors.Collect(ch)
+	m.pushes.Collect(ch)
+	m.tagDevices.Collect(ch)
+}
+
+func (m *Metrics) observeCall(apiClass, apiMethod string, code int, latency time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.requests.WithLabelValues(apiClass, apiMethod).Inc()
+	m.latency.WithLabelValues(apiClass, apiMethod).Observe(latency.Seconds())
+	if err != nil {
+		errCode := "transport"
+		if code != 0 {
+			errCode = strconv.Itoa(code)
+		}
+		m.errors.WithLabelValues(apiClass, apiMethod, errCode).Inc()
+		return
+	}
+
+	if apiClass == "push" {
+		m.pushes.WithLabelValues(apiMethod).Inc()
+	}
+}
+
+func (m *Metrics) observeTagDevices(apiMethod string, results []TagResult) {
+	if m == nil {
+		return
+	}
+
+	for _, r := range results {
+		result := "success"
+		if r.Res != 0 {
+			result = "failure"
+		}
+		m.tagDevices.WithLabelValues(apiMethod, result).Inc()
+	}
+}
@@ -0,0 +1,65 @@
+package baidupush
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		switch call.apiMethod {
+		case "add_devices":
+			return map[string]interface{}{"result": []interface{}{
+				map[string]interface{}{"channel_id": "chn1", "result": 0},
+				map[string]interface{}{"channel_id": "chn2", "result": 1},
+			}}, 0
+		case "device_num":
+			return nil, 30611
+		}
+		return pushOK(call)
+	})
+	metrics := NewMetrics("test")
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(metrics); err != nil {
+		t.Fatal("register metrics error", err)
+	}
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType, WithMetrics(metrics))
+
+	if _, _, err := bc.PushMsgToSingleDevice("chn1", "hello", nil); err != nil {
+		t.Fatal("push to single error", err)
+	}
+	if _, err := bc.AddTagDevices("tag", []string{"chn1", "chn2"}); err != nil {
+		t.Fatal("add tag devices error", err)
+	}
+	if _, err := bc.GetTagDevicesNumber("tag"); err == nil {
+		t.Fatal("get tag devices number succeeded, want error")
+	}
+	unreachable := NewChannel("127.0.0.1:1", "key", "secret", AndroidDeviceType, WithMetrics(metrics))
+	if _, err := unreachable.DeleteTag("tag"); err == nil {
+		t.Fatal("delete tag succeeded, want error")
+	}
+
+	if n := testutil.ToFloat64(metrics.requests.WithLabelValues("push", "single_device")); n != 1 {
+		t.Errorf("push requests %v want 1", n)
+	}
+	if n := testutil.ToFloat64(metrics.pushes.WithLabelValues("single_device")); n != 1 {
+		t.Errorf("pushed messages %v want 1", n)
+	}
+	if n := testutil.ToFloat64(metrics.errors.WithLabelValues("tag", "device_num", "30611")); n != 1 {
+		t.Errorf("errors 30611 %v want 1", n)
+	}
+	if n := testutil.ToFloat64(metrics.errors.WithLabelValues("app", "del_tag", "transport")); n != 1 {
+		t.Errorf("transport errors %v want 1", n)
+	}
+	if n := testutil.ToFloat64(metrics.tagDevices.WithLabelValues("add_devices", "success")); n != 1 {
+		t.Errorf("added devices %v want 1", n)
+	}
+	if n := testutil.ToFloat64(metrics.tagDevices.WithLabelValues("add_devices", "failure")); n != 1 {
+		t.Errorf("failed devices %v want 1", n)
+	}
+	if n := testutil.CollectAndCount(metrics, "test_baidupush_request_duration_seconds"); n != 4 {
+		t.Errorf("latency series %d want 4", n)
+	}
+}
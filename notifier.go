@@ -0,0 +1,229 @@
+package baidupush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Notifier sends notifications through a push provider, so that code sending
+// them does not depend on Baidu Cloud Push Service or any other provider.
+// Every error returned by a Notifier is a *NotifyError of one of the kinds
+// ErrInvalidDevice, ErrInvalidRequest, ErrUnauthorized, ErrThrottled,
+// ErrUnavailable, ErrTopicNotFound or ErrProvider, or an error of ctx.
+//
+// Package notifiertest tests a Notifier against a local fake of its provider.
+type Notifier interface {
+	// Provider names the provider, such as "baidu".
+	Provider() string
+	// SendToDevice sends msg to the device of token.
+	SendToDevice(ctx context.Context, token string, msg Message) (SendResult, error)
+	// SendToTopic sends msg to the devices subscribed to topic.
+	SendToTopic(ctx context.Context, topic string, msg Message) (SendResult, error)
+	// SendToAudience sends msg to the devices of tokens, in as many requests
+	// as the provider needs. A result is returned along with the error if
+	// some requests succeeded.
+	SendToAudience(ctx context.Context, tokens []string, msg Message) (SendResult, error)
+}
+
+// Message is a message of a Notifier.
+type Message struct {
+	Notification
+	// Silent sends only CustomContent to the app without showing anything.
+	Silent bool
+	// TTL is how long the provider keeps the message for devices offline,
+	// zero means the default of the provider.
+	TTL time.Duration
+}
+
+// SendResult is the outcome of a send of a Notifier.
+type SendResult struct {
+	Provider string
+	// IDs are the message IDs returned by the provider, one a request.
+	IDs []string
+	// Failed are the tokens of requests failed.
+	Failed []string
+	Sent   time.Time
+}
+
+// The kinds of errors of a Notifier.
+var (
+	// ErrInvalidDevice means the device token is invalid or not registered
+	// any more, the device should be forgotten.
+	ErrInvalidDevice = errors.New("invalid device")
+	// ErrInvalidRequest means the target or the message is invalid.
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrUnauthorized means the credentials or the certificates are invalid,
+	// or the app is not allowed to push so.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrThrottled means the quota is used up or requests are too frequent.
+	ErrThrottled = errors.New("throttled")
+	// ErrUnavailable means the provider could not be reached or failed
+	// temporarily, the send could be retried.
+	ErrUnavailable = errors.New("provider unavailable")
+	// ErrTopicNotFound means the topic does not exist.
+	ErrTopicNotFound = errors.New("topic not found")
+	// ErrProvider is any other error of the provider.
+	ErrProvider = errors.New("provider error")
+)
+
+// NotifyError is an error of a Notifier, errors.Is reports its kind as well
+// as the error of the provider it wraps.
+type NotifyError struct {
+	Provider string
+	Kind     error
+	Err      error
+}
+
+func (e *NotifyError) Error() string {
+	return fmt.Sprintf("%s: %v: %v", e.Provider, e.Kind, e.Err)
+}
+
+// Unwrap returns the error of the provider.
+func (e *NotifyError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of e.
+func (e *NotifyError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// baiduProvider is the name of Baidu Cloud Push Service as a provider.
+const baiduProvider = "baidu"
+
+// ChannelNotifier is the Notifier of a Channel, topics being tags.
+type ChannelNotifier struct {
+	channel *Channel
+}
+
+// NewChannelNotifier returns the Notifier of bc.
+func NewChannelNotifier(bc *Channel) *ChannelNotifier {
+	return &ChannelNotifier{channel: bc}
+}
+
+// Provider implements Notifier.
+func (n *ChannelNotifier) Provider() string {
+	return baiduProvider
+}
+
+// SendToDevice implements Notifier by PushMsgToSingleDevice.
+func (n *ChannelNotifier) SendToDevice(ctx context.Context, token string, msg Message) (SendResult, error) {
+	if token == "" {
+		return SendResult{}, n.error(ErrInvalidRequest, errors.New("device token is required"))
+	}
+	return n.send(ctx, PushRequest{Kind: PushSingle, ChannelID: token}, msg)
+}
+
+// SendToTopic implements Notifier by PushMsgToTaggedDevices of tag topic.
+func (n *ChannelNotifier) SendToTopic(ctx context.Context, topic string, msg Message) (SendResult, error) {
+	if topic == "" {
+		return SendResult{}, n.error(ErrInvalidRequest, errors.New("topic is required"))
+	}
+	return n.send(ctx, PushRequest{Kind: PushTag, Tag: topic}, msg)
+}
+
+// SendToAudience implements Notifier by PushMsgToBatchDevices in batches of
+// MaxBatchDevices, it stops at the first batch failed.
+func (n *ChannelNotifier) SendToAudience(ctx context.Context, tokens []string, msg Message) (SendResult, error) {
+	if len(tokens) == 0 {
+		return SendResult{}, n.error(ErrInvalidRequest, errors.New("no device tokens"))
+	}
+	result := SendResult{Provider: baiduProvider}
+	chunks := chunkStrings(tokens, MaxBatchDevices)
+	for i, chunk := range chunks {
+		batch, err := n.send(ctx, PushRequest{Kind: PushBatch, ChannelIDs: chunk}, msg)
+		if err != nil {
+			for _, rest := range chunks[i:] {
+				result.Failed = append(result.Failed, rest...)
+			}
+			return result, err
+		}
+		result.IDs = append(result.IDs, batch.IDs...)
+		result.Sent = batch.Sent
+	}
+	return result, nil
+}
+
+func (n *ChannelNotifier) send(ctx context.Context, req PushRequest, msg Message) (SendResult, error) {
+	var err error
+	req.Msg, req.Opts, err = n.render(msg)
+	if err != nil {
+		return SendResult{}, n.error(ErrInvalidRequest, err)
+	}
+	if err = ctx.Err(); err != nil {
+		return SendResult{}, err
+	}
+
+	pushed, err := n.channel.WithContext(ctx).Push(req)
+	if err != nil {
+		return SendResult{}, n.error(baiduErrorKind(err), err)
+	}
+	return SendResult{
+		Provider: baiduProvider,
+		IDs:      []string{pushed.MsgID},
+		Sent:     time.Unix(pushed.SendTime, 0),
+	}, nil
+}
+
+// render returns the msg and the options of msg on the platform of the
+// channel.
+func (n *ChannelNotifier) render(msg Message) (string, url.Values, error) {
+	opts := url.Values{}
+	if msg.TTL > 0 {
+		if msg.TTL > 7*24*time.Hour {
+			return "", nil, fmt.Errorf("TTL %s longer than 7 days", msg.TTL)
+		}
+		opts.Set("msg_expires", strconv.Itoa(int(msg.TTL/time.Second)))
+	}
+
+	platform := devicePlatform(n.channel.deviceType)
+	if !msg.Silent {
+		opts.Set("msg_type", strconv.Itoa(MsgTypeNotice))
+		rendered, err := msg.Render(platform)
+		return rendered, opts, err
+	}
+	if platform != Android {
+		return "", nil, errors.New("silent messages are only supported on Android")
+	}
+	opts.Set("msg_type", strconv.Itoa(MsgTypeMessage))
+	data, err := json.Marshal(msg.CustomContent)
+	return string(data), opts, err
+}
+
+func (n *ChannelNotifier) error(kind, err error) error {
+	return &NotifyError{Provider: baiduProvider, Kind: kind, Err: err}
+}
+
+// baiduErrorKind returns the kind of err returned by the service. Of errors
+// not returned by service, network errors make it unavailable, responses not
+// understood are errors of the provider and the others, such as a parameter
+// not allowed, are made locally by validating the request.
+func baiduErrorKind(err error) error {
+	var syntaxErr *json.SyntaxError
+	switch code := ErrorCode(err); {
+	case code == 0 && (isTemporary(err) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)):
+		return ErrUnavailable
+	case code == 0 && errors.As(err, &syntaxErr):
+		return ErrProvider
+	case code == 0:
+		return ErrInvalidRequest
+	case code == 30600 || code == 30606:
+		return ErrUnavailable
+	case IsDeadToken(err) || code == 30607:
+		return ErrInvalidDevice
+	case code == 30604 || code == 30699:
+		return ErrThrottled
+	case code == 30611:
+		return ErrTopicNotFound
+	case code == 30602 || code == 30605 || code == 30619 || code == 30621 || code == 40002 || code == 40005:
+		return ErrInvalidRequest
+	case code == 30603 || code >= 30612 && code <= 30618 || code == 30620 || code >= 40004 && code <= 40012:
+		return ErrUnauthorized
+	}
+	return ErrProvider
+}
@@ -0,0 +1,79 @@
+package baidupush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestBaiduErrorKind(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind error
+	}{
+		{&url.Error{Op: "Post", URL: "http://host", Err: errors.New("connection refused")}, ErrUnavailable},
+		{errors.New("invalid parameter: foo is not allowed in API PushMsgToSingleDevice"), ErrInvalidRequest},
+		{&json.SyntaxError{Offset: 1}, ErrProvider},
+		{checkErrorCode(30606), ErrUnavailable},
+		{checkErrorCode(40003), ErrInvalidDevice},
+		{checkErrorCode(30607), ErrInvalidDevice},
+		{checkErrorCode(30699), ErrThrottled},
+		{checkErrorCode(30611), ErrTopicNotFound},
+		{checkErrorCode(40002), ErrInvalidRequest},
+		{checkErrorCode(40008), ErrUnauthorized},
+		{checkErrorCode(30613), ErrUnauthorized},
+		{checkErrorCode(41001), ErrProvider},
+	}
+	for _, c := range cases {
+		if kind := baiduErrorKind(c.err); kind != c.kind {
+			t.Errorf("kind of %v is %v want %v", c.err, kind, c.kind)
+		}
+	}
+}
+
+func TestChannelNotifierMessages(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	android := NewChannelNotifier(NewChannel(srv.host(), "key", "secret", AndroidDeviceType))
+	ios := NewChannelNotifier(NewChannel(srv.host(), "key", "secret", AppleDeviceType))
+	ctx := context.Background()
+
+	silent := Message{Silent: true, TTL: time.Hour, Notification: Notification{CustomContent: map[string]interface{}{"sync": true}}}
+	if _, err := android.SendToDevice(ctx, "chn", silent); err != nil {
+		t.Fatal("silent send error", err)
+	}
+	params := srv.received()[0].params
+	if params.Get("msg_type") != "0" || params.Get("msg") != `{"sync":true}` || params.Get("msg_expires") != "3600" {
+		t.Errorf("silent message params %v", params)
+	}
+
+	if _, err := ios.SendToDevice(ctx, "chn", silent); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("silent iOS message error %v", err)
+	}
+	if _, err := android.SendToDevice(ctx, "chn", Message{TTL: 8 * 24 * time.Hour}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("long TTL error %v", err)
+	}
+
+	if _, err := ios.SendToTopic(ctx, "vip", Message{Notification: Notification{Title: "hi", Badge: 1}}); err != nil {
+		t.Fatal("topic send error", err)
+	}
+	params = srv.received()[1].params
+	if params.Get("tag") != "vip" || params.Get("msg_type") != "1" || params.Get("msg") != `{"aps":{"alert":"hi","badge":1}}` {
+		t.Errorf("topic message params %v", params)
+	}
+}
+
+func TestChannelNotifierCanceledBackoff(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	srv.Close()
+	policy := RetryPolicy{MaxAttempts: 3, MinBackoff: time.Hour, MaxBackoff: time.Hour}
+	n := NewChannelNotifier(NewChannel(srv.host(), "key", "secret", AndroidDeviceType, WithRetryPolicy(policy)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := n.SendToDevice(ctx, "chn", Message{Notification: Notification{Title: "hi"}}); !errors.Is(err, context.Canceled) || !errors.Is(err, ErrUnavailable) {
+		t.Errorf("error %v want canceled while backing off", err)
+	}
+}
@@ -0,0 +1,127 @@
+package notifiertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BaiduFake is a Fake of Baidu Cloud Push Service serving the push APIs
+// locally, tags being topics.
+type BaiduFake struct {
+	server      *httptest.Server
+	mu          sync.Mutex
+	deliveries  []Delivery
+	rejected    map[string]bool
+	unavailable bool
+	unreachable bool
+	msgs        int
+}
+
+// NewBaiduFake starts a fake closed when t finishes.
+func NewBaiduFake(t *testing.T) *BaiduFake {
+	f := &BaiduFake{rejected: map[string]bool{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// Host returns the address of the fake to pass to baidupush.NewChannel.
+func (f *BaiduFake) Host() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+// Deliveries implements Fake.
+func (f *BaiduFake) Deliveries() []Delivery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Delivery{}, f.deliveries...)
+}
+
+// RejectDevice implements Fake, pushes to the single device of token fail
+// with 30608 (bind relation not found).
+func (f *BaiduFake) RejectDevice(token string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rejected[token] = true
+}
+
+// SetUnavailable implements Fake, pushes fail with 30600 (internal server
+// error) while unavailable.
+func (f *BaiduFake) SetUnavailable(unavailable bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unavailable = unavailable
+}
+
+// SetUnreachable implements Fake, connections are closed without a response
+// while unreachable.
+func (f *BaiduFake) SetUnreachable(unreachable bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unreachable = unreachable
+}
+
+func (f *BaiduFake) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	unreachable := f.unreachable
+	f.mu.Unlock()
+	if unreachable {
+		if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	r.ParseForm()
+	delivery := Delivery{}
+	switch r.URL.Path {
+	case "/rest/3.0/push/single_device":
+		delivery.Devices = []string{r.Form.Get("channel_id")}
+	case "/rest/3.0/push/tags":
+		delivery.Topic = r.Form.Get("tag")
+	case "/rest/3.0/push/batch_device":
+		json.Unmarshal([]byte(r.Form.Get("channel_ids")), &delivery.Devices)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	delivery.Title, delivery.Description = decodeBaiduMsg(r.Form.Get("msg"))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rsp := map[string]interface{}{"request_id": 1}
+	switch {
+	case f.unavailable:
+		rsp["error_code"], rsp["error_msg"] = 30600, "internal server error"
+	case len(delivery.Devices) == 1 && f.rejected[delivery.Devices[0]] && strings.HasSuffix(r.URL.Path, "single_device"):
+		rsp["error_code"], rsp["error_msg"] = 30608, "bind relation not found"
+	default:
+		f.msgs++
+		f.deliveries = append(f.deliveries, delivery)
+		rsp["response_params"] = map[string]interface{}{"msg_id": fmt.Sprintf("msg-%d", f.msgs), "send_time": time.Now().Unix()}
+	}
+	json.NewEncoder(w).Encode(rsp)
+}
+
+// decodeBaiduMsg returns the title and the description of an Android or an
+// iOS msg.
+func decodeBaiduMsg(msg string) (string, string) {
+	fields := struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		APS         struct {
+			Alert string `json:"alert"`
+		} `json:"aps"`
+	}{}
+	json.Unmarshal([]byte(msg), &fields)
+	if fields.APS.Alert != "" {
+		return fields.Title, fields.APS.Alert
+	}
+	return fields.Title, fields.Description
+}
@@ -0,0 +1,15 @@
+package notifiertest
+
+import (
+	"testing"
+
+	baidupush "github.com/leesper/baidupush-golang"
+)
+
+func TestChannelNotifier(t *testing.T) {
+	Run(t, func(t *testing.T) (baidupush.Notifier, Fake) {
+		fake := NewBaiduFake(t)
+		bc := baidupush.NewChannel(fake.Host(), "key", "secret", baidupush.AndroidDeviceType)
+		return baidupush.NewChannelNotifier(bc), fake
+	})
+}
@@ -0,0 +1,208 @@
+// Package notifiertest tests implementations of baidupush.Notifier against
+// local fakes of their providers, so that every adapter behaves the same.
+//
+// An adapter is tested by running Run with a fake of its provider:
+//
+//	func TestConformance(t *testing.T) {
+//		notifiertest.Run(t, func(t *testing.T) (baidupush.Notifier, notifiertest.Fake) {
+//			fake := newFakeProvider(t)
+//			return newNotifier(fake.URL), fake
+//		})
+//	}
+package notifiertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	baidupush "github.com/leesper/baidupush-golang"
+)
+
+// Delivery is a message received by a fake provider.
+type Delivery struct {
+	// Devices are the device tokens the message was sent to, empty if sent to
+	// a topic.
+	Devices     []string
+	Topic       string
+	Title       string
+	Description string
+}
+
+// Fake is a local fake of a provider.
+type Fake interface {
+	// Deliveries returns the messages received so far in order.
+	Deliveries() []Delivery
+	// RejectDevice makes the provider reject token as not registered.
+	RejectDevice(token string)
+	// SetUnavailable makes the provider fail temporarily until set back.
+	SetUnavailable(unavailable bool)
+	// SetUnreachable makes the provider drop connections without answering
+	// until set back.
+	SetUnreachable(unreachable bool)
+}
+
+// AudienceSize is the number of devices of the audience sent to by Run, large
+// enough to need several requests of most providers.
+const AudienceSize = 12000
+
+// Run runs the conformance tests of the Notifier returned by newNotifier,
+// which is called with a new fake for every test.
+func Run(t *testing.T, newNotifier func(t *testing.T) (baidupush.Notifier, Fake)) {
+	msg := baidupush.Message{Notification: baidupush.Notification{Title: "hello", Description: "hello world"}}
+
+	t.Run("SendToDevice", func(t *testing.T) {
+		n, fake := newNotifier(t)
+		result, err := n.SendToDevice(context.Background(), "device-1", msg)
+		if err != nil {
+			t.Fatal("send error", err)
+		}
+		checkResult(t, n, result)
+		deliveries := fake.Deliveries()
+		if len(deliveries) != 1 || !equal(deliveries[0].Devices, []string{"device-1"}) {
+			t.Fatalf("deliveries %+v want one to device-1", deliveries)
+		}
+		if d := deliveries[0]; d.Title != "hello" || d.Description != "hello world" {
+			t.Errorf("delivered %q %q want hello, hello world", d.Title, d.Description)
+		}
+	})
+
+	t.Run("SendToTopic", func(t *testing.T) {
+		n, fake := newNotifier(t)
+		result, err := n.SendToTopic(context.Background(), "news", msg)
+		if err != nil {
+			t.Fatal("send error", err)
+		}
+		checkResult(t, n, result)
+		if deliveries := fake.Deliveries(); len(deliveries) != 1 || deliveries[0].Topic != "news" || len(deliveries[0].Devices) != 0 {
+			t.Errorf("deliveries %+v want one to topic news", deliveries)
+		}
+	})
+
+	t.Run("SendToAudience", func(t *testing.T) {
+		n, fake := newNotifier(t)
+		tokens := make([]string, AudienceSize)
+		for i := range tokens {
+			tokens[i] = fmt.Sprintf("device-%d", i)
+		}
+		result, err := n.SendToAudience(context.Background(), tokens, msg)
+		if err != nil {
+			t.Fatal("send error", err)
+		}
+		checkResult(t, n, result)
+		received := []string{}
+		for _, d := range fake.Deliveries() {
+			received = append(received, d.Devices...)
+		}
+		sort.Strings(received)
+		sort.Strings(tokens)
+		if !equal(received, tokens) {
+			t.Errorf("%d devices received want each of %d once", len(received), len(tokens))
+		}
+	})
+
+	t.Run("InvalidRequest", func(t *testing.T) {
+		n, fake := newNotifier(t)
+		ctx := context.Background()
+		errs := []error{}
+		_, err := n.SendToDevice(ctx, "", msg)
+		errs = append(errs, err)
+		_, err = n.SendToTopic(ctx, "", msg)
+		errs = append(errs, err)
+		_, err = n.SendToAudience(ctx, nil, msg)
+		errs = append(errs, err)
+		for _, err := range errs {
+			checkKind(t, n, err, baidupush.ErrInvalidRequest)
+		}
+		if len(fake.Deliveries()) != 0 {
+			t.Error("invalid request delivered")
+		}
+	})
+
+	t.Run("InvalidMessage", func(t *testing.T) {
+		n, fake := newNotifier(t)
+		expiring := msg
+		expiring.TTL = 365 * 24 * time.Hour
+		_, err := n.SendToDevice(context.Background(), "device-1", expiring)
+		checkKind(t, n, err, baidupush.ErrInvalidRequest)
+		if len(fake.Deliveries()) != 0 {
+			t.Error("invalid message delivered")
+		}
+	})
+
+	t.Run("InvalidDevice", func(t *testing.T) {
+		n, fake := newNotifier(t)
+		fake.RejectDevice("gone")
+		_, err := n.SendToDevice(context.Background(), "gone", msg)
+		checkKind(t, n, err, baidupush.ErrInvalidDevice)
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		n, fake := newNotifier(t)
+		fake.SetUnavailable(true)
+		_, err := n.SendToDevice(context.Background(), "device-1", msg)
+		checkKind(t, n, err, baidupush.ErrUnavailable)
+
+		fake.SetUnavailable(false)
+		if _, err = n.SendToDevice(context.Background(), "device-1", msg); err != nil {
+			t.Error("send after recovery error", err)
+		}
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		n, fake := newNotifier(t)
+		fake.SetUnreachable(true)
+		_, err := n.SendToDevice(context.Background(), "device-1", msg)
+		checkKind(t, n, err, baidupush.ErrUnavailable)
+
+		fake.SetUnreachable(false)
+		if _, err = n.SendToDevice(context.Background(), "device-1", msg); err != nil {
+			t.Error("send after recovery error", err)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		n, fake := newNotifier(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := n.SendToDevice(ctx, "device-1", msg); !errors.Is(err, context.Canceled) {
+			t.Errorf("error %v want context canceled", err)
+		}
+		if len(fake.Deliveries()) != 0 {
+			t.Error("canceled send delivered")
+		}
+	})
+}
+
+func checkResult(t *testing.T, n baidupush.Notifier, result baidupush.SendResult) {
+	t.Helper()
+	if result.Provider != n.Provider() || len(result.IDs) == 0 || len(result.Failed) != 0 {
+		t.Errorf("result %+v want provider %s and message IDs", result, n.Provider())
+	}
+}
+
+func checkKind(t *testing.T, n baidupush.Notifier, err, kind error) {
+	t.Helper()
+	if !errors.Is(err, kind) {
+		t.Errorf("error %v want %v", err, kind)
+	}
+	var ne *baidupush.NotifyError
+	if !errors.As(err, &ne) || ne.Provider != n.Provider() {
+		t.Errorf("error %v want a NotifyError of %s", err, n.Provider())
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,263 @@
+package baidupush
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OutboxStatus is the delivery status of an outbox entry.
+type OutboxStatus string
+
+const (
+	// OutboxPending means the entry is waiting to be pushed.
+	OutboxPending OutboxStatus = "pending"
+	// OutboxSent means the entry was pushed, its result is recorded.
+	OutboxSent OutboxStatus = "sent"
+	// OutboxFailed means the entry failed permanently or ran out of attempts.
+	OutboxFailed OutboxStatus = "failed"
+)
+
+// OutboxEntry is a push request persisted in Outbox.
+type OutboxEntry struct {
+	ID          string       `json:"id"`
+	Request     PushRequest  `json:"request"`
+	Status      OutboxStatus `json:"status"`
+	Created     time.Time    `json:"created"`
+	Attempts    int          `json:"attempts"`
+	NextAttempt time.Time    `json:"next_attempt"`
+	LastError   string       `json:"last_error,omitempty"`
+	Result      PushResult   `json:"result"`
+}
+
+// Outbox is a file-backed queue of push requests. Requests are persisted
+// before they are pushed by OutboxWorker, so that none is lost if the process
+// crashes or the service is down. A request is pushed with the ID of its entry
+// as idempotency key unless it has one, so that it is pushed once even if the
+// process crashes right after pushing it, as long as the channel remembers
+// pushes in a DedupStore surviving the process, see WithDedupStore.
+//
+// The file is an append-only log of JSON lines, each one a snapshot of an
+// entry, the latest snapshot of an entry wins. It is safe for concurrent use.
+type Outbox struct {
+	mu      sync.Mutex
+	log     *jsonLog
+	entries map[string]*OutboxEntry
+	order   []string
+	now     func() time.Time
+}
+
+// OpenOutbox opens the outbox stored in file path, creating it if not existed.
+func OpenOutbox(path string) (*Outbox, error) {
+	o := &Outbox{
+		entries: map[string]*OutboxEntry{},
+		now:     time.Now,
+	}
+	var err error
+	o.log, err = openJSONLog(path, "outbox", o.apply)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// apply keeps the snapshot entry in memory.
+func (o *Outbox) apply(entry OutboxEntry) {
+	if _, ok := o.entries[entry.ID]; !ok {
+		o.order = append(o.order, entry.ID)
+	}
+	o.entries[entry.ID] = &entry
+}
+
+// Enqueue persists req and returns the ID of its entry.
+func (o *Outbox) Enqueue(req PushRequest) (string, error) {
+	return o.EnqueueAt(req, time.Time{})
+}
+
+// EnqueueAt persists req to be pushed not before t and returns the ID of its
+// entry, a zero t means now.
+func (o *Outbox) EnqueueAt(req PushRequest, t time.Time) (string, error) {
+	id, err := newOutboxID()
+	if err != nil {
+		return "", err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := o.now()
+	if t.IsZero() {
+		t = now
+	}
+	entry := &OutboxEntry{
+		ID:          id,
+		Request:     req,
+		Status:      OutboxPending,
+		Created:     now,
+		NextAttempt: t,
+	}
+	if err = o.log.append(entry); err != nil {
+		return "", err
+	}
+	o.apply(*entry)
+	return id, nil
+}
+
+// Entry returns the entry of id.
+func (o *Outbox) Entry(id string) (OutboxEntry, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry, ok := o.entries[id]
+	if !ok {
+		return OutboxEntry{}, false
+	}
+	return *entry, true
+}
+
+// Pending returns entries not pushed yet in the order they were enqueued.
+func (o *Outbox) Pending() []OutboxEntry {
+	return o.pending(time.Time{})
+}
+
+// pending returns pending entries due at t, or all of them if t is zero.
+func (o *Outbox) pending(t time.Time) []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries := []OutboxEntry{}
+	for _, id := range o.order {
+		entry := o.entries[id]
+		if entry.Status == OutboxPending && (t.IsZero() || !entry.NextAttempt.After(t)) {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries
+}
+
+// update persists a new snapshot of entry.
+func (o *Outbox) update(entry OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.log.append(entry); err != nil {
+		return err
+	}
+	o.apply(entry)
+	return nil
+}
+
+// Compact rewrites the log with pending entries only, forgetting sent and
+// failed ones.
+func (o *Outbox) Compact() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	order := []string{}
+	entries := map[string]*OutboxEntry{}
+	values := []interface{}{}
+	for _, id := range o.order {
+		if entry := o.entries[id]; entry.Status == OutboxPending {
+			order = append(order, id)
+			entries[id] = entry
+			values = append(values, entry)
+		}
+	}
+	if err := o.log.compact(values); err != nil {
+		return err
+	}
+	o.order = order
+	o.entries = entries
+	return nil
+}
+
+// Close closes the file of outbox.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.log.close()
+}
+
+func newOutboxID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// OutboxWorker drains an outbox by pushing its entries through a channel,
+// retrying temporary failures by policy.
+type OutboxWorker struct {
+	outbox  *Outbox
+	channel *Channel
+	policy  RetryPolicy
+	now     func() time.Time
+}
+
+// NewOutboxWorker returns a worker pushing entries of outbox through bc.
+func NewOutboxWorker(outbox *Outbox, bc *Channel, policy RetryPolicy) *OutboxWorker {
+	return &OutboxWorker{
+		outbox:  outbox,
+		channel: bc,
+		policy:  policy,
+		now:     time.Now,
+	}
+}
+
+// Drain pushes the entries due now once and returns the number of entries
+// sent, failed pushes are recorded in entries, the error returned is about
+// persisting them.
+func (w *OutboxWorker) Drain() (int, error) {
+	sent := 0
+	for _, entry := range w.outbox.pending(w.now()) {
+		req := entry.Request
+		if req.IdempotencyKey == "" {
+			req.IdempotencyKey = entry.ID
+		}
+		result, err := w.channel.Push(req)
+		entry.Attempts++
+		if err == nil {
+			entry.Status = OutboxSent
+			entry.Result = result
+			entry.LastError = ""
+			sent++
+		} else {
+			entry.LastError = redactError(err, "")
+			if !isTemporary(err) || entry.Attempts >= w.policy.MaxAttempts {
+				entry.Status = OutboxFailed
+			} else {
+				entry.NextAttempt = w.now().Add(w.policy.Backoff(entry.Attempts))
+			}
+		}
+
+		if err = w.outbox.update(entry); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// Run drains the outbox every interval until ctx is done or persisting fails,
+// interval must be positive.
+func (w *OutboxWorker) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid interval %s", interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.Drain(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package baidupush
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOutboxDelivery(t *testing.T) {
+	failures := map[string]int{"temporary": 30600, "permanent": 30608}
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		if code, ok := failures[call.params.Get("channel_id")]; ok {
+			return nil, code
+		}
+		return pushOK(call)
+	})
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+	path := filepath.Join(t.TempDir(), "outbox.log")
+
+	outbox, err := OpenOutbox(path)
+	if err != nil {
+		t.Fatal("open outbox error", err)
+	}
+	ids := map[string]string{}
+	for _, chn := range []string{"ok", "temporary", "permanent"} {
+		id, err := outbox.Enqueue(PushRequest{Kind: PushSingle, ChannelID: chn, Msg: "hello"})
+		if err != nil {
+			t.Fatal("enqueue error", err)
+		}
+		ids[chn] = id
+	}
+
+	// a crash before pushing loses nothing
+	outbox.Close()
+	if outbox, err = OpenOutbox(path); err != nil {
+		t.Fatal("reopen outbox error", err)
+	}
+	defer outbox.Close()
+	if n := len(outbox.Pending()); n != 3 {
+		t.Fatalf("pending %d want 3", n)
+	}
+
+	now := time.Now()
+	worker := NewOutboxWorker(outbox, bc, RetryPolicy{MaxAttempts: 2, MinBackoff: time.Minute, MaxBackoff: time.Hour})
+	worker.now = func() time.Time { return now }
+
+	sent, err := worker.Drain()
+	if err != nil || sent != 1 {
+		t.Fatalf("drain sent %d error %v want 1 nil", sent, err)
+	}
+
+	entry, _ := outbox.Entry(ids["ok"])
+	if entry.Status != OutboxSent || entry.Result.MsgID != "msg-single_device" {
+		t.Errorf("entry ok status %s msg ID %s want sent msg-single_device", entry.Status, entry.Result.MsgID)
+	}
+	entry, _ = outbox.Entry(ids["permanent"])
+	if entry.Status != OutboxFailed || entry.LastError != "30608 - bind relation not found" {
+		t.Errorf("entry permanent status %s error %s want failed 30608", entry.Status, entry.LastError)
+	}
+	entry, _ = outbox.Entry(ids["temporary"])
+	if entry.Status != OutboxPending || !entry.NextAttempt.Equal(now.Add(time.Minute)) {
+		t.Errorf("entry temporary status %s next attempt %v want pending after 1 minute", entry.Status, entry.NextAttempt)
+	}
+
+	// not due yet
+	if sent, _ = worker.Drain(); sent != 0 || len(srv.received()) != 3 {
+		t.Errorf("drain before backoff sent %d requests %d want 0 3", sent, len(srv.received()))
+	}
+
+	now = now.Add(time.Minute)
+	worker.Drain()
+	entry, _ = outbox.Entry(ids["temporary"])
+	if entry.Status != OutboxFailed || entry.Attempts != 2 {
+		t.Errorf("entry temporary status %s attempts %d want failed 2", entry.Status, entry.Attempts)
+	}
+
+	if err = outbox.Compact(); err != nil {
+		t.Fatal("compact error", err)
+	}
+	if _, ok := outbox.Entry(ids["ok"]); ok {
+		t.Error("sent entry kept after compaction")
+	}
+	if _, err = outbox.Enqueue(PushRequest{Kind: PushAll, Msg: "after compaction"}); err != nil {
+		t.Fatal("enqueue after compaction error", err)
+	}
+	outbox.Close()
+	if outbox, err = OpenOutbox(path); err != nil {
+		t.Fatal("reopen compacted outbox error", err)
+	}
+	if pending := outbox.Pending(); len(pending) != 1 || pending[0].Request.Msg != "after compaction" {
+		t.Errorf("pending %v want the entry enqueued after compaction", pending)
+	}
+}
+
+func TestOutboxInvalidRequestNotRetried(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+	outbox, err := OpenOutbox(filepath.Join(t.TempDir(), "outbox.log"))
+	if err != nil {
+		t.Fatal("open outbox error", err)
+	}
+	defer outbox.Close()
+
+	id, err := outbox.Enqueue(PushRequest{Kind: "bogus", Msg: "hello"})
+	if err != nil {
+		t.Fatal("enqueue error", err)
+	}
+	worker := NewOutboxWorker(outbox, bc, RetryPolicy{MaxAttempts: 3, MinBackoff: time.Minute, MaxBackoff: time.Hour})
+	if sent, err := worker.Drain(); sent != 0 || err != nil {
+		t.Fatalf("drain sent %d error %v want 0 nil", sent, err)
+	}
+
+	entry, _ := outbox.Entry(id)
+	if entry.Status != OutboxFailed || entry.Attempts != 1 {
+		t.Errorf("entry status %s attempts %d want failed 1", entry.Status, entry.Attempts)
+	}
+	if n := len(srv.received()); n != 0 {
+		t.Errorf("requests %d want 0", n)
+	}
+	if err := worker.Run(context.Background(), 0); err == nil {
+		t.Error("run with zero interval succeeded")
+	}
+}
+
+func TestOutboxTruncatedLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	outbox, err := OpenOutbox(path)
+	if err != nil {
+		t.Fatal("open outbox error", err)
+	}
+	outbox.Enqueue(PushRequest{Kind: PushAll, Msg: "kept"})
+	outbox.Close()
+
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"id":"half-writ`)
+	f.Close()
+
+	if outbox, err = OpenOutbox(path); err != nil {
+		t.Fatal("open truncated outbox error", err)
+	}
+	defer outbox.Close()
+	if n := len(outbox.Pending()); n != 1 {
+		t.Errorf("pending %d want 1", n)
+	}
+	if _, err = outbox.Enqueue(PushRequest{Kind: PushAll, Msg: "appended"}); err != nil {
+		t.Fatal("enqueue error", err)
+	}
+	outbox.Close()
+	if outbox, err = OpenOutbox(path); err != nil {
+		t.Fatal("reopen outbox error", err)
+	}
+	if n := len(outbox.Pending()); n != 2 {
+		t.Errorf("pending %d want 2", n)
+	}
+}
+
+func TestOutboxPushedOnceAfterCrash(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	dedup := NewMemoryDedupStore(10, time.Hour)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "outbox.log")
+
+	outbox, err := OpenOutbox(path)
+	if err != nil {
+		t.Fatal("open outbox error", err)
+	}
+	id, _ := outbox.Enqueue(PushRequest{Kind: PushSingle, ChannelID: "chn", Msg: "hello"})
+	// the log as left by a crash right after pushing the entry
+	data, _ := os.ReadFile(path)
+	crashed := filepath.Join(dir, "crashed.log")
+	os.WriteFile(crashed, data, 0600)
+
+	policy := RetryPolicy{MaxAttempts: 2, MinBackoff: time.Minute, MaxBackoff: time.Hour}
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType, WithDedupStore(dedup))
+	if sent, err := NewOutboxWorker(outbox, bc, policy).Drain(); sent != 1 || err != nil {
+		t.Fatalf("drain sent %d error %v want 1 nil", sent, err)
+	}
+	outbox.Close()
+
+	if outbox, err = OpenOutbox(crashed); err != nil {
+		t.Fatal("open crashed outbox error", err)
+	}
+	defer outbox.Close()
+	bc = NewChannel(srv.host(), "key", "secret", AndroidDeviceType, WithDedupStore(dedup))
+	if sent, err := NewOutboxWorker(outbox, bc, policy).Drain(); sent != 1 || err != nil {
+		t.Fatalf("drain after crash sent %d error %v want 1 nil", sent, err)
+	}
+	if entry, _ := outbox.Entry(id); !entry.Result.Duplicate || entry.Request.IdempotencyKey != "" {
+		t.Errorf("entry result duplicate %v key %q want true and the request unchanged", entry.Result.Duplicate, entry.Request.IdempotencyKey)
+	}
+	if n := len(srv.received()); n != 1 {
+		t.Errorf("service received %d pushes want 1", n)
+	}
+}
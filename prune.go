@@ -0,0 +1,193 @@
+package baidupush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IsDeadToken reports whether err means the channel ID pushed to is dead and
+// should not be pushed to any more: an invalid or bad iOS device token
+// (40001, 40003) or a bind relation not found (30608).
+func IsDeadToken(err error) bool {
+	switch ErrorCode(err) {
+	case 40001, 40003, 30608:
+		return true
+	}
+	return false
+}
+
+// DeadTokenHandler is called with the channel ID of a device when a push to it
+// through bc failed with an error satisfying IsDeadToken.
+type DeadTokenHandler func(bc *Channel, channelID string, err error)
+
+// WithDeadTokenHandler makes channel call h when a push to a single device
+// finds its channel ID dead. h is called before the push returns, so it should
+// not block. Only pushes to a single device report dead channel IDs, because
+// the service answers a batch push with a msg ID only; UserPusher and
+// Registry.PushNotification push iOS devices one by one for this reason.
+func WithDeadTokenHandler(h DeadTokenHandler) ChannelOption {
+	return func(bc *Channel) {
+		bc.deadToken = h
+	}
+}
+
+func (bc *Channel) reportDeadToken(channelID string, err error) {
+	if bc.deadToken != nil && IsDeadToken(err) {
+		bc.deadToken(bc, channelID, err)
+	}
+}
+
+// DeviceMarker is implemented by device stores able to mark a device dead
+// instead of forgetting it, dead devices are not pushed to by UserPusher.
+type DeviceMarker interface {
+	// MarkDead marks the device of channelID dead since t, it is not an
+	// error if there is none.
+	MarkDead(channelID string, t time.Time) error
+}
+
+// DefaultPruneQueueSize is the number of dead channel IDs queued by
+// TokenPruner.Handle by default.
+const DefaultPruneQueueSize = 1000
+
+// ErrPruneQueueFull is reported to TokenPruner.OnError with a dead channel ID
+// dropped because the queue of the pruner is full.
+var ErrPruneQueueFull = errors.New("prune queue full")
+
+// TokenPruner prunes dead channel IDs, pass its Handle to
+// WithDeadTokenHandler and keep its Run running.
+type TokenPruner struct {
+	// Store, if set, forgets dead devices.
+	Store DeviceStore
+	// Mark marks dead devices instead of forgetting them, Store must
+	// implement DeviceMarker.
+	Mark bool
+	// Tags are the tags dead devices are deleted from by DeleteTagDevices.
+	Tags []string
+	// QueueSize is the number of dead channel IDs queued by Handle at most,
+	// DefaultPruneQueueSize if zero.
+	QueueSize int
+	// OnError, if set, is called with errors of pruning, which are ignored
+	// otherwise.
+	OnError func(channelID string, err error)
+
+	once  sync.Once
+	queue chan deadChannel
+}
+
+// deadChannel is a dead channel ID queued with the channel it was pushed
+// through.
+type deadChannel struct {
+	bc        *Channel
+	channelID string
+}
+
+func (p *TokenPruner) init() chan deadChannel {
+	p.once.Do(func() {
+		size := p.QueueSize
+		if size <= 0 {
+			size = DefaultPruneQueueSize
+		}
+		p.queue = make(chan deadChannel, size)
+	})
+	return p.queue
+}
+
+// Handle is a DeadTokenHandler queueing channelID to be pruned by Run, so that
+// the push is not held up by pruning. A channel ID is dropped with
+// ErrPruneQueueFull if the queue is full.
+func (p *TokenPruner) Handle(bc *Channel, channelID string, _ error) {
+	select {
+	case p.init() <- deadChannel{bc: bc, channelID: channelID}:
+	default:
+		p.report(channelID, ErrPruneQueueFull)
+	}
+}
+
+// Run prunes the channel IDs queued by Handle until ctx is done. Channel IDs
+// queued together are deleted from each tag by as few DeleteTagDevices as
+// possible, through their channels with ctx.
+func (p *TokenPruner) Run(ctx context.Context) error {
+	queue := p.init()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case dead := <-queue:
+			batch := []deadChannel{dead}
+			for n := len(queue); n > 0; n-- {
+				batch = append(batch, <-queue)
+			}
+			p.pruneBatch(ctx, batch)
+		}
+	}
+}
+
+func (p *TokenPruner) pruneBatch(ctx context.Context, batch []deadChannel) {
+	channels := []*Channel{}
+	channelIDs := map[*Channel][]string{}
+	seen := map[deadChannel]bool{}
+	for _, dead := range batch {
+		if seen[dead] {
+			continue
+		}
+		seen[dead] = true
+		if _, ok := channelIDs[dead.bc]; !ok {
+			channels = append(channels, dead.bc)
+		}
+		channelIDs[dead.bc] = append(channelIDs[dead.bc], dead.channelID)
+	}
+	for _, bc := range channels {
+		p.prune(bc.WithContext(ctx), channelIDs[bc], p.report)
+	}
+}
+
+func (p *TokenPruner) report(channelID string, err error) {
+	if p.OnError != nil {
+		p.OnError(channelID, err)
+	}
+}
+
+// Prune removes channelIDs from the store of p and deletes them from the tags
+// of p through bc at once, the error returned joins all failures.
+func (p *TokenPruner) Prune(bc *Channel, channelIDs ...string) error {
+	errs := []error{}
+	p.prune(bc, channelIDs, func(channelID string, err error) {
+		errs = append(errs, fmt.Errorf("%s: %w", channelID, err))
+	})
+	return errors.Join(errs...)
+}
+
+// prune removes channelIDs from the store and tags of p, and reports failures
+// by the channel IDs failed.
+func (p *TokenPruner) prune(bc *Channel, channelIDs []string, report func(channelID string, err error)) {
+	if p.Store != nil {
+		for _, channelID := range channelIDs {
+			if err := p.forget(channelID); err != nil {
+				report(channelID, err)
+			}
+		}
+	}
+	for _, tag := range p.Tags {
+		for _, chunk := range chunkStrings(channelIDs, 10) {
+			if _, err := bc.DeleteTagDevices(tag, chunk); err != nil {
+				for _, channelID := range chunk {
+					report(channelID, fmt.Errorf("delete from tag %s: %w", tag, err))
+				}
+			}
+		}
+	}
+}
+
+func (p *TokenPruner) forget(channelID string) error {
+	if !p.Mark {
+		return p.Store.Unregister(channelID)
+	}
+	marker, ok := p.Store.(DeviceMarker)
+	if !ok {
+		return fmt.Errorf("device store %T could not mark devices dead", p.Store)
+	}
+	return marker.MarkDead(channelID, time.Now())
+}
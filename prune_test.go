@@ -0,0 +1,86 @@
+package baidupush
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTokenPruner(t *testing.T) {
+	deleted := make(chan fakeCall, 10)
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		switch {
+		case call.apiClass == "tag":
+			deleted <- call
+			return map[string]interface{}{"result": []map[string]interface{}{{"channel_id": "dead", "result": 0}}}, 0
+		case call.params.Get("channel_id") == "dead" || call.params.Get("channel_id") == "gone":
+			return nil, 40003
+		case call.params.Get("channel_id") == "busy":
+			return nil, 30600
+		}
+		return pushOK(call)
+	})
+
+	for _, mark := range []bool{false, true} {
+		store := NewMemoryDeviceStore()
+		store.Register(Device{UserID: "u1", ChannelID: "dead", Platform: IOS, App: "shop"})
+		store.Register(Device{UserID: "u1", ChannelID: "busy", Platform: IOS, App: "shop"})
+		store.Register(Device{UserID: "u2", ChannelID: "gone", Platform: IOS, App: "shop"})
+		pruner := &TokenPruner{Store: store, Mark: mark, Tags: []string{"vip", "beta"}}
+		bc := NewChannel(srv.host(), "key", "secret", AppleDeviceType, WithDeadTokenHandler(pruner.Handle))
+
+		if _, _, err := bc.PushMsgToSingleDevice("busy", "hello", nil); err == nil {
+			t.Fatal("push to busy succeeded")
+		}
+		for _, chn := range []string{"dead", "gone", "dead"} {
+			if _, _, err := bc.PushMsgToSingleDevice(chn, "hello", nil); !IsDeadToken(err) {
+				t.Fatalf("error %v want dead token", err)
+			}
+		}
+		// pruned by Run only, the pushes are not held up
+		if devices, _ := store.Devices("u1"); len(devices) != 2 || devices[1].DeadSince != nil {
+			t.Fatalf("devices %v pruned before run", devices)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- pruner.Run(ctx) }()
+		for _, tag := range []string{"vip", "beta"} {
+			call := <-deleted
+			if call.apiMethod != "del_devices" || call.params.Get("tag") != tag || call.params.Get("channel_ids") != `["dead","gone"]` {
+				t.Errorf("tag call %s %v want dead and gone deleted from %s at once", call.apiMethod, call.params, tag)
+			}
+		}
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("run error %v want canceled", err)
+		}
+
+		devices, _ := store.Devices("u1")
+		gone, _ := store.Devices("u2")
+		if mark {
+			if len(devices) != 2 || devices[1].ChannelID != "dead" || devices[1].DeadSince == nil || devices[0].DeadSince != nil || len(gone) != 1 || gone[0].DeadSince == nil {
+				t.Errorf("devices %v %v want dead and gone marked", devices, gone)
+			}
+		} else if len(devices) != 1 || devices[0].ChannelID != "busy" || len(gone) != 0 {
+			t.Errorf("devices %v %v want busy only", devices, gone)
+		}
+	}
+	if n := len(deleted); n != 0 {
+		t.Errorf("%d more tag calls want none", n)
+	}
+}
+
+func TestTokenPrunerQueueFull(t *testing.T) {
+	dropped := []string{}
+	pruner := &TokenPruner{QueueSize: 1, OnError: func(channelID string, err error) {
+		if errors.Is(err, ErrPruneQueueFull) {
+			dropped = append(dropped, channelID)
+		}
+	}}
+	pruner.Handle(nil, "a", nil)
+	pruner.Handle(nil, "b", nil)
+	if len(dropped) != 1 || dropped[0] != "b" {
+		t.Errorf("dropped %v want b", dropped)
+	}
+}
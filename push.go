@@ -0,0 +1,86 @@
+package baidupush
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// PushKind is the kind of push, which decides the Channel method to call.
+type PushKind string
+
+const (
+	// PushSingle pushes by PushMsgToSingleDevice.
+	PushSingle PushKind = "single_device"
+	// PushAll pushes by PushMsgToAllDevices.
+	PushAll PushKind = "all"
+	// PushTag pushes by PushMsgToTaggedDevices.
+	PushTag PushKind = "tags"
+	// PushBatch pushes by PushMsgToBatchDevices.
+	PushBatch PushKind = "batch_device"
+)
+
+// PushRequest describes a push of message as a value, so that it could be
+// stored and sent later.
+//
+// A push with IdempotencyKey is made once within the window of the dedup
+// store of channel, pushing again with the same key returns the result of the
+// first push.
+type PushRequest struct {
+	Kind           PushKind   `json:"kind"`
+	ChannelID      string     `json:"channel_id,omitempty"`
+	ChannelIDs     []string   `json:"channel_ids,omitempty"`
+	Tag            string     `json:"tag,omitempty"`
+	Msg            string     `json:"msg"`
+	Opts           url.Values `json:"opts,omitempty"`
+	IdempotencyKey string     `json:"idempotency_key,omitempty"`
+}
+
+// PushResult is what the service returns for a push, Duplicate is set if the
+// result is of a former push with the same idempotency key.
+type PushResult struct {
+	MsgID     string `json:"msg_id"`
+	TimerID   string `json:"timer_id,omitempty"`
+	SendTime  int64  `json:"send_time"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+// Push sends req by the Channel method of its kind.
+func (bc *Channel) Push(req PushRequest) (PushResult, error) {
+	if req.IdempotencyKey == "" {
+		return bc.push(req)
+	}
+
+	bc.pushing.lock(req.IdempotencyKey)
+	defer bc.pushing.unlock(req.IdempotencyKey)
+
+	if result, ok := bc.dedup.Get(req.IdempotencyKey); ok {
+		result.Duplicate = true
+		return result, nil
+	}
+
+	result, err := bc.push(req)
+	if err == nil {
+		bc.dedup.Put(req.IdempotencyKey, result)
+	}
+	return result, err
+}
+
+func (bc *Channel) push(req PushRequest) (PushResult, error) {
+	result := PushResult{}
+	var err error
+
+	switch req.Kind {
+	case PushSingle:
+		result.MsgID, result.SendTime, err = bc.PushMsgToSingleDevice(req.ChannelID, req.Msg, req.Opts)
+	case PushAll:
+		result.MsgID, result.TimerID, result.SendTime, err = bc.PushMsgToAllDevices(req.Msg, req.Opts)
+	case PushTag:
+		result.MsgID, result.TimerID, result.SendTime, err = bc.PushMsgToTaggedDevices(req.Tag, req.Msg, req.Opts)
+	case PushBatch:
+		result.MsgID, result.SendTime, err = bc.PushMsgToBatchDevices(req.ChannelIDs, req.Msg, req.Opts)
+	default:
+		err = fmt.Errorf("invalid push kind %q", req.Kind)
+	}
+
+	return result, err
+}
@@ -0,0 +1,244 @@
+package baidupush
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DeliveryWindow is the time of day messages could be delivered to a
+// recipient in its time zone, such as 08:00 to 22:00. A window whose end is
+// before its start crosses midnight, and a window whose start equals its end
+// allows any time.
+type DeliveryWindow struct {
+	// Start and End are the time since midnight.
+	Start, End time.Duration
+	// Location is the time zone of recipient, defaults to UTC.
+	Location *time.Location
+}
+
+// ParseDeliveryWindow parses window like "08:00-22:00" in IANA time zone tz.
+func ParseDeliveryWindow(window, tz string) (DeliveryWindow, error) {
+	w := DeliveryWindow{}
+	var startH, startM, endH, endM int
+	if _, err := fmt.Sscanf(window, "%d:%d-%d:%d", &startH, &startM, &endH, &endM); err != nil {
+		return w, fmt.Errorf("invalid delivery window %q - must be like 08:00-22:00", window)
+	}
+	for _, hm := range [][2]int{{startH, startM}, {endH, endM}} {
+		if hm[0] < 0 || hm[0] > 24 || hm[1] < 0 || hm[1] > 59 || (hm[0] == 24 && hm[1] != 0) {
+			return w, fmt.Errorf("invalid delivery window %q - times must be 00:00 to 24:00", window)
+		}
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return w, err
+	}
+	w.Start = time.Duration(startH)*time.Hour + time.Duration(startM)*time.Minute
+	w.End = time.Duration(endH)*time.Hour + time.Duration(endM)*time.Minute
+	w.Location = loc
+	return w, nil
+}
+
+func (w DeliveryWindow) location() *time.Location {
+	if w.Location == nil {
+		return time.UTC
+	}
+	return w.Location
+}
+
+// Allows reports whether a message could be delivered at t.
+func (w DeliveryWindow) Allows(t time.Time) bool {
+	if w.Start == w.End {
+		return true
+	}
+	lt := t.In(w.location())
+	tod := time.Duration(lt.Hour())*time.Hour + time.Duration(lt.Minute())*time.Minute + time.Duration(lt.Second())*time.Second
+	if w.Start < w.End {
+		return tod >= w.Start && tod < w.End
+	}
+	return tod >= w.Start || tod < w.End
+}
+
+// Next returns the first time at or after t a message could be delivered.
+func (w DeliveryWindow) Next(t time.Time) time.Time {
+	if w.Allows(t) {
+		return t
+	}
+	lt := t.In(w.location())
+	start := time.Date(lt.Year(), lt.Month(), lt.Day(), 0, 0, 0, 0, w.location())
+	h, m := int(w.Start/time.Hour), int(w.Start%time.Hour/time.Minute)
+	next := time.Date(start.Year(), start.Month(), start.Day(), h, m, 0, 0, w.location())
+	if !next.After(t) {
+		next = time.Date(start.Year(), start.Month(), start.Day()+1, h, m, 0, 0, w.location())
+	}
+	return next
+}
+
+// Priority decides what happens to a message outside the delivery window.
+type Priority int
+
+const (
+	// PriorityLow messages are dropped outside the window.
+	PriorityLow Priority = iota
+	// PriorityNormal messages are deferred to the next window.
+	PriorityNormal
+	// PriorityHigh messages are sent at once whatever the window.
+	PriorityHigh
+)
+
+// DeliveryDecision is what QuietHours did to a push.
+type DeliveryDecision string
+
+const (
+	// DeliverNow means the push was sent at once.
+	DeliverNow DeliveryDecision = "sent"
+	// DeliverByTimer means the push was deferred by a timer task of the
+	// service with send_time.
+	DeliverByTimer DeliveryDecision = "timer"
+	// DeliverByQueue means the push was deferred by the local outbox.
+	DeliverByQueue DeliveryDecision = "queued"
+	// DeliverDropped means the push was dropped.
+	DeliverDropped DeliveryDecision = "dropped"
+)
+
+// Delivery records the decision on a push and its outcome.
+type Delivery struct {
+	Decision DeliveryDecision
+	// ChannelIDs are the devices of the push, if pushed to devices.
+	ChannelIDs []string
+	// At is when the push was sent or deferred to.
+	At time.Time
+	// Result is the result of a push sent or timed.
+	Result PushResult
+	// OutboxID is the ID of the entry of a push queued.
+	OutboxID string
+	Err      error
+}
+
+// QuietHours is a policy in front of the push methods keeping messages out of
+// the quiet hours of recipients. A message within the delivery window or of
+// PriorityHigh is sent at once, one of PriorityLow is dropped outside the
+// window, and one of PriorityNormal is deferred to the next window: by a timer
+// task of the service if the push is to all or tagged devices, or by Outbox
+// otherwise.
+type QuietHours struct {
+	// Outbox queues deferred pushes, required to defer pushes to devices.
+	Outbox *Outbox
+	// PreferQueue defers every push by Outbox, even those the service could
+	// time.
+	PreferQueue bool
+
+	now func() time.Time
+}
+
+func (q *QuietHours) clock() time.Time {
+	if q.now != nil {
+		return q.now()
+	}
+	return time.Now()
+}
+
+// Push pushes req through bc to recipients delivered in window at priority,
+// the error returned is that of the delivery.
+func (q *QuietHours) Push(bc *Channel, req PushRequest, window DeliveryWindow, priority Priority) (Delivery, error) {
+	now := q.clock()
+	d := q.deliver(bc, req, window.Next(now), priority, now)
+	switch req.Kind {
+	case PushSingle:
+		d.ChannelIDs = []string{req.ChannelID}
+	case PushBatch:
+		d.ChannelIDs = req.ChannelIDs
+	}
+	return d, d.Err
+}
+
+// deliver sends, defers or drops req allowed at slot.
+func (q *QuietHours) deliver(bc *Channel, req PushRequest, slot time.Time, priority Priority, now time.Time) Delivery {
+	d := Delivery{At: now}
+	if !slot.After(now) || priority >= PriorityHigh {
+		d.Decision = DeliverNow
+		d.Result, d.Err = bc.Push(req)
+		return d
+	}
+	if priority <= PriorityLow {
+		d.Decision = DeliverDropped
+		return d
+	}
+
+	d.At = slot
+	timed := req.Kind == PushAll || req.Kind == PushTag
+	delay := slot.Sub(now)
+	if timed && !q.PreferQueue && delay >= MinTimerDelay && delay <= MaxTimerDelay {
+		opts := url.Values{}
+		for k, v := range req.Opts {
+			opts[k] = v
+		}
+		opts.Set("send_time", strconv.FormatInt(slot.Unix(), 10))
+		req.Opts = opts
+		d.Decision = DeliverByTimer
+		d.Result, d.Err = bc.Push(req)
+		return d
+	}
+
+	d.Decision = DeliverByQueue
+	if q.Outbox == nil {
+		d.Err = errors.New("no outbox to defer push")
+		return d
+	}
+	d.OutboxID, d.Err = q.Outbox.EnqueueAt(req, slot)
+	return d
+}
+
+// WindowedDevice is a device with the delivery window of its user.
+type WindowedDevice struct {
+	ChannelID string
+	Window    DeliveryWindow
+}
+
+// PushDevices pushes msg through bc to devices at priority, devices of the
+// same decision and time are pushed together by PushMsgToBatchDevices in
+// batches of MaxBatchDevices, or by PushMsgToSingleDevice if alone. opts are
+// optional parameters of every push.
+//
+// Deliveries are returned in the order of their time, the error returned
+// joins errors of all failed deliveries.
+func (q *QuietHours) PushDevices(bc *Channel, devices []WindowedDevice, msg string, opts url.Values, priority Priority) ([]Delivery, error) {
+	now := q.clock()
+	groups := map[time.Time][]string{}
+	slots := []time.Time{}
+	for _, device := range devices {
+		slot := device.Window.Next(now)
+		if priority >= PriorityHigh || !slot.After(now) {
+			slot = now
+		}
+		// slots are grouped by instant whatever the time zone
+		slot = slot.UTC()
+		if _, ok := groups[slot]; !ok {
+			slots = append(slots, slot)
+		}
+		groups[slot] = append(groups[slot], device.ChannelID)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Before(slots[j]) })
+
+	deliveries := []Delivery{}
+	errs := []error{}
+	for _, slot := range slots {
+		for _, chunk := range chunkStrings(groups[slot], MaxBatchDevices) {
+			req := PushRequest{Kind: PushBatch, ChannelIDs: chunk, Msg: msg, Opts: opts}
+			if len(chunk) == 1 {
+				req = PushRequest{Kind: PushSingle, ChannelID: chunk[0], Msg: msg, Opts: opts}
+			}
+			d := q.deliver(bc, req, slot, priority, now)
+			d.ChannelIDs = chunk
+			if d.Err != nil {
+				errs = append(errs, d.Err)
+			}
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, errors.Join(errs...)
+}
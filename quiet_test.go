@@ -0,0 +1,99 @@
+package baidupush
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeliveryWindow(t *testing.T) {
+	day, _ := ParseDeliveryWindow("08:00-22:00", "Asia/Shanghai")
+	night, _ := ParseDeliveryWindow("22:00-06:30", "UTC")
+	cases := []struct {
+		window DeliveryWindow
+		at     time.Time
+		next   time.Time
+	}{
+		// 23:00 in Shanghai
+		{day, time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)},
+		// 07:00 in Shanghai
+		{day, time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{day, time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC)},
+		{night, time.Date(2024, 3, 1, 5, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 5, 0, 0, 0, time.UTC)},
+		{night, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC)},
+		{DeliveryWindow{}, time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		if next := c.window.Next(c.at); !next.Equal(c.next) {
+			t.Errorf("next of %+v at %s is %s want %s", c.window, c.at, next, c.next)
+		}
+	}
+
+	for _, w := range []string{"8-22", "25:00-06:00", "08:00-22:61"} {
+		if _, err := ParseDeliveryWindow(w, "UTC"); err == nil {
+			t.Errorf("window %q parsed", w)
+		}
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		if call.params.Get("send_time") != "" {
+			return map[string]interface{}{"msg_id": "m", "timer_id": "t1", "send_time": call.params.Get("send_time")}, 0
+		}
+		return pushOK(call)
+	})
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+	outbox, err := OpenOutbox(filepath.Join(t.TempDir(), "outbox.log"))
+	if err != nil {
+		t.Fatal("open outbox error", err)
+	}
+	defer outbox.Close()
+
+	// 23:00 in Shanghai, 15:00 in UTC
+	now := time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC)
+	q := &QuietHours{Outbox: outbox, now: func() time.Time { return now }}
+	shanghai, _ := ParseDeliveryWindow("08:00-22:00", "Asia/Shanghai")
+	london, _ := ParseDeliveryWindow("08:00-22:00", "Europe/London")
+	morning := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	d, err := q.Push(bc, PushRequest{Kind: PushTag, Tag: "cn", Msg: "hi"}, shanghai, PriorityNormal)
+	if err != nil || d.Decision != DeliverByTimer || d.Result.TimerID != "t1" || !d.At.Equal(morning) {
+		t.Errorf("delivery %+v error %v want timer at %s", d, err, morning)
+	}
+	if got := srv.received()[0].params.Get("send_time"); got != strconv.FormatInt(morning.Unix(), 10) {
+		t.Errorf("send time %s", got)
+	}
+
+	if d, _ = q.Push(bc, PushRequest{Kind: PushTag, Tag: "cn", Msg: "sale"}, shanghai, PriorityLow); d.Decision != DeliverDropped {
+		t.Errorf("low priority delivery %+v want dropped", d)
+	}
+	if d, _ = q.Push(bc, PushRequest{Kind: PushTag, Tag: "cn", Msg: "alert"}, shanghai, PriorityHigh); d.Decision != DeliverNow {
+		t.Errorf("high priority delivery %+v want sent", d)
+	}
+
+	devices := []WindowedDevice{
+		{ChannelID: "cn1", Window: shanghai},
+		{ChannelID: "uk1", Window: london},
+		{ChannelID: "cn2", Window: shanghai},
+	}
+	deliveries, err := q.PushDevices(bc, devices, "hi", nil, PriorityNormal)
+	if err != nil || len(deliveries) != 2 {
+		t.Fatalf("deliveries %+v error %v want 2", deliveries, err)
+	}
+	if d = deliveries[0]; d.Decision != DeliverNow || d.ChannelIDs[0] != "uk1" {
+		t.Errorf("delivery %+v want uk1 sent", d)
+	}
+	if d = deliveries[1]; d.Decision != DeliverByQueue || strings.Join(d.ChannelIDs, ",") != "cn1,cn2" || !d.At.Equal(morning) {
+		t.Errorf("delivery %+v want cn1 and cn2 queued", d)
+	}
+	entry, ok := outbox.Entry(deliveries[1].OutboxID)
+	if !ok || !entry.NextAttempt.Equal(morning) || entry.Request.Kind != PushBatch {
+		t.Errorf("outbox entry %+v want batch push at %s", entry, morning)
+	}
+	if calls := srv.received(); calls[len(calls)-1].params.Get("channel_id") != "uk1" {
+		t.Errorf("last push %v want uk1", calls[len(calls)-1].params)
+	}
+}
@@ -0,0 +1,68 @@
+package baidupush
+
+import (
+	"sync"
+	"time"
+)
+
+// WithRateLimit limits API calls made by channel to rate per second on
+// average with bursts of up to burst calls, calls over the limit wait for
+// their turn. Calls are not limited by default.
+func WithRateLimit(rate float64, burst int) ChannelOption {
+	return func(bc *Channel) {
+		bc.limiter = newRateLimiter(rate, burst)
+	}
+}
+
+// rateLimiter is a token bucket, a nil one never waits.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// wait takes a token, waiting until there is one.
+func (l *rateLimiter) wait() {
+	if l == nil || l.rate <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	// the token is taken now even if it is owed, so that callers waiting
+	// concurrently queue up one after another
+	l.tokens--
+	delay := time.Duration(0)
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		l.sleep(delay)
+	}
+}
@@ -0,0 +1,274 @@
+package baidupush
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// AppConfig configures the channel of an app on a platform, each one has its
+// own API key and secret.
+type AppConfig struct {
+	App      string   `json:"app"`
+	Platform Platform `json:"platform"`
+	Host     string   `json:"host,omitempty"`
+	APIKey   string   `json:"api_key"`
+	Secret   string   `json:"secret"`
+}
+
+func (cfg AppConfig) validate() error {
+	if cfg.App == "" {
+		return errors.New("app name is required")
+	}
+	if cfg.Platform.DeviceType() == 0 {
+		return fmt.Errorf("app %s: invalid platform %q - must be android or ios", cfg.App, cfg.Platform)
+	}
+	if cfg.APIKey == "" || cfg.Secret == "" {
+		return fmt.Errorf("app %s on %s: API key and secret are required", cfg.App, cfg.Platform)
+	}
+	return nil
+}
+
+type appKey struct {
+	app      string
+	platform Platform
+}
+
+// Registry builds and caches the channels of apps by app name and platform.
+// It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	configs  map[appKey]AppConfig
+	channels map[appKey]*Channel
+	opts     []ChannelOption
+}
+
+// NewRegistry returns a registry of apps configured by configs, channels are
+// built with opts when first used.
+func NewRegistry(configs []AppConfig, opts ...ChannelOption) (*Registry, error) {
+	r := &Registry{
+		configs:  map[appKey]AppConfig{},
+		channels: map[appKey]*Channel{},
+		opts:     opts,
+	}
+	for _, cfg := range configs {
+		key := appKey{cfg.App, cfg.Platform}
+		if _, ok := r.configs[key]; ok {
+			return nil, fmt.Errorf("app %s on %s configured twice", cfg.App, cfg.Platform)
+		}
+		if err := r.Set(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Set adds or replaces the config of an app on a platform, the channel built
+// from the former config is dropped.
+func (r *Registry) Set(cfg AppConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := appKey{cfg.App, cfg.Platform}
+	r.configs[key] = cfg
+	delete(r.channels, key)
+	return nil
+}
+
+// Channel returns the channel of app on platform.
+func (r *Registry) Channel(app string, platform Platform) (*Channel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := appKey{app, platform}
+	if bc, ok := r.channels[key]; ok {
+		return bc, nil
+	}
+	cfg, ok := r.configs[key]
+	if !ok {
+		return nil, fmt.Errorf("app %s on %s not found", app, platform)
+	}
+
+	host := cfg.Host
+	if host == "" {
+		host = DefaultBaiduPushService
+	}
+	bc := NewChannel(host, cfg.APIKey, cfg.Secret, platform.DeviceType(), r.opts...)
+	r.channels[key] = bc
+	return bc, nil
+}
+
+// Apps returns the names of apps in order.
+func (r *Registry) Apps() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := map[string]bool{}
+	apps := []string{}
+	for key := range r.configs {
+		if !seen[key.app] {
+			seen[key.app] = true
+			apps = append(apps, key.app)
+		}
+	}
+	sort.Strings(apps)
+	return apps
+}
+
+// Platforms returns the platforms of app in order.
+func (r *Registry) Platforms(app string) []Platform {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	platforms := []Platform{}
+	for key := range r.configs {
+		if key.app == app {
+			platforms = append(platforms, key.platform)
+		}
+	}
+	sort.Slice(platforms, func(i, j int) bool { return platforms[i] < platforms[j] })
+	return platforms
+}
+
+// CrossPush is a push of one notification to every platform of an app.
+type CrossPush struct {
+	// Kind is the kind of push on every platform.
+	Kind PushKind
+	// Tag is the tag pushed to by PushTag.
+	Tag string
+	// Devices are the channel IDs pushed to on each platform by PushSingle
+	// or PushBatch, platforms without devices are skipped.
+	Devices map[Platform][]string
+	// Message renders the msg of each platform.
+	Message MessageRenderer
+	// Opts are optional parameters of every platform, msg_type defaults to
+	// MsgTypeNotice.
+	Opts url.Values
+	// IdempotencyKey, if set, is suffixed by platform for each push, and by
+	// the index of the push if devices of a platform take several.
+	IdempotencyKey string
+}
+
+// CrossPushResult merges the results of a CrossPush on each platform.
+type CrossPushResult struct {
+	// Results are the results of the pushes succeeded on each platform, in
+	// the order devices were pushed to.
+	Results map[Platform][]PushResult
+	Errors  map[Platform]error
+}
+
+// PushNotification pushes one notification to every platform of app, the
+// error returned joins errors of all failed platforms. Devices of PushBatch
+// are pushed to in batches of MaxBatchDevices on Android, and one by one by
+// PushSingle on iOS, which has no batch push.
+func (r *Registry) PushNotification(app string, cp CrossPush) (CrossPushResult, error) {
+	merged := CrossPushResult{
+		Results: map[Platform][]PushResult{},
+		Errors:  map[Platform]error{},
+	}
+
+	platforms := r.Platforms(app)
+	if len(platforms) == 0 {
+		return merged, fmt.Errorf("app %s not found", app)
+	}
+
+	errs := []error{}
+	for _, platform := range platforms {
+		results, err := r.pushPlatform(app, platform, cp)
+		if err == errNoDevices {
+			continue
+		}
+		if len(results) > 0 {
+			merged.Results[platform] = results
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %w", platform, err)
+			merged.Errors[platform] = err
+			errs = append(errs, err)
+		}
+	}
+	return merged, errors.Join(errs...)
+}
+
+var errNoDevices = errors.New("no devices")
+
+// pushPlatform pushes cp to platform of app, returning the results of the
+// pushes succeeded and the errors of the others joined.
+func (r *Registry) pushPlatform(app string, platform Platform, cp CrossPush) ([]PushResult, error) {
+	req := PushRequest{Kind: cp.Kind, Tag: cp.Tag, Opts: url.Values{}}
+	for k, v := range cp.Opts {
+		req.Opts[k] = v
+	}
+	if req.Opts.Get("msg_type") == "" {
+		req.Opts.Set("msg_type", strconv.Itoa(MsgTypeNotice))
+	}
+
+	reqs := []PushRequest{req}
+	switch cp.Kind {
+	case PushSingle:
+		devices := cp.Devices[platform]
+		if len(devices) == 0 {
+			return nil, errNoDevices
+		}
+		if len(devices) > 1 {
+			return nil, fmt.Errorf("%d devices for a single device push", len(devices))
+		}
+		reqs[0].ChannelID = devices[0]
+	case PushBatch:
+		devices := cp.Devices[platform]
+		if len(devices) == 0 {
+			return nil, errNoDevices
+		}
+		reqs = reqs[:0]
+		if platform == IOS {
+			// batch_device is not supported on iOS
+			for _, device := range devices {
+				single := req
+				single.Kind = PushSingle
+				single.ChannelID = device
+				reqs = append(reqs, single)
+			}
+			break
+		}
+		for _, chunk := range chunkStrings(devices, MaxBatchDevices) {
+			batch := req
+			batch.ChannelIDs = chunk
+			reqs = append(reqs, batch)
+		}
+	}
+
+	msg, err := cp.Message.Render(platform)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := r.Channel(app, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []PushResult{}
+	errs := []error{}
+	for i := range reqs {
+		reqs[i].Msg = msg
+		if cp.IdempotencyKey != "" {
+			reqs[i].IdempotencyKey = fmt.Sprintf("%s/%s", cp.IdempotencyKey, platform)
+			if len(reqs) > 1 {
+				reqs[i].IdempotencyKey += fmt.Sprintf("/%d", i)
+			}
+		}
+		result, err := bc.Push(reqs[i])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, errors.Join(errs...)
+}
@@ -0,0 +1,84 @@
+package baidupush
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRegistryPushNotification(t *testing.T) {
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		if call.params.Get("apikey") == "shop-ios-key" && call.params.Get("tag") == "broken" {
+			return nil, 40004
+		}
+		return pushOK(call)
+	})
+	registry, err := NewRegistry([]AppConfig{
+		{App: "shop", Platform: Android, Host: srv.host(), APIKey: "shop-android-key", Secret: "s1"},
+		{App: "shop", Platform: IOS, Host: srv.host(), APIKey: "shop-ios-key", Secret: "s2"},
+		{App: "news", Platform: Android, Host: srv.host(), APIKey: "news-android-key", Secret: "s3"},
+	})
+	if err != nil {
+		t.Fatal("new registry error", err)
+	}
+
+	bc, _ := registry.Channel("shop", IOS)
+	if again, _ := registry.Channel("shop", IOS); again != bc {
+		t.Error("channel not cached")
+	}
+	if _, err = registry.Channel("news", IOS); err == nil {
+		t.Error("channel of news on iOS found")
+	}
+
+	n := Notification{Title: "Sale", Description: "50% off", Badge: 1, CustomContent: map[string]interface{}{"page": "sale"}}
+	merged, err := registry.PushNotification("shop", CrossPush{
+		Kind:    PushBatch,
+		Devices: map[Platform][]string{Android: {"a1", "a2"}, IOS: {"i1"}},
+		Message: n,
+	})
+	if err != nil {
+		t.Fatal("push notification error", err)
+	}
+	if len(merged.Results) != 2 || len(merged.Errors) != 0 {
+		t.Errorf("results %v errors %v want 2 results", merged.Results, merged.Errors)
+	}
+
+	calls := srv.received()
+	android, ios := calls[0].params, calls[1].params
+	if android.Get("apikey") != "shop-android-key" || android.Get("device_type") != "3" || android.Get("msg_type") != "1" {
+		t.Errorf("android push %v", android)
+	}
+	androidMsg := AndroidMessage{}
+	json.Unmarshal([]byte(android.Get("msg")), &androidMsg)
+	if androidMsg.Title != "Sale" || androidMsg.CustomContent["page"] != "sale" {
+		t.Errorf("android msg %s", android.Get("msg"))
+	}
+	if calls[1].apiMethod != "single_device" || ios.Get("apikey") != "shop-ios-key" || ios.Get("device_type") != "4" || ios.Get("channel_id") != "i1" {
+		t.Errorf("ios push %v", ios)
+	}
+	iosMsg := map[string]interface{}{}
+	json.Unmarshal([]byte(ios.Get("msg")), &iosMsg)
+	if aps := iosMsg["aps"].(map[string]interface{}); aps["alert"] != "50% off" || aps["badge"] != float64(1) || iosMsg["page"] != "sale" {
+		t.Errorf("ios msg %s", ios.Get("msg"))
+	}
+
+	merged, err = registry.PushNotification("shop", CrossPush{Kind: PushTag, Tag: "broken", Message: n})
+	if err == nil || ErrorCode(merged.Errors[IOS]) != 40004 {
+		t.Errorf("errors %v want 40004 on iOS", merged.Errors)
+	}
+	if len(merged.Results[Android]) != 1 || merged.Results[Android][0].MsgID == "" {
+		t.Error("android push not merged despite iOS failure")
+	}
+}
+
+func TestRegistryConfigErrors(t *testing.T) {
+	invalid := [][]AppConfig{
+		{{App: "shop", Platform: "windows", APIKey: "k", Secret: "s"}},
+		{{App: "shop", Platform: Android, APIKey: "k"}},
+		{{App: "shop", Platform: Android, APIKey: "k", Secret: "s"}, {App: "shop", Platform: Android, APIKey: "k2", Secret: "s2"}},
+	}
+	for _, configs := range invalid {
+		if _, err := NewRegistry(configs); err == nil {
+			t.Errorf("configs %v accepted", configs)
+		}
+	}
+}
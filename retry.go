@@ -0,0 +1,59 @@
+package baidupush
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy decides how many times and how long apart a failed request is
+// retried, the backoff doubles from MinBackoff up to MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+}
+
+// DefaultRetryPolicy tries 8 times with backoff from 1 second to 5 minutes.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 8,
+	MinBackoff:  time.Second,
+	MaxBackoff:  5 * time.Minute,
+}
+
+// Backoff returns the time to wait after the attempt-th attempt failed.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := p.MinBackoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+// WithRetryPolicy makes channel retry API calls failed temporarily by policy,
+// such as network failures or a busy service. Only queries are retried on any
+// temporary failure, calls changing the service such as pushes are retried
+// only if the connection could not be made, lest they were done twice. Calls
+// are not retried by default.
+func WithRetryPolicy(policy RetryPolicy) ChannelOption {
+	return func(bc *Channel) {
+		bc.retry = policy
+	}
+}
+
+// retryable reports whether a call by httpMethod failing with err could be
+// made again without being done twice.
+func retryable(httpMethod string, err error) bool {
+	if !isTemporary(err) {
+		return false
+	}
+	if httpMethod == http.MethodGet {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
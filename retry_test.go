@@ -0,0 +1,63 @@
+package baidupush
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryOnlySafeCalls(t *testing.T) {
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		return nil, 30606
+	})
+	policy := RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType, WithRetryPolicy(policy))
+
+	if _, err := bc.GetTagDevicesNumber("vip"); ErrorCode(err) != 30606 {
+		t.Fatalf("error %v want 30606", err)
+	}
+	if n := len(srv.received()); n != 3 {
+		t.Errorf("query attempts %d want 3", n)
+	}
+
+	if _, _, err := bc.PushMsgToSingleDevice("chn", "hello", nil); ErrorCode(err) != 30606 {
+		t.Fatalf("error %v want 30606", err)
+	}
+	if n := len(srv.received()); n != 4 {
+		t.Errorf("push attempts %d want 1", n-3)
+	}
+}
+
+func TestRetryDialFailure(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	srv.Close()
+	policy := RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	attempts := 0
+	creds := CredentialsFunc(func() (Credentials, error) {
+		attempts++
+		return Credentials{APIKey: "key", Secret: "secret"}, nil
+	})
+	bc := NewChannel(srv.host(), "", "", AndroidDeviceType, WithRetryPolicy(policy), WithCredentialsProvider(creds))
+
+	if _, _, err := bc.PushMsgToSingleDevice("chn", "hello", nil); err == nil {
+		t.Fatal("push to a closed server succeeded")
+	}
+	if attempts != 2 {
+		t.Errorf("attempts %d want 2", attempts)
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		return nil, 30606
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{MaxAttempts: 3, MinBackoff: time.Hour, MaxBackoff: time.Hour}
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType, WithRetryPolicy(policy), WithParentContext(ctx))
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := bc.GetTagDevicesNumber("vip"); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v want canceled", err)
+	}
+}
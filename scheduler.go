@@ -0,0 +1,300 @@
+package baidupush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultScheduleGrace is how late a run could be fired by default, see
+// Scheduler.SetGrace.
+const DefaultScheduleGrace = time.Minute
+
+// maxMissedCount bounds counting the runs missed by a schedule.
+const maxMissedCount = 100000
+
+// Schedule is a push request fired repeatedly by Scheduler.
+type Schedule struct {
+	ID string `json:"id"`
+	// Cron is the cron expression of the schedule, see ParseCron.
+	Cron string `json:"cron"`
+	// TimeZone is the IANA time zone the cron expression is in, such as
+	// "Asia/Shanghai", defaults to UTC.
+	TimeZone string      `json:"time_zone,omitempty"`
+	Request  PushRequest `json:"request"`
+	// LastRun is the time of the latest run fired.
+	LastRun time.Time `json:"last_run"`
+	// NextRun is the time of the next run.
+	NextRun time.Time `json:"next_run"`
+
+	cron *CronSchedule
+	loc  *time.Location
+}
+
+// ScheduleRun is a run of a schedule fired or missed.
+type ScheduleRun struct {
+	ScheduleID string
+	// Time is the time the run was due.
+	Time   time.Time
+	Result PushResult
+	Err    error
+	// Missed, if not zero, is the number of runs missed from Time on, none of
+	// them was pushed.
+	Missed int
+}
+
+// Scheduler fires push requests through a channel by cron expressions in IANA
+// time zones, schedules are persisted in a JSON file. Unlike timers of the
+// service made with send_time, schedules are recurring and could be of any
+// kind of push. It is safe for concurrent use.
+//
+// A run is pushed at least once: the file is updated after the run is pushed,
+// so a run might be pushed again if the process crashes in between. A run
+// without idempotency key is given "<schedule ID>@<time>", which prevents
+// pushing it twice only while the dedup store of channel remembers it, the
+// default store is in memory and forgets it on a crash.
+type Scheduler struct {
+	mu        sync.Mutex
+	path      string
+	channel   *Channel
+	schedules map[string]*Schedule
+	grace     time.Duration
+	now       func() time.Time
+}
+
+// OpenScheduler opens the schedules stored in file path, which is created
+// when a schedule is added if not existed, runs are pushed through bc.
+func OpenScheduler(path string, bc *Channel) (*Scheduler, error) {
+	s := &Scheduler{
+		path:      path,
+		channel:   bc,
+		schedules: map[string]*Schedule{},
+		grace:     DefaultScheduleGrace,
+		now:       time.Now,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	schedules := []*Schedule{}
+	if err = json.Unmarshal(data, &schedules); err != nil {
+		return nil, fmt.Errorf("schedules %s: %v", path, err)
+	}
+	for _, sched := range schedules {
+		if err = sched.parse(); err != nil {
+			return nil, fmt.Errorf("schedules %s: %v", path, err)
+		}
+		s.schedules[sched.ID] = sched
+	}
+	return s, nil
+}
+
+func (sched *Schedule) parse() error {
+	cron, err := ParseCron(sched.Cron)
+	if err != nil {
+		return fmt.Errorf("schedule %s: %v", sched.ID, err)
+	}
+	loc, err := time.LoadLocation(sched.TimeZone)
+	if err != nil {
+		return fmt.Errorf("schedule %s: %v", sched.ID, err)
+	}
+	sched.cron, sched.loc = cron, loc
+	return nil
+}
+
+// next returns the first run after t.
+func (sched *Schedule) next(t time.Time) time.Time {
+	return sched.cron.Next(t.In(sched.loc))
+}
+
+// SetGrace sets how late a run could be fired, a run later than that, such as
+// one due while the process was down, is reported missed and not pushed. The
+// grace must be positive, it is DefaultScheduleGrace unless set.
+func (s *Scheduler) SetGrace(grace time.Duration) error {
+	if grace <= 0 {
+		return fmt.Errorf("invalid grace %s", grace)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.grace = grace
+	return nil
+}
+
+// Add adds or replaces the schedule of id pushing req by cron expression
+// cronExpr in time zone tz, and returns it.
+func (s *Scheduler) Add(id, cronExpr, tz string, req PushRequest) (Schedule, error) {
+	if id == "" {
+		return Schedule{}, errors.New("schedule ID is required")
+	}
+	sched := &Schedule{ID: id, Cron: cronExpr, TimeZone: tz, Request: req}
+	if err := sched.parse(); err != nil {
+		return Schedule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sched.NextRun = sched.next(s.now())
+	if sched.NextRun.IsZero() {
+		return Schedule{}, fmt.Errorf("schedule %s: cron %q never runs", id, cronExpr)
+	}
+	former, ok := s.schedules[id]
+	s.schedules[id] = sched
+	if err := s.save(); err != nil {
+		if ok {
+			s.schedules[id] = former
+		} else {
+			delete(s.schedules, id)
+		}
+		return Schedule{}, err
+	}
+	return *sched, nil
+}
+
+// Remove removes the schedule of id, it is not an error if there is none.
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sched, ok := s.schedules[id]
+	if !ok {
+		return nil
+	}
+	delete(s.schedules, id)
+	if err := s.save(); err != nil {
+		s.schedules[id] = sched
+		return err
+	}
+	return nil
+}
+
+// Schedules returns all schedules ordered by ID.
+func (s *Scheduler) Schedules() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules := []Schedule{}
+	for _, id := range s.ids() {
+		schedules = append(schedules, *s.schedules[id])
+	}
+	return schedules
+}
+
+func (s *Scheduler) ids() []string {
+	ids := []string{}
+	for id := range s.schedules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// save writes all schedules to the file atomically.
+func (s *Scheduler) save() error {
+	schedules := []*Schedule{}
+	for _, id := range s.ids() {
+		schedules = append(schedules, s.schedules[id])
+	}
+	data, err := json.MarshalIndent(schedules, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// RunDue fires the runs due now once and returns them in the order of
+// schedule IDs, runs later than the grace of scheduler are reported missed
+// instead. The error returned is about persisting schedules.
+func (s *Scheduler) RunDue() ([]ScheduleRun, error) {
+	s.mu.Lock()
+	now, grace := s.now(), s.grace
+	due := []Schedule{}
+	for _, id := range s.ids() {
+		if sched := s.schedules[id]; !sched.NextRun.IsZero() && !sched.NextRun.After(now) {
+			due = append(due, *sched)
+		}
+	}
+	s.mu.Unlock()
+
+	runs := []ScheduleRun{}
+	for _, sched := range due {
+		run := ScheduleRun{ScheduleID: sched.ID, Time: sched.NextRun}
+		from := sched.NextRun
+		if now.Sub(sched.NextRun) > grace {
+			for t := sched.NextRun; !t.IsZero() && !t.After(now) && run.Missed < maxMissedCount; t = sched.next(t) {
+				run.Missed++
+			}
+			from = now
+		} else {
+			req := sched.Request
+			if req.IdempotencyKey == "" {
+				req.IdempotencyKey = fmt.Sprintf("%s@%s", sched.ID, sched.NextRun.UTC().Format(time.RFC3339))
+			}
+			run.Result, run.Err = s.channel.Push(req)
+			sched.LastRun = sched.NextRun
+		}
+		runs = append(runs, run)
+
+		if err := s.advance(sched, from); err != nil {
+			return runs, err
+		}
+	}
+	return runs, nil
+}
+
+// advance moves the schedule to its next run after from, unless it was
+// replaced or removed meanwhile. A run fired is advanced from its own time, so
+// that a run falling due while it was pushed is not skipped, and runs missed
+// are advanced from now.
+func (s *Scheduler) advance(sched Schedule, from time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.schedules[sched.ID]
+	if !ok || !current.NextRun.Equal(sched.NextRun) || current.Cron != sched.Cron {
+		return nil
+	}
+	current.LastRun = sched.LastRun
+	current.NextRun = sched.next(from)
+	return s.save()
+}
+
+// Run fires due runs every interval until ctx is done, report is called with
+// every run fired or missed if not nil. The interval must be positive and at
+// most the grace of scheduler, or runs due between two checks would be missed.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration, report func(ScheduleRun)) error {
+	s.mu.Lock()
+	grace := s.grace
+	s.mu.Unlock()
+	if interval <= 0 || interval > grace {
+		return fmt.Errorf("invalid interval %s, it must be in (0, %s]", interval, grace)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		runs, err := s.RunDue()
+		if report != nil {
+			for _, run := range runs {
+				report(run)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
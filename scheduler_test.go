@@ -0,0 +1,153 @@
+package baidupush
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	newYork, _ := time.LoadLocation("America/New_York")
+	cases := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 3, 1, 8, 7, 30, 0, time.UTC), time.Date(2024, 3, 1, 8, 15, 0, 0, time.UTC)},
+		{"0 9 * * MON-FRI", time.Date(2024, 3, 1, 9, 0, 0, 0, shanghai), time.Date(2024, 3, 4, 9, 0, 0, 0, shanghai)},
+		{"30 8 1,15 * *", time.Date(2024, 12, 20, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 8, 30, 0, 0, time.UTC)},
+		{"0 0 29 FEB *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 13 * 5", time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 9, 6, 12, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 7 * * 7", time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 10, 7, 0, 0, 0, time.UTC)},
+		// 2:30 does not exist on the day daylight saving time starts
+		{"30 2 * * *", time.Date(2024, 3, 9, 3, 0, 0, 0, newYork), time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)},
+		{"0 0 30 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("parse %q error %v", c.expr, err)
+			continue
+		}
+		if got := cron.Next(c.from); !got.Equal(c.want) {
+			t.Errorf("next of %q from %s is %s want %s", c.expr, c.from, got, c.want)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * FOO *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("cron %q parsed", expr)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+	path := filepath.Join(t.TempDir(), "schedules.json")
+
+	now := time.Date(2024, 3, 1, 0, 55, 0, 0, time.UTC)
+	s, err := OpenScheduler(path, bc)
+	if err != nil {
+		t.Fatal("open scheduler error", err)
+	}
+	s.now = func() time.Time { return now }
+
+	// 9:00 in Shanghai is 1:00 UTC
+	sched, err := s.Add("morning", "0 9 * * *", "Asia/Shanghai", PushRequest{Kind: PushTag, Tag: "vip", Msg: "good morning"})
+	if err != nil {
+		t.Fatal("add schedule error", err)
+	}
+	if !sched.NextRun.Equal(time.Date(2024, 3, 1, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("next run %s want 1:00 UTC", sched.NextRun)
+	}
+	if _, err = s.Add("bad", "0 9 * * *", "Mars/Olympus", PushRequest{Kind: PushAll}); err == nil {
+		t.Error("schedule in unknown time zone added")
+	}
+
+	runs, _ := s.RunDue()
+	if len(runs) != 0 {
+		t.Errorf("runs %v before due", runs)
+	}
+
+	now = now.Add(5*time.Minute + 10*time.Second)
+	runs, err = s.RunDue()
+	if err != nil || len(runs) != 1 || runs[0].Err != nil || runs[0].Result.MsgID == "" {
+		t.Fatalf("runs %+v error %v want one pushed", runs, err)
+	}
+	if call := srv.received()[0]; call.apiMethod != "tags" || call.params.Get("tag") != "vip" {
+		t.Errorf("push %s %v want tag vip", call.apiMethod, call.params)
+	}
+	if runs, _ = s.RunDue(); len(runs) != 0 {
+		t.Errorf("runs %v fired twice", runs)
+	}
+
+	// the process is down for 3 days
+	s, err = OpenScheduler(path, bc)
+	if err != nil {
+		t.Fatal("reopen scheduler error", err)
+	}
+	now = now.Add(72 * time.Hour)
+	s.now = func() time.Time { return now }
+	runs, _ = s.RunDue()
+	if len(runs) != 1 || runs[0].Missed != 3 || !runs[0].Time.Equal(time.Date(2024, 3, 2, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("runs %+v want 3 runs missed from Mar 2", runs)
+	}
+	if len(srv.received()) != 1 {
+		t.Error("missed runs pushed")
+	}
+	schedules := s.Schedules()
+	if len(schedules) != 1 || !schedules[0].NextRun.Equal(time.Date(2024, 3, 5, 1, 0, 0, 0, time.UTC)) || !schedules[0].LastRun.Equal(sched.NextRun) {
+		t.Errorf("schedules %+v want next run on Mar 5", schedules)
+	}
+
+	s.Remove("morning")
+	if s, _ = OpenScheduler(path, bc); len(s.Schedules()) != 0 {
+		t.Error("removed schedule persisted")
+	}
+
+	for _, interval := range []time.Duration{0, DefaultScheduleGrace + time.Second} {
+		if err = s.Run(context.Background(), interval, nil); err == nil {
+			t.Errorf("run every %s succeeded", interval)
+		}
+	}
+}
+
+func TestSchedulerGrace(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+	s, err := OpenScheduler(filepath.Join(t.TempDir(), "schedules.json"), bc)
+	if err != nil {
+		t.Fatal("open scheduler error", err)
+	}
+	now := time.Date(2024, 3, 1, 0, 59, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	if err = s.SetGrace(0); err == nil {
+		t.Error("zero grace set")
+	}
+	if err = s.SetGrace(10 * time.Minute); err != nil {
+		t.Fatal("set grace error", err)
+	}
+	s.Add("often", "*/5 * * * *", "", PushRequest{Kind: PushAll, Msg: "hello"})
+
+	// 1:00 is fired 7 minutes late, and 1:05 falls due while it is pushed
+	now = time.Date(2024, 3, 1, 1, 7, 0, 0, time.UTC)
+	for _, due := range []int{0, 5} {
+		runs, err := s.RunDue()
+		if err != nil || len(runs) != 1 || runs[0].Missed != 0 || !runs[0].Time.Equal(time.Date(2024, 3, 1, 1, due, 0, 0, time.UTC)) {
+			t.Fatalf("runs %+v error %v want 1:%02d pushed", runs, err, due)
+		}
+	}
+	if schedules := s.Schedules(); !schedules[0].NextRun.Equal(time.Date(2024, 3, 1, 1, 10, 0, 0, time.UTC)) {
+		t.Errorf("next run %s want 1:10", schedules[0].NextRun)
+	}
+	if n := len(srv.received()); n != 2 {
+		t.Errorf("pushes %d want 2", n)
+	}
+	if err = s.Run(context.Background(), 10*time.Minute+time.Second, nil); err == nil {
+		t.Error("run at an interval longer than grace succeeded")
+	}
+}
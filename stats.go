@@ -0,0 +1,330 @@
+package baidupush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// chinaTime is the time zone days of statistics of the service start in.
+var chinaTime = time.FixedZone("CST", 8*60*60)
+
+// statsDay returns the UNIX timestamp of the start of the day of t as the
+// service reports days.
+func statsDay(t time.Time) int64 {
+	y, m, d := t.In(chinaTime).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, chinaTime).Unix()
+}
+
+// TopicSnapshot is a topic listed by QueryTopicList on a day.
+type TopicSnapshot struct {
+	Day int64 `json:"day"`
+	TopicResult
+}
+
+// statsRecord is a line in the log of StatsStore, a device statistics, the
+// statistics of a topic on a day, or a snapshot of a topic listed.
+type statsRecord struct {
+	Device    *DeviceStatistics `json:"device,omitempty"`
+	Topic     string            `json:"topic,omitempty"`
+	TopicDay  *TopicStatistics  `json:"topic_day,omitempty"`
+	TopicList *TopicSnapshot    `json:"topic_list,omitempty"`
+}
+
+// StatsStore keeps the history of statistics the service reports only for
+// recent days, one record a day, the latest record of a day wins. Records are
+// kept in memory as well.
+//
+// The file is an append-only log of JSON lines, a record is appended only if
+// new or changed. It is safe for concurrent use.
+type StatsStore struct {
+	mu        sync.Mutex
+	log       *jsonLog
+	devices   map[int64]DeviceStatistics
+	topicDays map[string]map[int64]TopicStatistics
+	topicList map[string]map[int64]TopicSnapshot
+}
+
+// OpenStatsStore opens the store in file path, creating it if not existed.
+func OpenStatsStore(path string) (*StatsStore, error) {
+	s := &StatsStore{
+		devices:   map[int64]DeviceStatistics{},
+		topicDays: map[string]map[int64]TopicStatistics{},
+		topicList: map[string]map[int64]TopicSnapshot{},
+	}
+	var err error
+	s.log, err = openJSONLog(path, "stats store", s.apply)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// changed reports whether record is new or differs from the one kept.
+func (s *StatsStore) changed(record statsRecord) bool {
+	switch {
+	case record.Device != nil:
+		old, ok := s.devices[record.Device.Day]
+		return !ok || old != *record.Device
+	case record.TopicDay != nil:
+		old, ok := s.topicDays[record.Topic][record.TopicDay.Day]
+		return !ok || old != *record.TopicDay
+	case record.TopicList != nil:
+		old, ok := s.topicList[record.TopicList.Topic][record.TopicList.Day]
+		return !ok || old != *record.TopicList
+	}
+	return false
+}
+
+// apply keeps record in memory.
+func (s *StatsStore) apply(record statsRecord) {
+	switch {
+	case record.Device != nil:
+		s.devices[record.Device.Day] = *record.Device
+	case record.TopicDay != nil:
+		days := s.topicDays[record.Topic]
+		if days == nil {
+			days = map[int64]TopicStatistics{}
+			s.topicDays[record.Topic] = days
+		}
+		days[record.TopicDay.Day] = *record.TopicDay
+	case record.TopicList != nil:
+		days := s.topicList[record.TopicList.Topic]
+		if days == nil {
+			days = map[int64]TopicSnapshot{}
+			s.topicList[record.TopicList.Topic] = days
+		}
+		days[record.TopicList.Day] = *record.TopicList
+	}
+}
+
+// add appends the records new or changed to the log and syncs it to disk
+// before keeping them in memory, returning the number of records appended.
+func (s *StatsStore) add(records []statsRecord) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added := []statsRecord{}
+	values := []interface{}{}
+	for _, record := range records {
+		if s.changed(record) {
+			added = append(added, record)
+			values = append(values, record)
+		}
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	if err := s.log.append(values...); err != nil {
+		return 0, err
+	}
+	for _, record := range added {
+		s.apply(record)
+	}
+	return len(added), nil
+}
+
+// AddDevices records stats returned by ReportDeviceStatistics, returning the
+// number of days new or changed.
+func (s *StatsStore) AddDevices(stats []DeviceStatistics) (int, error) {
+	records := make([]statsRecord, len(stats))
+	for i := range stats {
+		records[i] = statsRecord{Device: &stats[i]}
+	}
+	return s.add(records)
+}
+
+// AddTopicDays records stats of topic returned by ReportTopicStatistics,
+// returning the number of days new or changed.
+func (s *StatsStore) AddTopicDays(topic string, stats []TopicStatistics) (int, error) {
+	records := make([]statsRecord, len(stats))
+	for i := range stats {
+		records[i] = statsRecord{Topic: topic, TopicDay: &stats[i]}
+	}
+	return s.add(records)
+}
+
+// AddTopicList records topics returned by QueryTopicList on the day of t,
+// returning the number of topics new or changed of the day.
+func (s *StatsStore) AddTopicList(t time.Time, topics []TopicResult) (int, error) {
+	day := statsDay(t)
+	records := make([]statsRecord, len(topics))
+	for i, topic := range topics {
+		records[i] = statsRecord{TopicList: &TopicSnapshot{Day: day, TopicResult: topic}}
+	}
+	return s.add(records)
+}
+
+// inRange reports whether day is in [from, to), a zero time being unbounded.
+func inRange(day int64, from, to time.Time) bool {
+	return (from.IsZero() || day >= from.Unix()) && (to.IsZero() || day < to.Unix())
+}
+
+// DeviceStatistics returns the device statistics of days in [from, to),
+// ordered by day, a zero time being unbounded.
+func (s *StatsStore) DeviceStatistics(from, to time.Time) []DeviceStatistics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := []DeviceStatistics{}
+	for day, stat := range s.devices {
+		if inRange(day, from, to) {
+			stats = append(stats, stat)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Day < stats[j].Day })
+	return stats
+}
+
+// TopicStatistics returns the statistics of topic of days in [from, to),
+// ordered by day, a zero time being unbounded.
+func (s *StatsStore) TopicStatistics(topic string, from, to time.Time) []TopicStatistics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := []TopicStatistics{}
+	for day, stat := range s.topicDays[topic] {
+		if inRange(day, from, to) {
+			stats = append(stats, stat)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Day < stats[j].Day })
+	return stats
+}
+
+// TopicSnapshots returns the snapshots of topic listed on days in [from, to),
+// ordered by day, a zero time being unbounded.
+func (s *StatsStore) TopicSnapshots(topic string, from, to time.Time) []TopicSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshots := []TopicSnapshot{}
+	for day, snapshot := range s.topicList[topic] {
+		if inRange(day, from, to) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Day < snapshots[j].Day })
+	return snapshots
+}
+
+// Topics returns all topics recorded, sorted.
+func (s *StatsStore) Topics() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := map[string]struct{}{}
+	for topic := range s.topicDays {
+		set[topic] = struct{}{}
+	}
+	for topic := range s.topicList {
+		set[topic] = struct{}{}
+	}
+	return sortedKeys(set)
+}
+
+// Close closes the file of store.
+func (s *StatsStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.log.close()
+}
+
+// StatsCollection counts the records new or changed by a collection.
+type StatsCollection struct {
+	Time      time.Time
+	Devices   int
+	TopicDays int
+	TopicList int
+}
+
+// StatsCollector collects statistics of a channel into a StatsStore, so that
+// they are kept longer than the service reports them.
+type StatsCollector struct {
+	store   *StatsStore
+	channel *Channel
+	topics  []string
+	now     func() time.Time
+}
+
+// NewStatsCollector returns a collector of statistics of bc into store,
+// collecting ReportTopicStatistics of topics and of the topics listed by
+// QueryTopicList.
+func NewStatsCollector(bc *Channel, store *StatsStore, topics ...string) *StatsCollector {
+	return &StatsCollector{
+		store:   store,
+		channel: bc,
+		topics:  topics,
+		now:     time.Now,
+	}
+}
+
+// Collect collects ReportDeviceStatistics, all pages of QueryTopicList and
+// ReportTopicStatistics of known topics once. A topic failed does not stop
+// the others, the error returned joins errors of all failures.
+func (c *StatsCollector) Collect() (StatsCollection, error) {
+	collection := StatsCollection{Time: c.now()}
+	errs := []error{}
+
+	_, devices, err := c.channel.ReportDeviceStatistics()
+	if err == nil {
+		collection.Devices, err = c.store.AddDevices(devices)
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("device statistics: %w", err))
+	}
+
+	topics := map[string]struct{}{}
+	for _, topic := range c.topics {
+		topics[topic] = struct{}{}
+	}
+	listed := []TopicResult{}
+	err = walkPages(c.channel.QueryTopicList, func(topic TopicResult) error {
+		listed = append(listed, topic)
+		return nil
+	})
+	if err == nil {
+		collection.TopicList, err = c.store.AddTopicList(collection.Time, listed)
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("topic list: %w", err))
+	}
+	for _, topic := range listed {
+		topics[topic.Topic] = struct{}{}
+	}
+
+	for _, topic := range sortedKeys(topics) {
+		_, days, err := c.channel.ReportTopicStatistics(topic)
+		if err == nil {
+			var n int
+			n, err = c.store.AddTopicDays(topic, days)
+			collection.TopicDays += n
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("topic %s statistics: %w", topic, err))
+		}
+	}
+	return collection, errors.Join(errs...)
+}
+
+// Run collects every interval until ctx is done, reporting every collection
+// to report if not nil. Failures of collections do not stop it, interval must
+// be positive.
+func (c *StatsCollector) Run(ctx context.Context, interval time.Duration, report func(StatsCollection, error)) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid interval %s", interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		collection, err := c.Collect()
+		if report != nil {
+			report(collection, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package baidupush
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStatsCollector(t *testing.T) {
+	var newUsers atomic.Int64
+	newUsers.Store(5)
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		switch call.apiClass + "/" + call.apiMethod {
+		case "report/statistic_device":
+			return map[string]interface{}{"total_num": 2, "result": map[string]interface{}{
+				"1709222400": map[string]interface{}{"new_term": 10, "del_term": 1, "total_term": 100},
+				"1709308800": map[string]interface{}{"new_term": newUsers.Load(), "del_term": 0, "total_term": 105},
+			}}, 0
+		case "topic/query_list":
+			return map[string]interface{}{"total_num": 1, "result": []interface{}{
+				map[string]interface{}{"topic_id": "listed", "push_cnt": 3, "ack_cnt": 1, "ctime": 1709222400, "mtime": 1709308800},
+			}}, 0
+		case "report/statistic_topic":
+			if call.params.Get("topic_id") == "broken" {
+				return nil, 30602
+			}
+			return map[string]interface{}{"total_num": 1, "result": map[string]interface{}{
+				"1709308800": map[string]interface{}{"ack": 7},
+			}}, 0
+		}
+		return nil, 30602
+	})
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+
+	path := filepath.Join(t.TempDir(), "stats.log")
+	store, err := OpenStatsStore(path)
+	if err != nil {
+		t.Fatal("open stats store error", err)
+	}
+	c := NewStatsCollector(bc, store, "spring", "broken")
+	c.now = func() time.Time { return time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC) }
+
+	collection, err := c.Collect()
+	if err == nil {
+		t.Error("broken topic no error")
+	}
+	if collection.Devices != 2 || collection.TopicList != 1 || collection.TopicDays != 2 {
+		t.Errorf("first collection %+v", collection)
+	}
+
+	// only the day changed is appended
+	newUsers.Store(8)
+	if collection, _ = c.Collect(); collection.Devices != 1 || collection.TopicList != 0 || collection.TopicDays != 0 {
+		t.Errorf("second collection %+v", collection)
+	}
+	store.Close()
+
+	store, err = OpenStatsStore(path)
+	if err != nil {
+		t.Fatal("reopen stats store error", err)
+	}
+	defer store.Close()
+	devices := store.DeviceStatistics(time.Time{}, time.Time{})
+	if len(devices) != 2 || devices[0].Day != 1709222400 || devices[1].DailyNewUser != 8 {
+		t.Errorf("devices %+v", devices)
+	}
+	if recent := store.DeviceStatistics(time.Unix(1709308800, 0), time.Time{}); len(recent) != 1 {
+		t.Errorf("recent devices %+v", recent)
+	}
+	if topics := store.Topics(); !reflect.DeepEqual(topics, []string{"listed", "spring"}) {
+		t.Errorf("topics %v", topics)
+	}
+	if days := store.TopicStatistics("spring", time.Time{}, time.Time{}); len(days) != 1 || days[0].Ack != 7 {
+		t.Errorf("spring days %+v", days)
+	}
+	// 20:00 UTC is the next day in China
+	snapshots := store.TopicSnapshots("listed", time.Time{}, time.Time{})
+	if len(snapshots) != 1 || snapshots[0].Day != 1709308800 || snapshots[0].PushCount != 3 {
+		t.Errorf("snapshots %+v", snapshots)
+	}
+}
+
+func TestStatsStoreFailedWrite(t *testing.T) {
+	store, err := OpenStatsStore(filepath.Join(t.TempDir(), "stats.log"))
+	if err != nil {
+		t.Fatal("open stats store error", err)
+	}
+	store.log.close()
+
+	if _, err = store.AddDevices([]DeviceStatistics{{Day: 1709222400, DailyNewUser: 10}}); err == nil {
+		t.Fatal("add devices to a closed log succeeded")
+	}
+	if devices := store.DeviceStatistics(time.Time{}, time.Time{}); len(devices) != 0 {
+		t.Errorf("devices %+v kept without being written", devices)
+	}
+
+	c := NewStatsCollector(NewChannel("localhost", "key", "secret", AndroidDeviceType), store)
+	if err = c.Run(context.Background(), 0, nil); err == nil {
+		t.Error("run with zero interval succeeded")
+	}
+}
@@ -0,0 +1,235 @@
+package baidupush
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"text/template"
+)
+
+// MaxBatchDevices is the most channel IDs pushed to by one call of
+// PushMsgToBatchDevices, larger batches are split into several calls.
+const MaxBatchDevices = 10000
+
+// MessageTemplate is a Notification whose Title, Description, URL and string
+// values in CustomContent are text/template templates, executed with the
+// data of each recipient. Templates are parsed when the MessageTemplate is
+// made and fail on missing map keys when executed. It is safe for concurrent
+// use.
+type MessageTemplate struct {
+	base        Notification
+	title       *template.Template
+	description *template.Template
+	url         *template.Template
+	custom      map[string]interface{}
+}
+
+// NewMessageTemplate parses the templates in n, name is used in errors.
+func NewMessageTemplate(name string, n Notification) (*MessageTemplate, error) {
+	mt := &MessageTemplate{base: n}
+	var err error
+	if mt.title, err = parseTemplate(name+".title", n.Title); err != nil {
+		return nil, err
+	}
+	if mt.description, err = parseTemplate(name+".description", n.Description); err != nil {
+		return nil, err
+	}
+	if mt.url, err = parseTemplate(name+".url", n.URL); err != nil {
+		return nil, err
+	}
+
+	custom, err := parseCustomContent(name+".custom_content", n.CustomContent)
+	if err != nil {
+		return nil, err
+	}
+	if custom != nil {
+		mt.custom = custom.(map[string]interface{})
+	}
+	return mt, nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template %w", err)
+	}
+	return tmpl, nil
+}
+
+// parseCustomContent parses the strings in val, which is a map, a slice or a
+// plain value decoded from JSON.
+func parseCustomContent(name string, val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case string:
+		return parseTemplate(name, v)
+	case map[string]interface{}:
+		if v == nil {
+			return nil, nil
+		}
+		parsed := map[string]interface{}{}
+		for k, elem := range v {
+			p, err := parseCustomContent(name+"."+k, elem)
+			if err != nil {
+				return nil, err
+			}
+			parsed[k] = p
+		}
+		return parsed, nil
+	case []interface{}:
+		parsed := make([]interface{}, len(v))
+		for i, elem := range v {
+			p, err := parseCustomContent(name+"."+strconv.Itoa(i), elem)
+			if err != nil {
+				return nil, err
+			}
+			parsed[i] = p
+		}
+		return parsed, nil
+	}
+	return val, nil
+}
+
+func executeCustomContent(val, data interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case *template.Template:
+		return executeTemplate(v, data)
+	case map[string]interface{}:
+		executed := map[string]interface{}{}
+		for k, elem := range v {
+			e, err := executeCustomContent(elem, data)
+			if err != nil {
+				return nil, err
+			}
+			executed[k] = e
+		}
+		return executed, nil
+	case []interface{}:
+		executed := make([]interface{}, len(v))
+		for i, elem := range v {
+			e, err := executeCustomContent(elem, data)
+			if err != nil {
+				return nil, err
+			}
+			executed[i] = e
+		}
+		return executed, nil
+	}
+	return val, nil
+}
+
+func executeTemplate(tmpl *template.Template, data interface{}) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Render returns the notification of a recipient with data.
+func (mt *MessageTemplate) Render(data interface{}) (Notification, error) {
+	n := mt.base
+	var err error
+	if n.Title, err = executeTemplate(mt.title, data); err != nil {
+		return n, err
+	}
+	if n.Description, err = executeTemplate(mt.description, data); err != nil {
+		return n, err
+	}
+	if n.URL, err = executeTemplate(mt.url, data); err != nil {
+		return n, err
+	}
+	if mt.custom != nil {
+		custom, err := executeCustomContent(mt.custom, data)
+		if err != nil {
+			return n, err
+		}
+		n.CustomContent = custom.(map[string]interface{})
+	}
+	return n, nil
+}
+
+// Recipient is a device pushed to with its own template data.
+type Recipient struct {
+	ChannelID string
+	Data      interface{}
+}
+
+// TemplateBatch is a call of PushMsgToBatchDevices pushing the same rendered
+// msg to devices.
+type TemplateBatch struct {
+	Msg        string
+	ChannelIDs []string
+	MsgID      string
+	SendTime   int64
+	Err        error
+}
+
+// PushTemplate renders mt for each recipient on the platform of channel, and
+// pushes each group of identical msgs to its devices by PushMsgToBatchDevices,
+// split into batches of MaxBatchDevices. Nothing is pushed if any rendering
+// fails. opts are optional parameters of every batch, msg_type defaults to
+// MsgTypeNotice.
+//
+// Batches are returned in the order their msgs were first rendered, the
+// error returned joins errors of all failed batches.
+func (bc *Channel) PushTemplate(mt *MessageTemplate, recipients []Recipient, opts url.Values) ([]TemplateBatch, error) {
+	platform := devicePlatform(bc.deviceType)
+	groups := map[string][]string{}
+	msgs := []string{}
+	for _, r := range recipients {
+		n, err := mt.Render(r.Data)
+		if err != nil {
+			return nil, fmt.Errorf("render for %s: %w", r.ChannelID, err)
+		}
+		msg, err := n.Render(platform)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := groups[msg]; !ok {
+			msgs = append(msgs, msg)
+		}
+		groups[msg] = append(groups[msg], r.ChannelID)
+	}
+
+	return bc.pushBatches(msgs, groups, opts)
+}
+
+// pushBatches pushes each msg in msgs to its devices in groups.
+func (bc *Channel) pushBatches(msgs []string, groups map[string][]string, opts url.Values) ([]TemplateBatch, error) {
+	batchOpts := url.Values{}
+	for k, v := range opts {
+		batchOpts[k] = v
+	}
+	if batchOpts.Get("msg_type") == "" {
+		batchOpts.Set("msg_type", strconv.Itoa(MsgTypeNotice))
+	}
+
+	batches := []TemplateBatch{}
+	errs := []error{}
+	for _, msg := range msgs {
+		for _, chunk := range chunkStrings(groups[msg], MaxBatchDevices) {
+			batch := TemplateBatch{Msg: msg, ChannelIDs: chunk}
+			batch.MsgID, batch.SendTime, batch.Err = bc.PushMsgToBatchDevices(chunk, msg, batchOpts)
+			if batch.Err != nil {
+				errs = append(errs, batch.Err)
+			}
+			batches = append(batches, batch)
+		}
+	}
+	return batches, errors.Join(errs...)
+}
+
+// chunkStrings splits s into chunks of at most size.
+func chunkStrings(s []string, size int) [][]string {
+	chunks := [][]string{}
+	for len(s) > size {
+		chunks = append(chunks, s[:size])
+		s = s[size:]
+	}
+	if len(s) > 0 {
+		chunks = append(chunks, s)
+	}
+	return chunks
+}
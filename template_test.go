@@ -0,0 +1,68 @@
+package baidupush
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewMessageTemplateErrors(t *testing.T) {
+	invalid := []Notification{
+		{Title: "Hi {{.Name"},
+		{Description: "{{if .Count}}no end"},
+		{Title: "ok", CustomContent: map[string]interface{}{"links": []interface{}{"{{.Broken"}}},
+	}
+	for _, n := range invalid {
+		if _, err := NewMessageTemplate("welcome", n); err == nil {
+			t.Errorf("template %+v parsed", n)
+		}
+	}
+}
+
+func TestPushTemplate(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType)
+	mt, err := NewMessageTemplate("cart", Notification{
+		Title:         "Hi {{.Name}}",
+		Description:   "{{.Count}} items in your cart",
+		URL:           "https://shop.example.com/cart/{{.Cart}}",
+		CustomContent: map[string]interface{}{"page": "cart", "count": "{{.Count}}", "nested": map[string]interface{}{"id": "{{.Cart}}"}, "badge": 1.0},
+	})
+	if err != nil {
+		t.Fatal("new template error", err)
+	}
+
+	recipients := []Recipient{
+		{ChannelID: "c1", Data: map[string]interface{}{"Name": "Li", "Count": 2, "Cart": "x"}},
+		{ChannelID: "c2", Data: map[string]interface{}{"Name": "Wang", "Count": 1, "Cart": "y"}},
+		{ChannelID: "c3", Data: map[string]interface{}{"Name": "Li", "Count": 2, "Cart": "x"}},
+	}
+	batches, err := bc.PushTemplate(mt, recipients, nil)
+	if err != nil {
+		t.Fatal("push template error", err)
+	}
+	if len(batches) != 2 || strings.Join(batches[0].ChannelIDs, ",") != "c1,c3" || batches[0].MsgID == "" {
+		t.Fatalf("batches %+v want c1,c3 and c2", batches)
+	}
+
+	calls := srv.received()
+	if len(calls) != 2 || calls[0].apiMethod != "batch_device" || calls[0].params.Get("msg_type") != "1" {
+		t.Fatalf("calls %v want 2 batch pushes of notices", calls)
+	}
+	msg := AndroidMessage{}
+	json.Unmarshal([]byte(calls[0].params.Get("msg")), &msg)
+	if msg.Title != "Hi Li" || msg.Description != "2 items in your cart" || msg.URL != "https://shop.example.com/cart/x" {
+		t.Errorf("msg %s", calls[0].params.Get("msg"))
+	}
+	if msg.CustomContent["count"] != "2" || msg.CustomContent["badge"] != 1.0 || msg.CustomContent["nested"].(map[string]interface{})["id"] != "x" {
+		t.Errorf("custom content %v", msg.CustomContent)
+	}
+
+	recipients = append(recipients, Recipient{ChannelID: "c4", Data: map[string]interface{}{"Name": "Zhao"}})
+	if _, err = bc.PushTemplate(mt, recipients, nil); err == nil || !strings.Contains(err.Error(), "c4") {
+		t.Errorf("error %v want missing data of c4", err)
+	}
+	if len(srv.received()) != 2 {
+		t.Error("pushed despite a failed rendering")
+	}
+}
@@ -0,0 +1,302 @@
+package baidupush
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TimerStatus is the status of a timer task recorded by TimerManager.
+type TimerStatus string
+
+const (
+	// TimerScheduled means the timer task is waiting on the service.
+	TimerScheduled TimerStatus = "scheduled"
+	// TimerExecuting means the timer task is being executed and could not be
+	// canceled any more (41004).
+	TimerExecuting TimerStatus = "executing"
+	// TimerExecuted means the message of the timer task was pushed.
+	TimerExecuted TimerStatus = "executed"
+	// TimerCanceled means the timer task was canceled.
+	TimerCanceled TimerStatus = "canceled"
+)
+
+const (
+	// MinTimerDelay is how soon a timer task could be sent at least.
+	MinTimerDelay = time.Minute
+	// MaxTimerDelay is how late a timer task could be sent at most.
+	MaxTimerDelay = 365 * 24 * time.Hour
+)
+
+// TimerRecord is a timer task created by TimerManager with a label of ours,
+// such as the name of a campaign.
+type TimerRecord struct {
+	TimerID  string      `json:"timer_id"`
+	Label    string      `json:"label"`
+	Kind     PushKind    `json:"kind"`
+	Tag      string      `json:"tag,omitempty"`
+	MsgID    string      `json:"msg_id"`
+	SendTime time.Time   `json:"send_time"`
+	Created  time.Time   `json:"created"`
+	Status   TimerStatus `json:"status"`
+}
+
+// TimerManager creates timer tasks by pushes to all or tagged devices with
+// send_time, and records them with labels in a file, so that they could be
+// listed and canceled by label or send time, and reconciled with the timer
+// tasks on the service.
+//
+// The file is an append-only log of JSON lines, each one a snapshot of a
+// record, the latest snapshot of a record wins. It is safe for concurrent use.
+type TimerManager struct {
+	mu      sync.Mutex
+	log     *jsonLog
+	channel *Channel
+	records map[string]*TimerRecord
+	order   []string
+	now     func() time.Time
+}
+
+// OpenTimerManager opens the records stored in file path, creating it if not
+// existed, timer tasks are managed through bc.
+func OpenTimerManager(path string, bc *Channel) (*TimerManager, error) {
+	m := &TimerManager{
+		channel: bc,
+		records: map[string]*TimerRecord{},
+		now:     time.Now,
+	}
+	var err error
+	m.log, err = openJSONLog(path, "timer records", m.apply)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// apply keeps the snapshot record in memory.
+func (m *TimerManager) apply(record TimerRecord) {
+	if _, ok := m.records[record.TimerID]; !ok {
+		m.order = append(m.order, record.TimerID)
+	}
+	m.records[record.TimerID] = &record
+}
+
+// save appends the snapshot of record to the log, syncs it to disk and keeps
+// it in memory, m.mu must be held.
+func (m *TimerManager) save(record TimerRecord) error {
+	if err := m.log.append(record); err != nil {
+		return err
+	}
+	m.apply(record)
+	return nil
+}
+
+// PushAll creates a timer task pushing msg to all devices at sendTime.
+func (m *TimerManager) PushAll(label, msg string, sendTime time.Time, opts url.Values) (TimerRecord, error) {
+	return m.push(label, PushRequest{Kind: PushAll, Msg: msg, Opts: opts}, sendTime)
+}
+
+// PushTag creates a timer task pushing msg to devices tagged by tag at
+// sendTime.
+func (m *TimerManager) PushTag(label, tag, msg string, sendTime time.Time, opts url.Values) (TimerRecord, error) {
+	return m.push(label, PushRequest{Kind: PushTag, Tag: tag, Msg: msg, Opts: opts}, sendTime)
+}
+
+func (m *TimerManager) push(label string, req PushRequest, sendTime time.Time) (TimerRecord, error) {
+	now := m.now()
+	if delay := sendTime.Sub(now); delay < MinTimerDelay || delay > MaxTimerDelay {
+		return TimerRecord{}, fmt.Errorf("send time %s must be %s to %s from now", sendTime.Format(time.RFC3339), MinTimerDelay, MaxTimerDelay)
+	}
+
+	opts := url.Values{}
+	for k, v := range req.Opts {
+		opts[k] = v
+	}
+	opts.Set("send_time", strconv.FormatInt(sendTime.Unix(), 10))
+	req.Opts = opts
+
+	result, err := m.channel.Push(req)
+	if err != nil {
+		return TimerRecord{}, err
+	}
+	if result.TimerID == "" {
+		return TimerRecord{}, fmt.Errorf("no timer ID returned for message %s", result.MsgID)
+	}
+
+	record := TimerRecord{
+		TimerID:  result.TimerID,
+		Label:    label,
+		Kind:     req.Kind,
+		Tag:      req.Tag,
+		MsgID:    result.MsgID,
+		SendTime: time.Unix(sendTime.Unix(), 0),
+		Created:  now,
+		Status:   TimerScheduled,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return record, m.save(record)
+}
+
+// Timers returns records labeled label, or all records if label is empty, in
+// the order they were created.
+func (m *TimerManager) Timers(label string) []TimerRecord {
+	return m.filter(func(r *TimerRecord) bool { return label == "" || r.Label == label })
+}
+
+func (m *TimerManager) filter(match func(r *TimerRecord) bool) []TimerRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := []TimerRecord{}
+	for _, id := range m.order {
+		if record := m.records[id]; match(record) {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+// Cancel cancels the timer task of timerID and returns its record. A task
+// being executed (41004) or executed already (41005) is not an error, its
+// record has the status found instead of TimerCanceled.
+func (m *TimerManager) Cancel(timerID string) (TimerRecord, error) {
+	m.mu.Lock()
+	record, ok := m.records[timerID]
+	m.mu.Unlock()
+	if !ok {
+		return TimerRecord{}, fmt.Errorf("timer %s not found", timerID)
+	}
+	if record.Status != TimerScheduled {
+		return *record, nil
+	}
+
+	updated := *record
+	err := m.channel.CancelTimerTask(timerID)
+	switch ErrorCode(err) {
+	case 0:
+		if err != nil {
+			return updated, err
+		}
+		updated.Status = TimerCanceled
+	case 41004:
+		updated.Status = TimerExecuting
+	case 41005:
+		updated.Status = TimerExecuted
+	case 41001:
+		updated.Status = m.vanished(updated)
+	default:
+		return updated, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return updated, m.save(updated)
+}
+
+// vanished returns the status of a timer task not found on the service.
+func (m *TimerManager) vanished(record TimerRecord) TimerStatus {
+	if record.SendTime.After(m.now()) {
+		return TimerCanceled
+	}
+	return TimerExecuted
+}
+
+// CancelLabel cancels the scheduled timer tasks labeled label, see Cancel.
+// Records are returned with their status, the error returned joins failures.
+func (m *TimerManager) CancelLabel(label string) ([]TimerRecord, error) {
+	return m.cancelAll(m.filter(func(r *TimerRecord) bool {
+		return r.Label == label && r.Status == TimerScheduled
+	}))
+}
+
+// CancelBetween cancels the scheduled timer tasks to be sent in [from, to),
+// see CancelLabel.
+func (m *TimerManager) CancelBetween(from, to time.Time) ([]TimerRecord, error) {
+	return m.cancelAll(m.filter(func(r *TimerRecord) bool {
+		return r.Status == TimerScheduled && !r.SendTime.Before(from) && r.SendTime.Before(to)
+	}))
+}
+
+func (m *TimerManager) cancelAll(records []TimerRecord) ([]TimerRecord, error) {
+	canceled := []TimerRecord{}
+	errs := []error{}
+	for _, record := range records {
+		updated, err := m.Cancel(record.TimerID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("timer %s: %w", record.TimerID, err))
+		}
+		canceled = append(canceled, updated)
+	}
+	return canceled, errors.Join(errs...)
+}
+
+// TimerReconciliation is what reconciling records with the service found.
+type TimerReconciliation struct {
+	// Updated are the records found executed or canceled on the service.
+	Updated []TimerRecord
+	// Unknown are the timer tasks on the service not created by the manager.
+	Unknown []TimerResult
+}
+
+// Reconcile pages through the timer tasks on the service by QueryTimerTasks,
+// and updates the scheduled or executing records not found there: executed if
+// their send time has come, or canceled otherwise.
+func (m *TimerManager) Reconcile() (TimerReconciliation, error) {
+	rec := TimerReconciliation{}
+	onService := map[string]TimerResult{}
+	for start := 0; ; {
+		opts := url.Values{}
+		opts.Set("start", strconv.Itoa(start))
+		opts.Set("limit", "100")
+		total, tasks, err := m.channel.QueryTimerTasks(opts)
+		if err != nil {
+			return rec, err
+		}
+		for _, task := range tasks {
+			onService[task.ID] = task
+		}
+		start += len(tasks)
+		if len(tasks) == 0 || start >= total {
+			break
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range m.order {
+		record := m.records[id]
+		if _, ok := onService[id]; ok {
+			delete(onService, id)
+			continue
+		}
+		if record.Status != TimerScheduled && record.Status != TimerExecuting {
+			continue
+		}
+		updated := *record
+		updated.Status = m.vanished(updated)
+		if err := m.save(updated); err != nil {
+			return rec, err
+		}
+		rec.Updated = append(rec.Updated, updated)
+	}
+
+	for _, task := range onService {
+		rec.Unknown = append(rec.Unknown, task)
+	}
+	sort.Slice(rec.Unknown, func(i, j int) bool { return rec.Unknown[i].ID < rec.Unknown[j].ID })
+	return rec, nil
+}
+
+// Close closes the file of manager.
+func (m *TimerManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.log.close()
+}
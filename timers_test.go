@@ -0,0 +1,115 @@
+package baidupush
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimerManager(t *testing.T) {
+	mu := sync.Mutex{}
+	timers := 0
+	onService := []string{"foreign"}
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch call.apiClass + "/" + call.apiMethod {
+		case "push/all", "push/tags":
+			timers++
+			id := fmt.Sprintf("timer-%d", timers)
+			onService = append(onService, id)
+			return map[string]interface{}{"msg_id": "msg-" + id, "timer_id": id, "send_time": call.params.Get("send_time")}, 0
+		case "timer/cancel":
+			switch id := call.params.Get("timer_id"); id {
+			case "timer-2":
+				return nil, 41004
+			case "timer-3":
+				return nil, 41005
+			default:
+				return map[string]interface{}{}, 0
+			}
+		case "timer/query_list":
+			start, _ := strconv.Atoi(call.params.Get("start"))
+			result := []map[string]interface{}{}
+			// one task a page
+			if start < len(onService) {
+				result = append(result, map[string]interface{}{"timer_id": onService[start], "msg": "m", "send_time": 1, "msg_type": 1, "range_type": 0})
+			}
+			return map[string]interface{}{"total_num": len(onService), "result": result}, 0
+		}
+		return nil, 30602
+	})
+
+	now := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "timers.log")
+	m, err := OpenTimerManager(path, NewChannel(srv.host(), "key", "secret", AndroidDeviceType))
+	if err != nil {
+		t.Fatal("open timer manager error", err)
+	}
+	m.now = func() time.Time { return now }
+
+	if _, err = m.PushAll("spring", "hello", now.Add(30*time.Second), nil); err == nil {
+		t.Error("timer within a minute created")
+	}
+	for i, label := range []string{"spring", "spring", "spring", "summer"} {
+		sendTime := now.Add(time.Duration(i+1) * time.Hour)
+		var err error
+		if label == "summer" {
+			_, err = m.PushTag(label, "vip", "hello", sendTime, nil)
+		} else {
+			_, err = m.PushAll(label, "hello", sendTime, nil)
+		}
+		if err != nil {
+			t.Fatal("create timer error", err)
+		}
+	}
+	if call := srv.received()[0]; call.params.Get("send_time") != strconv.FormatInt(now.Add(time.Hour).Unix(), 10) {
+		t.Errorf("send time %s", call.params.Get("send_time"))
+	}
+	if spring := m.Timers("spring"); len(spring) != 3 || spring[0].TimerID != "timer-1" || spring[0].MsgID != "msg-timer-1" {
+		t.Errorf("spring timers %+v", spring)
+	}
+
+	canceled, err := m.CancelLabel("spring")
+	if err != nil {
+		t.Fatal("cancel label error", err)
+	}
+	statuses := []TimerStatus{}
+	for _, r := range canceled {
+		statuses = append(statuses, r.Status)
+	}
+	if fmt.Sprint(statuses) != "[canceled executing executed]" {
+		t.Errorf("statuses %v", statuses)
+	}
+
+	// timer-2 has been executed and timer-4 canceled by someone else, only
+	// the foreign task is left on the service
+	mu.Lock()
+	onService = []string{"foreign"}
+	mu.Unlock()
+	now = now.Add(150 * time.Minute)
+	m.Close()
+
+	m, err = OpenTimerManager(path, NewChannel(srv.host(), "key", "secret", AndroidDeviceType))
+	if err != nil {
+		t.Fatal("reopen timer manager error", err)
+	}
+	m.now = func() time.Time { return now }
+	rec, err := m.Reconcile()
+	if err != nil {
+		t.Fatal("reconcile error", err)
+	}
+	if len(rec.Unknown) != 1 || rec.Unknown[0].ID != "foreign" {
+		t.Errorf("unknown %+v want foreign", rec.Unknown)
+	}
+	if len(rec.Updated) != 2 || rec.Updated[0].TimerID != "timer-2" || rec.Updated[0].Status != TimerExecuted ||
+		rec.Updated[1].TimerID != "timer-4" || rec.Updated[1].Status != TimerCanceled {
+		t.Errorf("updated %+v want timer-2 executed and timer-4 canceled", rec.Updated)
+	}
+	if _, err = m.CancelBetween(now, now.Add(time.Hour)); err != nil {
+		t.Error("cancel nothing error", err)
+	}
+}
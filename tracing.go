@@ -0,0 +1,82 @@
+package baidupush
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/leesper/baidupush-golang"
+
+// WithTracerProvider makes channel create a client span named like
+// "baidupush.push.single_device" for every API call, the span context is
+// injected into the HTTP request by the global propagator. Nothing is traced
+// by default.
+func WithTracerProvider(tp trace.TracerProvider) ChannelOption {
+	return func(bc *Channel) {
+		bc.tracer = tp.Tracer(tracerName)
+	}
+}
+
+func defaultTracer() trace.Tracer {
+	return noop.NewTracerProvider().Tracer(tracerName)
+}
+
+func (bc *Channel) startSpan(apiClass, apiMethod string, query url.Values) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.Int("baidupush.device_type", bc.deviceType),
+	}
+	if msgType, err := strconv.Atoi(query.Get("msg_type")); err == nil {
+		attrs = append(attrs, attribute.Int("baidupush.msg_type", msgType))
+	}
+	if topicID := query.Get("topic_id"); topicID != "" {
+		attrs = append(attrs, attribute.String("baidupush.topic_id", topicID))
+	}
+	if tag := query.Get("tag"); tag != "" {
+		attrs = append(attrs, attribute.String("baidupush.tag", tag))
+	}
+	if num := channelIDsNumber(query); num > 0 {
+		attrs = append(attrs, attribute.Int("baidupush.channel_ids", num))
+	}
+
+	return bc.tracer.Start(bc.ctx, fmt.Sprintf("baidupush.%s.%s", apiClass, apiMethod),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span of a call, err is recorded redacted like in logs.
+func endSpan(span trace.Span, requestID int64, code int, secret string, err error) {
+	if requestID != 0 {
+		span.SetAttributes(attribute.Int64("baidupush.request_id", requestID))
+	}
+	if code != 0 {
+		span.SetAttributes(attribute.Int("baidupush.error_code", code))
+	}
+	if err != nil {
+		msg := redactError(err, secret)
+		span.AddEvent("exception", trace.WithAttributes(
+			attribute.String("exception.type", fmt.Sprintf("%T", err)),
+			attribute.String("exception.message", msg)))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
+
+// channelIDsNumber returns the number of devices query is about.
+func channelIDsNumber(query url.Values) int {
+	if query.Get("channel_id") != "" {
+		return 1
+	}
+	channelIDs := []string{}
+	if err := json.Unmarshal([]byte(query.Get("channel_ids")), &channelIDs); err != nil {
+		return 0
+	}
+	return len(channelIDs)
+}
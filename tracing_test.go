@@ -0,0 +1,140 @@
+package baidupush
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func spanAttrs(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	srv := newFakeServer(t, func(call fakeCall) (interface{}, int) {
+		if call.apiMethod == "cancel" {
+			return nil, 41005
+		}
+		return pushOK(call)
+	})
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	bc := NewChannel(srv.host(), "key", "secret", AppleDeviceType, WithTracerProvider(tp))
+
+	opts := url.Values{"msg_type": {"1"}, "topic_id": {"topic"}}
+	if _, _, err := bc.PushMsgToBatchDevices([]string{"chn1", "chn2"}, "hello", opts); err != nil {
+		t.Fatal("push to batch error", err)
+	}
+	if err := bc.CancelTimerTask("timer"); err == nil {
+		t.Fatal("cancel timer succeeded, want error")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("spans %d want 2", len(spans))
+	}
+
+	push := spans[0]
+	if push.Name != "baidupush.push.batch_device" {
+		t.Errorf("span name %s want baidupush.push.batch_device", push.Name)
+	}
+	if push.SpanKind != trace.SpanKindClient {
+		t.Errorf("span kind %v want client", push.SpanKind)
+	}
+	attrs := spanAttrs(push)
+	if attrs["baidupush.device_type"].AsInt64() != AppleDeviceType {
+		t.Errorf("device type %v want %d", attrs["baidupush.device_type"], AppleDeviceType)
+	}
+	if attrs["baidupush.msg_type"].AsInt64() != MsgTypeNotice {
+		t.Errorf("msg type %v want %d", attrs["baidupush.msg_type"], MsgTypeNotice)
+	}
+	if attrs["baidupush.topic_id"].AsString() != "topic" {
+		t.Errorf("topic ID %v want topic", attrs["baidupush.topic_id"])
+	}
+	if attrs["baidupush.channel_ids"].AsInt64() != 2 {
+		t.Errorf("channel IDs %v want 2", attrs["baidupush.channel_ids"])
+	}
+	if attrs["baidupush.request_id"].AsInt64() != 1 {
+		t.Errorf("request ID %v want 1", attrs["baidupush.request_id"])
+	}
+
+	traceparent := srv.received()[0].header.Get("Traceparent")
+	if want := push.SpanContext.TraceID().String(); len(traceparent) < 35 || traceparent[3:35] != want {
+		t.Errorf("traceparent %q want trace ID %s", traceparent, want)
+	}
+
+	cancel := spans[1]
+	if cancel.Name != "baidupush.timer.cancel" {
+		t.Errorf("span name %s want baidupush.timer.cancel", cancel.Name)
+	}
+	if cancel.Status.Code != codes.Error {
+		t.Errorf("status %v want error", cancel.Status.Code)
+	}
+	if code := spanAttrs(cancel)["baidupush.error_code"].AsInt64(); code != 41005 {
+		t.Errorf("error code %d want 41005", code)
+	}
+}
+
+func TestTracingParentContext(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "job")
+	bc := NewChannel(srv.host(), "key", "secret", AndroidDeviceType, WithTracerProvider(tp), WithParentContext(ctx))
+
+	if _, _, err := bc.PushMsgToSingleDevice("chn", "hello", nil); err != nil {
+		t.Fatal("push to single error", err)
+	}
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("spans %d want 2", len(spans))
+	}
+	if got, want := spans[0].Parent.SpanID(), parent.SpanContext().SpanID(); got != want {
+		t.Errorf("parent span %s want %s", got, want)
+	}
+}
+
+func TestTracingRedactsTransportError(t *testing.T) {
+	srv := newFakeServer(t, pushOK)
+	srv.Close()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	bc := NewChannel(srv.host(), "the-api-key", "secret", AndroidDeviceType, WithTracerProvider(tp))
+
+	if _, err := bc.GetTagDevicesNumber("tag"); err == nil {
+		t.Fatal("get tag devices number succeeded, want error")
+	}
+
+	span := exporter.GetSpans()[0]
+	texts := []string{span.Status.Description}
+	for _, event := range span.Events {
+		for _, kv := range event.Attributes {
+			texts = append(texts, kv.Value.Emit())
+		}
+	}
+	for _, text := range texts {
+		for _, leaked := range []string{"the-api-key", "apikey=", "sign="} {
+			if strings.Contains(text, leaked) {
+				t.Errorf("span %q leaks %s", text, leaked)
+			}
+		}
+	}
+}